@@ -14,10 +14,79 @@ import (
 type Options struct {
 	fileName   string
 	memorySize uint
+	sparse     bool
 	tracing    bool
 	gui        bool
 	exec       bool
 	logFile    string
+
+	haltOnUndefined   bool
+	alignmentChecking bool
+	traceCycles       bool
+	pipeline          bool
+
+	permissionChecking bool
+	haltOnFault        bool
+	regions            regionList
+
+	icache cacheFlag
+	dcache cacheFlag
+
+	watchpoints watchList
+
+	loadSnapshot string
+	saveSnapshot string
+	snapshotDir  string
+}
+
+// A list of memory regions given with --region (which may be repeated)
+type regionList []armsim.Region
+
+func (r *regionList) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *regionList) Set(s string) (err error) {
+	region, err := armsim.ParseRegion(s)
+	if err == nil {
+		*r = append(*r, region)
+	}
+	return
+}
+
+// A list of watchpoints given with --watch (which may be repeated)
+type watchList []armsim.Watchpoint
+
+func (w *watchList) String() string {
+	return fmt.Sprint(*w)
+}
+
+func (w *watchList) Set(s string) (err error) {
+	watchpoint, err := armsim.ParseWatchpoint(s)
+	if err == nil {
+		*w = append(*w, watchpoint)
+	}
+	return
+}
+
+// A cache configuration given with --icache or --dcache
+type cacheFlag struct {
+	config *armsim.CacheConfig
+}
+
+func (f *cacheFlag) String() string {
+	if f.config == nil {
+		return ""
+	}
+	return f.config.String()
+}
+
+func (f *cacheFlag) Set(s string) (err error) {
+	config, err := armsim.ParseCacheConfig(s)
+	if err == nil {
+		f.config = &config
+	}
+	return
 }
 
 func main() {
@@ -50,7 +119,12 @@ func main() {
 	}
 
 	// Initialize Computer
-	c := armsim.NewComputer(uint32(options.memorySize), logFile)
+	var c *armsim.Computer
+	if options.sparse {
+		c = armsim.NewComputerWithMemory(armsim.NewSparseMemory(logFile), logFile)
+	} else {
+		c = armsim.NewComputer(uint32(options.memorySize), logFile)
+	}
 
 	// Setup channels
 	halting := make(chan bool, 1)
@@ -63,6 +137,44 @@ func main() {
 		defer c.DisableTracing()
 	}
 
+	if options.haltOnUndefined {
+		c.EnableHaltOnUndefined()
+	}
+
+	if options.alignmentChecking {
+		c.EnableAlignmentChecking()
+	}
+
+	if options.traceCycles {
+		c.EnableCycleTracing()
+	}
+
+	if options.pipeline {
+		c.EnablePipeline()
+	}
+
+	// Regions are enforced along with the segments of the ELF file
+	for _, region := range options.regions {
+		c.AddRegion(region)
+	}
+	if options.permissionChecking || len(options.regions) > 0 {
+		c.EnablePermissionChecking()
+	}
+	if options.haltOnFault {
+		c.EnableHaltOnFault()
+	}
+
+	if options.icache.config != nil {
+		c.EnableInstructionCache(*options.icache.config)
+	}
+	if options.dcache.config != nil {
+		c.EnableDataCache(*options.dcache.config)
+	}
+
+	for _, w := range options.watchpoints {
+		c.AddWatchpoint(w)
+	}
+
 	// Load ELF File
 	if options.fileName != "" {
 		err = c.LoadELF(options.fileName)
@@ -74,6 +186,15 @@ func main() {
 		}
 	}
 
+	// Restore a snapshot (over the ELF file, if any)
+	if options.loadSnapshot != "" {
+		if err = loadSnapshot(c, options.loadSnapshot); err != nil {
+			fmt.Println("Unable to load snapshot. Encountered error -", err)
+			return
+		}
+		fmt.Println("Loaded snapshot - checksum is", c.Checksum())
+	}
+
 	if options.gui {
 		log.Println("Loading webserver...")
 		fmt.Println("Please open your web browser to http://localhost:4567/ to see the gui.")
@@ -82,13 +203,70 @@ func main() {
 		cmd := exec.Command("firefox", "http://localhost:4567/")
 		cmd.Start()
 
-		s := web.Server{c, options.fileName, halting, finishing, nil, c.Keyboard, c.Console}
+		s := web.Server{
+			Computer:    c,
+			FilePath:    options.fileName,
+			Halt:        halting,
+			Finished:    finishing,
+			Keyboard:    c.Keyboard,
+			Console:     c.Console,
+			SnapshotDir: options.snapshotDir,
+		}
 		// Launch the webserver
 		s.Launch(logFile)
 	} else if options.exec {
 		// Run the program
 		c.Run(halting, finishing)
+		if err = c.Err(); err != nil {
+			fmt.Println("Halted -", err)
+		}
+		if hit := c.WatchHit(); hit != nil {
+			fmt.Println("Stopped -", hit)
+		}
+		if options.saveSnapshot != "" {
+			if err = saveSnapshot(c, options.saveSnapshot); err != nil {
+				fmt.Println("Unable to save snapshot. Encountered error -", err)
+			} else {
+				fmt.Println("Saved snapshot to", options.saveSnapshot)
+			}
+		}
+		cycles := c.Cycles()
+		fmt.Printf("Executed %d instructions in %d cycles (%s)\n", c.Status().Steps-1,
+			cycles.Total(), cycles)
+
+		icache, dcache := c.Caches()
+		if icache != nil {
+			fmt.Printf("I-cache (%s): %s\n", icache.Config(), icache.Statistics())
+		}
+		if dcache != nil {
+			fmt.Printf("D-cache (%s): %s\n", dcache.Config(), dcache.Statistics())
+		}
+	}
+}
+
+// Restores a snapshot from a file.
+func loadSnapshot(c *armsim.Computer, path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return c.LoadSnapshot(file)
+}
+
+// Saves a snapshot to a file.
+func saveSnapshot(c *armsim.Computer, path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+
+	if err = c.SaveSnapshot(file); err != nil {
+		file.Close()
+		return
 	}
+	return file.Close()
 }
 
 func processFlags() (options *Options, err error) {
@@ -97,30 +275,44 @@ func processFlags() (options *Options, err error) {
 
 	// Define Options
 	flag.UintVar(&options.memorySize, "mem", 32768, "RAM size in bytes (1MB max)")
+	flag.BoolVar(&options.sparse, "sparse", false, "Use sparse memory covering the 32-bit address space (ignores --mem)")
 	flag.StringVar(&options.fileName, "load", "", "ELF File Name")
 	flag.StringVar(&options.logFile, "log", "", "Log file")
 	flag.BoolVar(&options.tracing, "trace", true, "Output trace.log file (default=enabled)")
 	flag.BoolVar(&options.gui, "gui", true, "Use gui instead of command line")
 	flag.BoolVar(&options.exec, "exec", false, "Load file, execute and then close program (requires --load)")
+	flag.BoolVar(&options.haltOnUndefined, "halt-undefined", false, "Halt on undefined instructions instead of taking the exception")
+	flag.BoolVar(&options.alignmentChecking, "strict-alignment", false, "Raise a data abort on unaligned halfword and word accesses")
+	flag.BoolVar(&options.traceCycles, "trace-cycles", false, "Append cycle counts to each trace line")
+	flag.BoolVar(&options.pipeline, "pipeline", false, "Step through the fetch, decode and execute stages one cycle at a time")
+	flag.BoolVar(&options.permissionChecking, "enforce-permissions", false, "Enforce the read/write/execute permissions of the ELF segments")
+	flag.Var(&options.regions, "region", "Add a memory region as name:base:size:permissions, e.g. rom:0x0:0x1000:r-x (implies --enforce-permissions, may be repeated)")
+	flag.BoolVar(&options.haltOnFault, "halt-fault", false, "Halt with a report on permission faults instead of taking the abort exception")
+	flag.Var(&options.watchpoints, "watch", "Stop when RAM is accessed, as kind:address[:size[:value]] with kind read, write, change or value, e.g. write:0x7000:4 (may be repeated)")
+	flag.StringVar(&options.loadSnapshot, "load-snapshot", "", "Restore a snapshot file after loading the ELF file (if any)")
+	flag.StringVar(&options.saveSnapshot, "save-snapshot", "", "Save a snapshot file when --exec finishes")
+	flag.StringVar(&options.snapshotDir, "snapshot-dir", "snapshots", "Directory the gui saves and loads snapshots in")
+	flag.Var(&options.icache, "icache", "Enable an instruction cache as size:linesize:ways[:lru|fifo|random[:wb|wt]], e.g. 4096:32:2")
+	flag.Var(&options.dcache, "dcache", "Enable a data cache as size:linesize:ways[:lru|fifo|random[:wb|wt]], e.g. 4096:32:2:lru:wb")
 
 	// Parse Options
 	flag.Parse()
 
 	// Validate Options
 	log.Println("RAM Size:", options.memorySize)
-	if options.memorySize > 1048576 {
-		err = errors.New("RAM size is too large. Must be under 1MB (1048576).")
+	if options.memorySize > 1048576 && !options.sparse {
+		err = errors.New("RAM size is too large. Must be under 1MB (1048576), or use --sparse.")
 		return
 	}
 
-	if options.exec && options.fileName != "" {
+	if options.exec && (options.fileName != "" || options.loadSnapshot != "") {
 		options.gui = false
 	}
 
 	if !options.gui {
 		log.Println("File name:", options.fileName)
-		if options.fileName == "" {
-			err = errors.New("Please specify a file name.")
+		if options.fileName == "" && options.loadSnapshot == "" {
+			err = errors.New("Please specify a file name (or a snapshot).")
 			return
 		}
 	}
@@ -46,12 +46,21 @@ func NewFromOperand2(operand2 uint32, i bool, cpu *CPU) (b *BarrelShifter) {
 		data, _ = cpu.FetchRegisterFromInstruction(rn)
 		shift = ExtractShiftBits(operand2, 5, 7)
 		if (operand2 & 0x10) == 0 {
-			// Immediate shift
+			// Immediate shift (LSR #0 and ASR #0 encode a shift by 32)
 			shift_amount = ExtractShiftBits(operand2, 7, 12)
+			if shift_amount == 0 && (shift == LSR || shift == ASR) {
+				shift_amount = 32
+			}
 		} else {
-			// Register shift
+			// Register shift (only the bottom byte of Rs is used)
 			rs = ExtractShiftBits(operand2, 8, 12)
 			shift_amount, _ = cpu.FetchRegisterFromInstruction(rs)
+			shift_amount &= 0xFF
+
+			// The PC reads one instruction further ahead with a register shift
+			if rn == 15 {
+				data += 4
+			}
 		}
 	}
 
@@ -60,21 +69,77 @@ func NewFromOperand2(operand2 uint32, i bool, cpu *CPU) (b *BarrelShifter) {
 	return
 }
 
-// Shifts the data and returns the result.
+// Shifts the data and returns the result. The carry flag is treated as clear
+// (it only matters for RRX); use ShiftWithCarry to take it into account.
 func (b *BarrelShifter) Shift() (result uint32) {
+	result, _ = b.ShiftWithCarry(false)
+	return
+}
+
+// Shifts the data and returns the result along with the shifter carry-out (as
+// defined by the ARM ARM's addressing mode 1).
+//
+// Parameters:
+//  carryIn - the current state of the CPSR C flag
+//
+// Returns:
+//  result - the shifted value
+//  carryOut - the last bit shifted out (or carryIn when nothing is shifted)
+func (b *BarrelShifter) ShiftWithCarry(carryIn bool) (result uint32, carryOut bool) {
+	n := b.ShiftAmount
+	result, carryOut = b.Data, carryIn
+	bit := func(i uint32) bool { return (b.Data>>i)&1 == 1 }
+
+	// RRX is encoded as an immediate ROR #0
+	if b.isRRX() {
+		result = b.Data >> 1
+		if carryIn {
+			result |= 0x80000000
+		}
+		return result, bit(0)
+	}
+
+	if n == 0 {
+		return
+	}
+
 	switch b.Type {
-	case ROR:
-		result = ror(b.Data, b.ShiftAmount)
 	case LSL:
-		result = b.Data << b.ShiftAmount
+		switch {
+		case n < 32:
+			result, carryOut = b.Data<<n, bit(32-n)
+		case n == 32:
+			result, carryOut = 0, bit(0)
+		default:
+			result, carryOut = 0, false
+		}
 	case LSR:
-		result = b.Data >> b.ShiftAmount
+		switch {
+		case n < 32:
+			result, carryOut = b.Data>>n, bit(n-1)
+		case n == 32:
+			result, carryOut = 0, bit(31)
+		default:
+			result, carryOut = 0, false
+		}
 	case ASR:
-		result = asr(b.Data, b.ShiftAmount)
+		if n >= 32 {
+			n = 32
+		}
+		result, carryOut = asr(b.Data, n), bit(n-1)
+	case ROR:
+		// Rotating by a multiple of 32 leaves the value unchanged
+		result = ror(b.Data, n%32)
+		carryOut = result>>31 == 1
 	}
 	return
 }
 
+// Checks whether the shift is an RRX (an immediate shift encoded as ROR #0)
+func (b *BarrelShifter) isRRX() bool {
+	return !b.i && b.Rs > 15 && b.Type == ROR && b.ShiftAmount == 0
+}
+
 // Returns value of the Rs register
 func (b *BarrelShifter) GetRs() (rs uint32) {
 	return b.ShiftAmount
@@ -103,7 +168,11 @@ func (b *BarrelShifter) Disassemble() (operands string) {
 		}
 		if b.Rs < 16 {
 			// Register shift
-			data = fmt.Sprintf("r%d", b.GetRs())
+			data = fmt.Sprintf("r%d", b.Rs)
+		} else if b.isRRX() {
+			operands = fmt.Sprintf("r%d, rrx", b.Rn)
+			b.log.Println(operands)
+			return
 		} else {
 			// Immediate shift
 			data = fmt.Sprintf("#%d", b.ShiftAmount)
@@ -72,3 +72,71 @@ func TestBDisassemble(t *testing.T) {
 		t.Fatal("Expected 'lsr #2', got", a)
 	}
 }
+
+func TestShiftWithCarry(t *testing.T) {
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(r1, 0x80000001)
+
+	tests := []struct {
+		operand2 uint32
+		rs       uint32
+		carryIn  bool
+		result   uint32
+		carry    bool
+	}{
+		{0x001, 0, true, 0x80000001, true},      // r1 (lsl #0 keeps the carry)
+		{0x081, 0, false, 0x00000002, true},     // r1, lsl #1
+		{0x021, 0, false, 0x00000000, true},     // r1, lsr #32
+		{0x041, 0, false, 0xFFFFFFFF, true},     // r1, asr #32
+		{0x061, 0, false, 0x40000000, true},     // r1, rrx
+		{0x061, 0, true, 0xC0000000, true},      // r1, rrx
+		{0x0E1, 0, false, 0xC0000000, true},     // r1, ror #1
+		{0x211, 0, true, 0x80000001, true},      // r1, lsl r2 (r2 = 0)
+		{0x211, 32, false, 0x00000000, true},    // r1, lsl r2 (r2 = 32)
+		{0x211, 33, true, 0x00000000, false},    // r1, lsl r2 (r2 = 33)
+		{0x231, 32, false, 0x00000000, true},    // r1, lsr r2 (r2 = 32)
+		{0x231, 33, true, 0x00000000, false},    // r1, lsr r2 (r2 = 33)
+		{0x251, 40, false, 0xFFFFFFFF, true},    // r1, asr r2 (r2 = 40)
+		{0x271, 32, false, 0x80000001, true},    // r1, ror r2 (r2 = 32)
+		{0x271, 33, false, 0xC0000000, true},    // r1, ror r2 (r2 = 33)
+		{0x211, 0x101, false, 0x00000002, true}, // r1, lsl r2 (only r2[7:0] is used)
+	}
+	for _, test := range tests {
+		c.registers.WriteWord(r2, test.rs)
+		b := NewFromOperand2(test.operand2, false, c.cpu)
+		result, carry := b.ShiftWithCarry(test.carryIn)
+		if result != test.result || carry != test.carry {
+			t.Errorf("%#03x (rs %d): expected %#x/%t, got %#x/%t", test.operand2, test.rs,
+				test.result, test.carry, result, carry)
+		}
+	}
+
+	// Immediate operands only change the carry when rotated
+	b := NewFromOperand2(0x0FF, true, c.cpu)
+	if result, carry := b.ShiftWithCarry(true); result != 0xFF || !carry {
+		t.Errorf("expected 0xff/true, got %#x/%t", result, carry)
+	}
+	b = NewFromOperand2(0x4FF, true, c.cpu)
+	if result, carry := b.ShiftWithCarry(false); result != 0xFF000000 || !carry {
+		t.Errorf("expected 0xff000000/true, got %#x/%t", result, carry)
+	}
+}
+
+func TestShiftDisassemble(t *testing.T) {
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(r2, 7)
+
+	tests := map[uint32]string{
+		0x061: "r1, rrx",
+		0x021: "r1, lsr #32",
+		0x041: "r1, asr #32",
+		0x0E1: "r1, ror #1",
+		0x211: "r1, lsl r2",
+		0x271: "r1, ror r2",
+	}
+	for operand2, expected := range tests {
+		if a := NewFromOperand2(operand2, false, c.cpu).Disassemble(); a != expected {
+			t.Errorf("%#03x: expected %q, got %q", operand2, expected, a)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+// Filename: bus.go
+// Contents: The Bus struct, which maps devices (RAM, ROM and peripherals) into
+//	the address space, and the Device interface with the console and keyboard
+//	devices.
+
+package armsim
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Addresses of the default console and keyboard devices
+const (
+	ConsoleAddress  = 0x100000
+	KeyboardAddress = 0x100001
+)
+
+// A Device is anything that can be mapped into the address space. Accesses are
+// passed the offset from the base address of the device's mapping, and an
+// error raises a Data Abort (or a Prefetch Abort for a fetch). A *Memory is a
+// Device.
+type Device interface {
+	LoadByte(offset uint32) (data byte, err error)
+	StoreByte(offset uint32, data byte) (err error)
+	ReadHalfWord(offset uint32) (data uint16, err error)
+	WriteHalfWord(offset uint32, data uint16) (err error)
+	ReadWord(offset uint32) (data uint32, err error)
+	WriteWord(offset uint32, data uint32) (err error)
+}
+
+// A device mapped into a range of addresses
+type Mapping struct {
+	Name   string
+	Base   uint32
+	Size   uint32
+	Device Device
+}
+
+// A Bus routes each access to the device mapped at its address. The device
+// handles the whole access, so a halfword or word access that starts in a
+// device's range never reaches the next device. A Bus is itself a Device.
+type Bus struct {
+	mappings  []Mapping // Sorted by base address
+	bigEndian bool
+	log       *log.Logger
+}
+
+// Initializes an empty Bus
+//
+// Parameters:
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns: A pointer to the newly created Bus
+func NewBus(logOut io.Writer) (b *Bus) {
+	b = new(Bus)
+
+	// Setup logging
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+	b.log = log.New(logOut, "Bus: ", 0)
+
+	return
+}
+
+// Maps a device into a range of addresses. The device takes on the byte
+// order of the bus if it has one (see SetBigEndian).
+//
+// Parameters:
+//  name - a name for the mapping (e.g., "RAM")
+//  base - the first address of the range
+//  size - the size of the range in bytes
+//  device - the device to map
+//
+// Returns:
+//  err - an error if the range is empty or overlaps another mapping
+func (b *Bus) Map(name string, base, size uint32, device Device) (err error) {
+	if size == 0 || base+size-1 < base {
+		err = fmt.Errorf("Invalid range for %s (0x%08X, %d bytes).", name, base, size)
+		b.log.Println(err)
+		return
+	}
+
+	i := 0
+	for ; i < len(b.mappings); i++ {
+		m := b.mappings[i]
+		if base <= m.Base+m.Size-1 && m.Base <= base+size-1 {
+			err = fmt.Errorf("%s overlaps %s at 0x%08X.", name, m.Name, m.Base)
+			b.log.Println(err)
+			return
+		}
+		if base < m.Base {
+			break
+		}
+	}
+
+	// Insert in order
+	b.mappings = append(b.mappings, Mapping{})
+	copy(b.mappings[i+1:], b.mappings[i:])
+	b.mappings[i] = Mapping{name, base, size, device}
+	b.log.Printf("Mapped %s at %#08x (%d bytes)", name, base, size)
+
+	if d, ok := device.(interface {
+		SetBigEndian(bool)
+	}); ok {
+		d.SetBigEndian(b.bigEndian)
+	}
+
+	return
+}
+
+// Removes the mapping of a device.
+//
+// Parameters:
+//  name - the name the device was mapped with
+//
+// Returns:
+//  err - an error if there is no mapping with that name
+func (b *Bus) Unmap(name string) (err error) {
+	for i, m := range b.mappings {
+		if m.Name == name {
+			b.mappings = append(b.mappings[:i], b.mappings[i+1:]...)
+			return
+		}
+	}
+
+	return fmt.Errorf("No device named %s is mapped.", name)
+}
+
+// Returns the mappings, sorted by base address.
+func (b *Bus) Mappings() (mappings []Mapping) {
+	return append(mappings, b.mappings...)
+}
+
+// Sets the byte order of every mapped device that has one (such as a Memory).
+//
+// Parameters:
+//  bigEndian - true for big-endian, false for little-endian
+//
+// Returns: None
+func (b *Bus) SetBigEndian(bigEndian bool) {
+	b.bigEndian = bigEndian
+	for _, m := range b.mappings {
+		if d, ok := m.Device.(interface {
+			SetBigEndian(bool)
+		}); ok {
+			d.SetBigEndian(bigEndian)
+		}
+	}
+}
+
+// Returns true if the bus is big-endian.
+func (b *Bus) BigEndian() (bigEndian bool) {
+	return b.bigEndian
+}
+
+// Reads a byte from the device mapped at an address.
+func (b *Bus) LoadByte(address uint32) (data byte, err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.LoadByte(address - m.Base)
+}
+
+// Writes a byte to the device mapped at an address.
+func (b *Bus) StoreByte(address uint32, data byte) (err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.StoreByte(address-m.Base, data)
+}
+
+// Reads a halfword from the device mapped at an address.
+func (b *Bus) ReadHalfWord(address uint32) (data uint16, err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.ReadHalfWord(address - m.Base)
+}
+
+// Writes a halfword to the device mapped at an address.
+func (b *Bus) WriteHalfWord(address uint32, data uint16) (err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.WriteHalfWord(address-m.Base, data)
+}
+
+// Reads a word from the device mapped at an address.
+func (b *Bus) ReadWord(address uint32) (data uint32, err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.ReadWord(address - m.Base)
+}
+
+// Writes a word to the device mapped at an address.
+func (b *Bus) WriteWord(address uint32, data uint32) (err error) {
+	m, err := b.find(address)
+	if err != nil {
+		return
+	}
+	return m.Device.WriteWord(address-m.Base, data)
+}
+
+// Helpers
+
+// Finds the mapping containing an address. Returns an error if no device is
+// mapped there.
+func (b *Bus) find(address uint32) (m *Mapping, err error) {
+	for i := range b.mappings {
+		m = &b.mappings[i]
+		if m.Base <= address && address-m.Base < m.Size {
+			return
+		}
+	}
+
+	err = fmt.Errorf("No device mapped at address 0x%08X.", address)
+	b.log.Println(err)
+	return nil, err
+}
+
+// A window onto a device starting at an offset, to map the part of a device
+// above another mapping (such as RAM above the console and keyboard)
+type window struct {
+	device Device
+	offset uint32
+}
+
+func (w *window) LoadByte(offset uint32) (data byte, err error) {
+	return w.device.LoadByte(w.offset + offset)
+}
+
+func (w *window) StoreByte(offset uint32, data byte) (err error) {
+	return w.device.StoreByte(w.offset+offset, data)
+}
+
+func (w *window) ReadHalfWord(offset uint32) (data uint16, err error) {
+	return w.device.ReadHalfWord(w.offset + offset)
+}
+
+func (w *window) WriteHalfWord(offset uint32, data uint16) (err error) {
+	return w.device.WriteHalfWord(w.offset+offset, data)
+}
+
+func (w *window) ReadWord(offset uint32) (data uint32, err error) {
+	return w.device.ReadWord(w.offset + offset)
+}
+
+func (w *window) WriteWord(offset uint32, data uint32) (err error) {
+	return w.device.WriteWord(w.offset+offset, data)
+}
+
+// A ROM is a read-only Memory; writes return an error (and so abort).
+type ROM struct {
+	*Memory
+}
+
+// Initializes a ROM with its contents
+//
+// Parameters:
+//  contents - the bytes of the ROM (its size is len(contents))
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns: A pointer to the newly created ROM
+func NewROM(contents []byte, logOut io.Writer) (r *ROM) {
+	r = &ROM{NewMemory(uint32(len(contents)), logOut)}
+	copy(r.memory, contents)
+	return
+}
+
+// Refuses to write a byte.
+func (r *ROM) StoreByte(offset uint32, data byte) (err error) {
+	return r.readOnly(offset)
+}
+
+// Refuses to write a halfword.
+func (r *ROM) WriteHalfWord(offset uint32, data uint16) (err error) {
+	return r.readOnly(offset)
+}
+
+// Refuses to write a word.
+func (r *ROM) WriteWord(offset uint32, data uint32) (err error) {
+	return r.readOnly(offset)
+}
+
+// Returns the error for a write to ROM.
+func (r *ROM) readOnly(offset uint32) (err error) {
+	err = fmt.Errorf("Attempted to write to ROM (offset 0x%08X).", offset)
+	r.log.Println(err)
+	return
+}
+
+// A Console is a write-only device that buffers each byte written to it until
+// the output is read (see Read). Halfword and word writes buffer their low
+// byte, and reads return 0.
+type Console struct {
+	mutex  sync.Mutex
+	buffer []byte    // Output that hasn't been read yet
+	ready  chan bool // Signalled when output is written (see Ready)
+	log    *log.Logger
+}
+
+// Initializes a Console
+//
+// Parameters:
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns: A pointer to the newly created Console
+func NewConsole(logOut io.Writer) (c *Console) {
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+	return &Console{ready: make(chan bool, 1), log: log.New(logOut, "Console: ", 0)}
+}
+
+// Returns a channel that receives a value when output is written after the
+// last Read.
+func (c *Console) Ready() <-chan bool {
+	return c.ready
+}
+
+// Returns the output written since the last Read.
+//
+// Parameters: None
+//
+// Returns:
+//  data - the bytes written, in order (nil if there are none)
+func (c *Console) Read() (data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, c.buffer = c.buffer, nil
+	return
+}
+
+// Logs an attempted read and returns 0.
+func (c *Console) LoadByte(offset uint32) (data byte, err error) {
+	c.log.Printf("ERROR: Attempted to read from console...")
+	return
+}
+
+// Buffers a byte of output.
+func (c *Console) StoreByte(offset uint32, data byte) (err error) {
+	c.mutex.Lock()
+	c.buffer = append(c.buffer, data)
+	c.mutex.Unlock()
+
+	select {
+	case c.ready <- true:
+	default:
+	}
+	return
+}
+
+// Logs an attempted read and returns 0.
+func (c *Console) ReadHalfWord(offset uint32) (data uint16, err error) {
+	_, err = c.LoadByte(offset)
+	return
+}
+
+// Buffers the low byte of a halfword.
+func (c *Console) WriteHalfWord(offset uint32, data uint16) (err error) {
+	return c.StoreByte(offset, byte(data))
+}
+
+// Logs an attempted read and returns 0.
+func (c *Console) ReadWord(offset uint32) (data uint32, err error) {
+	_, err = c.LoadByte(offset)
+	return
+}
+
+// Buffers the low byte of a word.
+func (c *Console) WriteWord(offset uint32, data uint32) (err error) {
+	return c.StoreByte(offset, byte(data))
+}
+
+// Returns a copy of the unread output (for snapshots).
+func (c *Console) buffered() (data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append(data, c.buffer...)
+}
+
+// Replaces the unread output (for snapshots).
+func (c *Console) setBuffered(data []byte) {
+	c.mutex.Lock()
+	c.buffer = append([]byte(nil), data...)
+	c.mutex.Unlock()
+
+	if len(data) > 0 {
+		select {
+		case c.ready <- true:
+		default:
+		}
+	}
+}
+
+// A Keyboard is a read-only device that returns the next key pressed (see
+// Press), or 0 if none is waiting. Halfword and word reads are zero-extended,
+// and writes are ignored.
+type Keyboard struct {
+	mutex  sync.Mutex
+	buffer []byte // Keys that haven't been read yet
+	log    *log.Logger
+}
+
+// Initializes a Keyboard
+//
+// Parameters:
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns: A pointer to the newly created Keyboard
+func NewKeyboard(logOut io.Writer) (k *Keyboard) {
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+	return &Keyboard{log: log.New(logOut, "Keyboard: ", 0)}
+}
+
+// Buffers keys for the program to read.
+//
+// Parameters:
+//  keys - the keys pressed, in order
+//
+// Returns: None
+func (k *Keyboard) Press(keys ...byte) {
+	k.mutex.Lock()
+	k.buffer = append(k.buffer, keys...)
+	k.mutex.Unlock()
+}
+
+// Reads the next byte from the keyboard.
+func (k *Keyboard) LoadByte(offset uint32) (data byte, err error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if len(k.buffer) > 0 {
+		data, k.buffer = k.buffer[0], k.buffer[1:]
+	}
+	return
+}
+
+// Logs an attempted write.
+func (k *Keyboard) StoreByte(offset uint32, data byte) (err error) {
+	k.log.Printf("ERROR: Attempted to write to keyboard...")
+	return
+}
+
+// Reads the next byte from the keyboard.
+func (k *Keyboard) ReadHalfWord(offset uint32) (data uint16, err error) {
+	b, err := k.LoadByte(offset)
+	return uint16(b), err
+}
+
+// Logs an attempted write.
+func (k *Keyboard) WriteHalfWord(offset uint32, data uint16) (err error) {
+	return k.StoreByte(offset, byte(data))
+}
+
+// Reads the next byte from the keyboard.
+func (k *Keyboard) ReadWord(offset uint32) (data uint32, err error) {
+	b, err := k.LoadByte(offset)
+	return uint32(b), err
+}
+
+// Logs an attempted write.
+func (k *Keyboard) WriteWord(offset uint32, data uint32) (err error) {
+	return k.StoreByte(offset, byte(data))
+}
+
+// Returns a copy of the unread keys (for snapshots).
+func (k *Keyboard) buffered() (data []byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return append(data, k.buffer...)
+}
+
+// Replaces the unread keys (for snapshots).
+func (k *Keyboard) setBuffered(data []byte) {
+	k.mutex.Lock()
+	k.buffer = append([]byte(nil), data...)
+	k.mutex.Unlock()
+}
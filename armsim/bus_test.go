@@ -0,0 +1,116 @@
+package armsim
+
+import "testing"
+
+func TestBusMap(t *testing.T) {
+	b := NewBus(nil)
+	ram := NewMemory(0x100, nil)
+	if err := b.Map("RAM", 0x1000, 0x100, ram); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Map("Overlap", 0x10FF, 0x10, NewMemory(0x10, nil)); err == nil {
+		t.Fatal("expected an error for an overlapping mapping")
+	}
+	if err := b.Map("Empty", 0x2000, 0, ram); err == nil {
+		t.Fatal("expected an error for an empty mapping")
+	}
+	if err := b.Map("Wrap", 0xFFFFFFF0, 0x20, ram); err == nil {
+		t.Fatal("expected an error for a mapping past the end of the address space")
+	}
+	if err := b.Map("Low", 0x0, 0x10, NewMemory(0x10, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if m := b.Mappings(); len(m) != 2 || m[0].Name != "Low" || m[1].Name != "RAM" {
+		t.Fatalf("expected the mappings sorted by address, got %+v", m)
+	}
+
+	// Accesses are passed the offset into the device
+	b.WriteWord(0x1010, 0xDEADBEEF)
+	if word, _ := ram.ReadWord(0x10); word != 0xDEADBEEF {
+		t.Fatalf("expected 0xdeadbeef at offset 0x10, got %#x", word)
+	}
+	if _, err := b.LoadByte(0x1100); err == nil {
+		t.Fatal("expected an error reading an unmapped address")
+	}
+
+	// Devices take on the byte order of the bus
+	b.SetBigEndian(true)
+	if !ram.BigEndian() {
+		t.Fatal("expected the RAM to be big-endian")
+	}
+	if word, _ := b.ReadWord(0x1010); word != 0xEFBEADDE {
+		t.Fatalf("expected 0xefbeadde, got %#x", word)
+	}
+
+	if err := b.Unmap("RAM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadWord(0x1010); err == nil {
+		t.Fatal("expected an error reading an unmapped device")
+	}
+	if err := b.Unmap("RAM"); err == nil {
+		t.Fatal("expected an error unmapping a missing device")
+	}
+}
+
+func TestROM(t *testing.T) {
+	c := NewComputer(1024, nil)
+
+	// mov r0, #7; str r0, [r1]
+	rom := NewROM([]byte{0x07, 0x00, 0xA0, 0xE3, 0x00, 0x00, 0x81, 0xE5}, nil)
+	if err := c.Bus().Map("ROM", 0x200000, 8, rom); err != nil {
+		t.Fatal(err)
+	}
+	c.registers.WriteWord(PC, 0x200000)
+	c.registers.WriteWord(r1, 0x200000)
+
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 7 {
+		t.Fatal("expected r0 7, got", r)
+	}
+
+	// Stores to ROM abort
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != DataAbortVector {
+		t.Fatalf("expected pc %#x, got %#x", DataAbortVector, pc)
+	}
+	if word, _ := rom.ReadWord(0); word != 0xE3A00007 {
+		t.Fatalf("expected the ROM unchanged, got %#x", word)
+	}
+}
+
+func TestConsoleKeyboard(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x4142)
+	c.registers.WriteWord(r1, ConsoleAddress)
+	c.ram.WriteWord(0x100, 0xE5810000) // str r0, [r1]
+	c.ram.WriteWord(0x104, 0xE5D12001) // ldrb r2, [r1, #1]
+	c.ram.WriteWord(0x108, 0xE5D12001) // ldrb r2, [r1, #1]
+
+	// Word stores write their low byte
+	c.Step()
+	if b := string(c.Console.Read()); b != "B" {
+		t.Fatalf("expected \"B\" on the console, got %q", b)
+	}
+	select {
+	case <-c.Console.Ready():
+	default:
+		t.Fatal("expected the console to signal output")
+	}
+	if b := c.Console.Read(); b != nil {
+		t.Fatalf("expected the output to be read once, got %q", b)
+	}
+
+	c.Keyboard.Press('k')
+	c.Step()
+	if r, _ := c.registers.ReadWord(r2); r != 'k' {
+		t.Fatalf("expected 'k' from the keyboard, got %q", rune(r))
+	}
+
+	// An empty keyboard reads 0
+	c.Step()
+	if r, _ := c.registers.ReadWord(r2); r != 0 {
+		t.Fatal("expected 0 from the empty keyboard, got", r)
+	}
+}
@@ -0,0 +1,391 @@
+// Filename: cache.go
+// Contents: The Cache struct, a model of an L1 instruction or data cache
+//	between the CPU and RAM. Only the tags are modelled (the data is always
+//	read from and written to RAM), so a cache changes the statistics but never
+//	the results of a program.
+
+package armsim
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Replacement policies
+type Replacement uint8
+
+const (
+	LRU    Replacement = iota // Least recently used
+	FIFO                      // First in, first out (oldest line)
+	Random                    // Random line
+)
+
+// Builds a string such as "LRU".
+func (r Replacement) String() string {
+	return [...]string{"LRU", "FIFO", "Random"}[r]
+}
+
+// Write policies
+type WritePolicy uint8
+
+const (
+	WriteBack    WritePolicy = iota // Write-allocate; dirty lines are written back when evicted
+	WriteThrough                    // No write-allocate; writes always go to RAM
+)
+
+// Builds a string such as "write-back".
+func (w WritePolicy) String() string {
+	return [...]string{"write-back", "write-through"}[w]
+}
+
+// The geometry and policies of a cache
+type CacheConfig struct {
+	Size          uint32 // Total size in bytes
+	LineSize      uint32 // Line size in bytes
+	Associativity uint32 // Lines per set (Size / LineSize for fully associative)
+	Replacement   Replacement
+	WritePolicy   WritePolicy
+}
+
+// Parses a cache configuration from a string of the form
+// size:linesize:ways[:replacement[:write]] (e.g., "4096:32:2:lru:wb"). The
+// replacement policy is lru (the default), fifo or random, and the write policy
+// is wb (write-back, the default) or wt (write-through).
+//
+// Parameters:
+//  s - the string to parse
+//
+// Returns:
+//  config - the parsed configuration
+//  err - an error if the string is malformed or the geometry is invalid
+func ParseCacheConfig(s string) (config CacheConfig, err error) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 3 || len(fields) > 5 {
+		err = fmt.Errorf("Invalid cache %q (expected size:linesize:ways[:replacement[:write]]).", s)
+		return
+	}
+
+	var values [3]uint32
+	for i, name := range []string{"size", "line size", "associativity"} {
+		var v uint64
+		if v, err = strconv.ParseUint(fields[i], 0, 32); err != nil {
+			err = fmt.Errorf("Invalid cache %s: %s.", name, fields[i])
+			return
+		}
+		values[i] = uint32(v)
+	}
+	config.Size, config.LineSize, config.Associativity = values[0], values[1], values[2]
+
+	if len(fields) > 3 {
+		switch strings.ToLower(fields[3]) {
+		case "lru":
+			config.Replacement = LRU
+		case "fifo":
+			config.Replacement = FIFO
+		case "random":
+			config.Replacement = Random
+		default:
+			err = fmt.Errorf("Invalid cache replacement policy: %s.", fields[3])
+			return
+		}
+	}
+	if len(fields) > 4 {
+		switch strings.ToLower(fields[4]) {
+		case "wb":
+			config.WritePolicy = WriteBack
+		case "wt":
+			config.WritePolicy = WriteThrough
+		default:
+			err = fmt.Errorf("Invalid cache write policy: %s.", fields[4])
+			return
+		}
+	}
+
+	err = config.validate()
+	return
+}
+
+// Builds a string such as "4KB, 32-byte lines, 2-way, LRU, write-back".
+func (cfg CacheConfig) String() string {
+	size := fmt.Sprintf("%d bytes", cfg.Size)
+	if cfg.Size%1024 == 0 {
+		size = fmt.Sprintf("%dKB", cfg.Size/1024)
+	}
+	return fmt.Sprintf("%s, %d-byte lines, %d-way, %s, %s", size, cfg.LineSize,
+		cfg.Associativity, cfg.Replacement, cfg.WritePolicy)
+}
+
+// Checks that the sizes are powers of two and the lines divide into sets.
+func (cfg CacheConfig) validate() (err error) {
+	powerOfTwo := func(n uint32) bool { return n != 0 && n&(n-1) == 0 }
+
+	switch {
+	case !powerOfTwo(cfg.Size):
+		err = fmt.Errorf("Invalid cache size %d (must be a power of two).", cfg.Size)
+	case !powerOfTwo(cfg.LineSize) || cfg.LineSize < 4 || cfg.LineSize > cfg.Size:
+		err = fmt.Errorf("Invalid cache line size %d (must be a power of two from 4 to the cache size).", cfg.LineSize)
+	case !powerOfTwo(cfg.Associativity) || cfg.Associativity > cfg.Size/cfg.LineSize:
+		err = fmt.Errorf("Invalid cache associativity %d (must be a power of two up to the number of lines).", cfg.Associativity)
+	case cfg.Replacement > Random || cfg.WritePolicy > WriteThrough:
+		err = fmt.Errorf("Invalid cache policies.")
+	}
+	return
+}
+
+// Counts of cache accesses and their outcomes
+type CacheStats struct {
+	Reads      uint64 // Loads (or fetches)
+	Writes     uint64 // Stores
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64 // Valid lines replaced
+	Writebacks uint64 // Dirty lines written back to RAM (write-back only)
+}
+
+// Returns the fraction of accesses that hit (0 if there were none).
+func (s CacheStats) HitRate() (rate float64) {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Builds a string such as
+// "100 accesses, 90 hits, 10 misses (90.0% hit rate), 2 evictions, 1 writebacks".
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d accesses, %d hits, %d misses (%.1f%% hit rate), %d evictions, %d writebacks",
+		s.Reads+s.Writes, s.Hits, s.Misses, 100*s.HitRate(), s.Evictions, s.Writebacks)
+}
+
+// A cache line's tag and state
+type cacheLine struct {
+	valid  bool
+	dirty  bool
+	tag    uint32
+	used   uint64 // When the line was last accessed (for LRU)
+	filled uint64 // When the line was filled (for FIFO)
+}
+
+// A set-associative cache (see CacheConfig)
+type Cache struct {
+	config CacheConfig
+	sets   [][]cacheLine
+	stats  CacheStats
+
+	// Access counter to order the lines for replacement
+	clock uint64
+
+	// Source for random replacement (seeded the same at every reset)
+	random *rand.Rand
+
+	log *log.Logger
+}
+
+// Initializes an empty Cache
+//
+// Parameters:
+//  config - the geometry and policies of the cache
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns:
+//  cache - a pointer to the newly created Cache
+//  err - an error if the configuration is invalid
+func NewCache(config CacheConfig, logOut io.Writer) (cache *Cache, err error) {
+	if err = config.validate(); err != nil {
+		return
+	}
+
+	cache = new(Cache)
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+	cache.log = log.New(logOut, "Cache: ", 0)
+	cache.config = config
+
+	sets := config.Size / config.LineSize / config.Associativity
+	cache.sets = make([][]cacheLine, sets)
+	for i := range cache.sets {
+		cache.sets[i] = make([]cacheLine, config.Associativity)
+	}
+	cache.Reset()
+
+	return
+}
+
+// Returns the configuration of the cache.
+func (c *Cache) Config() (config CacheConfig) {
+	return c.config
+}
+
+// Returns the statistics since the last reset.
+func (c *Cache) Statistics() (stats CacheStats) {
+	return c.stats
+}
+
+// Invalidates every line and clears the statistics.
+func (c *Cache) Reset() {
+	c.invalidate()
+	c.stats = CacheStats{}
+	c.clock = 0
+	c.random = rand.New(rand.NewSource(1))
+}
+
+// Looks up the line containing an address, filling it on a miss (except for
+// writes to a write-through cache) and evicting a line from the set if it is
+// full.
+//
+// Parameters:
+//  address - the physical address accessed
+//  write - true for a store
+//
+// Returns:
+//  hit - true if the line was in the cache
+func (c *Cache) access(address uint32, write bool) (hit bool) {
+	c.clock++
+	if write {
+		c.stats.Writes++
+	} else {
+		c.stats.Reads++
+	}
+
+	set, tag := c.locate(address)
+	for i := range set {
+		if set[i].valid && set[i].tag == tag {
+			c.stats.Hits++
+			set[i].used = c.clock
+			if write && c.config.WritePolicy == WriteBack {
+				set[i].dirty = true
+			}
+			return true
+		}
+	}
+
+	c.stats.Misses++
+	if write && c.config.WritePolicy == WriteThrough {
+		return false
+	}
+
+	victim := c.victim(set)
+	if victim.valid {
+		c.stats.Evictions++
+		if victim.dirty {
+			c.stats.Writebacks++
+		}
+	}
+	*victim = cacheLine{true, write && c.config.WritePolicy == WriteBack, tag, c.clock, c.clock}
+
+	return false
+}
+
+// Chooses the line of a set to fill: an invalid line if there is one, or else
+// the line chosen by the replacement policy.
+func (c *Cache) victim(set []cacheLine) (line *cacheLine) {
+	for i := range set {
+		if !set[i].valid {
+			return &set[i]
+		}
+	}
+
+	if c.config.Replacement == Random {
+		return &set[c.random.Intn(len(set))]
+	}
+
+	line = &set[0]
+	for i := range set {
+		if c.config.Replacement == LRU && set[i].used < line.used ||
+			c.config.Replacement == FIFO && set[i].filled < line.filled {
+			line = &set[i]
+		}
+	}
+	return
+}
+
+// Returns the set an address maps to and its tag.
+func (c *Cache) locate(address uint32) (set []cacheLine, tag uint32) {
+	line := address / c.config.LineSize
+	return c.sets[line%uint32(len(c.sets))], line / uint32(len(c.sets))
+}
+
+// Returns the line holding an address (nil if it isn't cached).
+func (c *Cache) lookup(address uint32) (line *cacheLine) {
+	set, tag := c.locate(address)
+	for i := range set {
+		if set[i].valid && set[i].tag == tag {
+			return &set[i]
+		}
+	}
+	return nil
+}
+
+// Returns the line selected by a CP15 set/way index, which has the way in
+// its top bits and the set just above the line offset.
+func (c *Cache) setWay(index uint32) (line *cacheLine) {
+	set := c.sets[index/c.config.LineSize%uint32(len(c.sets))]
+	way := uint64(index) * uint64(c.config.Associativity) >> 32
+	return &set[way]
+}
+
+// Invalidates every line. Dirty lines are discarded without a writeback.
+func (c *Cache) invalidate() {
+	for _, set := range c.sets {
+		for i := range set {
+			set[i] = cacheLine{}
+		}
+	}
+}
+
+// Cleans and/or invalidates a line (if it is valid). Cleaning writes a dirty
+// line back, and invalidating discards it.
+func (c *Cache) maintain(line *cacheLine, clean, invalidate bool) {
+	if line == nil || !line.valid {
+		return
+	}
+	if clean && line.dirty {
+		c.stats.Writebacks++
+		line.dirty = false
+	}
+	if invalidate {
+		*line = cacheLine{}
+	}
+}
+
+// Records an access in a cache (if one is enabled, see cacheEnabled). Only RAM
+// and ROM are cached, so accesses to other devices (such as the console) are
+// ignored.
+//
+// Parameters:
+//  cache - the instruction or data cache (or nil)
+//  address - the physical address accessed
+//  write - true for a store
+func (cpu *CPU) cacheAccess(cache *Cache, address uint32, write bool) {
+	if cache == nil || !cpu.cacheEnabled(cache) {
+		return
+	}
+
+	m, err := cpu.bus.find(address)
+	if err != nil {
+		return
+	}
+	switch m.Device.(type) {
+	case *Memory, *ROM, *window:
+		cache.access(address, write)
+	}
+}
+
+// Returns true if a cache is enabled by the CP15 control register: the I bit
+// for the instruction cache and the C bit for the data cache. Without CP15 the
+// caches are always enabled.
+func (cpu *CPU) cacheEnabled(cache *Cache) (enabled bool) {
+	if cpu.mmu == nil {
+		return true
+	}
+	if cache == cpu.icache {
+		return cpu.mmu.control&controlI != 0
+	}
+	return cpu.mmu.control&controlC != 0
+}
@@ -0,0 +1,186 @@
+package armsim
+
+import "testing"
+
+func TestParseCacheConfig(t *testing.T) {
+	config, err := ParseCacheConfig("4096:32:2:fifo:wt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != (CacheConfig{4096, 32, 2, FIFO, WriteThrough}) {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if s := config.String(); s != "4KB, 32-byte lines, 2-way, FIFO, write-through" {
+		t.Fatal("unexpected string", s)
+	}
+	if config, _ = ParseCacheConfig("1024:16:1"); config.Replacement != LRU || config.WritePolicy != WriteBack {
+		t.Fatalf("expected LRU and write-back by default, got %+v", config)
+	}
+
+	for _, s := range []string{"4096:32", "4096:x:2", "3000:32:2", "4096:2:2", "64:16:8", "4096:32:3", "4096:32:2:mru", "4096:32:2:lru:wa"} {
+		if _, err := ParseCacheConfig(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestCacheReplacement(t *testing.T) {
+	// 2 sets of 2 lines: 0x00, 0x20 and 0x40 all map to set 0
+	sequence := []uint32{0x00, 0x20, 0x04, 0x40, 0x08, 0x20}
+
+	for _, test := range []struct {
+		replacement Replacement
+		expected    CacheStats
+	}{
+		{LRU, CacheStats{Reads: 6, Hits: 2, Misses: 4, Evictions: 2}},
+		{FIFO, CacheStats{Reads: 6, Hits: 1, Misses: 5, Evictions: 3}},
+	} {
+		cache, err := NewCache(CacheConfig{64, 16, 2, test.replacement, WriteBack}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, address := range sequence {
+			cache.access(address, false)
+		}
+		if stats := cache.Statistics(); stats != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.replacement, test.expected, stats)
+		}
+	}
+}
+
+func TestCacheWritePolicies(t *testing.T) {
+	// Write-back allocates on a write and writes the dirty line back when it is
+	// evicted
+	cache, _ := NewCache(CacheConfig{64, 16, 2, LRU, WriteBack}, nil)
+	cache.access(0x00, true)
+	cache.access(0x20, false)
+	cache.access(0x40, false)
+	expected := CacheStats{Reads: 2, Writes: 1, Misses: 3, Evictions: 1, Writebacks: 1}
+	if stats := cache.Statistics(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+
+	// Write-through doesn't allocate on a write miss
+	cache, _ = NewCache(CacheConfig{64, 16, 2, LRU, WriteThrough}, nil)
+	cache.access(0x00, true)
+	cache.access(0x00, false)
+	cache.access(0x00, true)
+	expected = CacheStats{Reads: 1, Writes: 2, Hits: 1, Misses: 2}
+	if stats := cache.Statistics(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+
+	cache.Reset()
+	if stats := cache.Statistics(); stats != (CacheStats{}) {
+		t.Fatalf("expected no statistics after a reset, got %+v", stats)
+	}
+}
+
+func TestComputerCaches(t *testing.T) {
+	c := NewComputer(1024, nil)
+	if err := c.EnableInstructionCache(CacheConfig{256, 16, 1, LRU, WriteBack}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableDataCache(CacheConfig{256, 16, 1, LRU, WriteBack}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableDataCache(CacheConfig{256, 16, 3, LRU, WriteBack}); err == nil {
+		t.Fatal("expected an error for 3-way associativity")
+	}
+
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r1, 0x200)
+	c.registers.WriteWord(r2, ConsoleAddress)
+	c.ram.WriteWord(0x100, 0xE5910000) // ldr r0, [r1]
+	c.ram.WriteWord(0x104, 0xE5910000) // ldr r0, [r1]
+	c.ram.WriteWord(0x108, 0xE5C20000) // strb r0, [r2] (uncached)
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+
+	status := c.Status()
+	if status.ICache == nil || status.DCache == nil {
+		t.Fatal("expected cache statistics in the status")
+	}
+	if status.ICache.Hits != 2 || status.ICache.Misses != 1 {
+		t.Fatalf("unexpected I-cache statistics %+v", status.ICache.CacheStats)
+	}
+	if status.DCache.Reads != 2 || status.DCache.Writes != 0 || status.DCache.Hits != 1 {
+		t.Fatalf("unexpected D-cache statistics %+v", status.DCache.CacheStats)
+	}
+	if status.DCache.Config != "256 bytes, 16-byte lines, 1-way, LRU, write-back" {
+		t.Fatal("unexpected config", status.DCache.Config)
+	}
+
+	c.Reset()
+	if _, dcache := c.Caches(); dcache.Statistics() != (CacheStats{}) {
+		t.Fatal("expected reset statistics")
+	}
+	c.DisableInstructionCache()
+	c.DisableDataCache()
+	if status = c.Status(); status.ICache != nil || status.DCache != nil {
+		t.Fatal("expected no cache statistics")
+	}
+}
+
+func TestCacheMaintenance(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.EnableDataCache(CacheConfig{256, 16, 2, LRU, WriteBack})
+	_, dcache := c.Caches()
+	run := func(instruction uint32) {
+		c.registers.WriteWord(PC, 0x100)
+		c.ram.WriteWord(0x100, instruction)
+		c.Step()
+	}
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(r1, 0x200)
+
+	// Configuring the cache sets the C bit
+	if c.cp15.control&controlC == 0 {
+		t.Fatal("expected the C bit to be set")
+	}
+
+	// Cleaning a dirty line writes it back, and it stays cached
+	run(0xE5810000) // str r0, [r1]
+	run(0xEE071F3A) // mcr p15, 0, r1, c7, c10, 1
+	run(0xEE071F3A) // mcr p15, 0, r1, c7, c10, 1
+	run(0xE5910000) // ldr r0, [r1]
+	expected := CacheStats{Reads: 1, Writes: 1, Hits: 1, Misses: 1, Writebacks: 1}
+	if stats := dcache.Statistics(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+
+	// Invalidating the data cache discards the line
+	run(0xEE070F16) // mcr p15, 0, r0, c7, c6, 0
+	run(0xE5910000) // ldr r0, [r1]
+	if stats := dcache.Statistics(); stats.Misses != 2 {
+		t.Fatalf("expected a miss after invalidating, got %+v", stats)
+	}
+
+	// Clean and invalidate by set/way (set 0x200/16 % 8 = 0, way 0)
+	run(0xE5810000) // str r0, [r1]
+	c.registers.WriteWord(r2, 0)
+	run(0xEE072F5E) // mcr p15, 0, r2, c7, c14, 2
+	if stats := dcache.Statistics(); stats.Writebacks != 2 || dcache.lookup(0x200) != nil {
+		t.Fatalf("expected the line to be written back and invalidated, got %+v", stats)
+	}
+
+	// Clearing the C bit disables the cache
+	before := dcache.Statistics()
+	c.registers.WriteWord(r0, controlReset)
+	run(0xEE010F10) // mcr p15, 0, r0, c1, c0, 0
+	run(0xE5910000) // ldr r0, [r1]
+	if stats := dcache.Statistics(); stats != before {
+		t.Fatalf("expected no accesses with the cache disabled, got %+v", stats)
+	}
+
+	// Reset enables the configured cache again
+	c.Reset()
+	if c.cp15.control&controlC == 0 {
+		t.Fatal("expected the C bit to be set after a reset")
+	}
+	c.DisableDataCache()
+	if c.cp15.control&controlC != 0 {
+		t.Fatal("expected the C bit to be cleared")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 )
 
 // A Computer holds the RAM, registers, and CPU of the simulated ARM
@@ -22,28 +23,43 @@ type Computer struct {
 
 	// A reference to the bank of CPU registers,
 	// implemented using a standard memory container
-	memSize   uint32
 	registers *Memory
 
 	// A reference to the CPU for the simulator
 	cpu *CPU
 
+	// The VFP floating-point unit (coprocessors 10 and 11)
+	vfp *VFP
+
+	// The system control coprocessor and MMU (coprocessor 15)
+	cp15 *CP15
+
 	// A simple counter to track number of execution cycles
 	step_counter uint64
 
+	// The fetch, decode and execute stages (only used in pipelined mode)
+	pipelined bool
+	pipeline  Pipeline
+
 	// Logger class
 	log *log.Logger
 
+	// Held by Run for each step, so snapshots see the machine between steps
+	mutex sync.Mutex
+
 	// Trace Log File
 	traceFile   *os.File
 	SystemTrace bool
+	CycleTrace  bool // Append cycle counts to traces
 
-	// Keyboard buffer
-	Keyboard chan byte
-	// Console buffer
-	Console chan byte
+	// Keyboard device (and buffer)
+	Keyboard *Keyboard
+	// Console device (and buffer)
+	Console *Console
 	// IRQ buffer
 	Irq chan bool
+	// FIQ buffer
+	Fiq chan bool
 }
 
 // A ComputerStatus is an individual module designed to make it easy to pass
@@ -54,9 +70,32 @@ type ComputerStatus struct {
 	Registers   [16]uint32 // A representation of the registers
 	Stack       []uint32   // A representation of the top of the stack
 	Memory      []string   // A string representation of the RAM
+	MemoryPages []uint32   // Addresses of the pages in Memory (sparse memory only)
 	Steps       uint64     // The number of steps executed so far (step_counter)
+	Cycles      uint64     // The number of cycles executed so far
+	CycleCounts Cycles     // The cycles executed so far by type
 	Checksum    int32      // Current RAM Checksum
 	Mode        string     // Current processor mode
+	State       string     // Current instruction set state (ARM or Thumb)
+
+	Pipelined bool     // True if each step is one pipeline cycle
+	Pipeline  Pipeline // The in-flight instructions (in pipelined mode)
+
+	ICache *CacheStatus // Instruction cache statistics (nil if disabled)
+	DCache *CacheStatus // Data cache statistics (nil if disabled)
+
+	Watchpoints []Watchpoint // The data watchpoints
+	WatchHit    *WatchHit    // The watchpoint hit that stopped the last step (or nil)
+
+	Endianness   string     // Byte order of memory (Little or Big)
+	FPSCR        uint32     // VFP status and control register
+	VFPRegisters [32]uint32 // VFP registers s0-s31 (d0-d15 are pairs)
+}
+
+// The configuration and statistics of a cache, for ComputerStatus
+type CacheStatus struct {
+	Config string // A description such as "4KB, 32-byte lines, 2-way, LRU, write-back"
+	CacheStats
 }
 
 // Initializes a Computer
@@ -69,6 +108,18 @@ type ComputerStatus struct {
 // Returns:
 //  a pointer to the newly created Computer
 func NewComputer(memSize uint32, logOut io.Writer) (c *Computer) {
+	return NewComputerWithMemory(NewMemory(memSize, logOut), logOut)
+}
+
+// Initializes a Computer with the given RAM (such as a sparse Memory)
+//
+// Parameters:
+//  ram - the RAM, mapped at address 0
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns:
+//  a pointer to the newly created Computer
+func NewComputerWithMemory(ram *Memory, logOut io.Writer) (c *Computer) {
 	c = new(Computer)
 
 	// Setup logging
@@ -77,21 +128,30 @@ func NewComputer(memSize uint32, logOut io.Writer) (c *Computer) {
 	}
 	c.log = log.New(logOut, "Computer: ", 0)
 
-	// Initialize RAM of memSize
-	c.memSize = memSize
-	c.ram = NewMemory(memSize, logOut)
+	// Assign RAM
+	c.ram = ram
 
 	// Initialize a register bank to contain all 16 registers + CPSR + Banked
 	// registers
-	c.registers = NewMemory(100, logOut)
+	c.registers = NewMemory(registerBankSize, logOut)
 
 	// Initialize buffers
-	c.Keyboard = make(chan byte, 100)
-	c.Console = make(chan byte, 100)
+	c.Keyboard = NewKeyboard(logOut)
+	c.Console = NewConsole(logOut)
 
 	// Initialize CPU with RAM and registers
 	c.cpu = NewCPU(c.ram, c.registers, c.Keyboard, c.Console, logOut)
 	c.Irq = c.cpu.irq
+	c.Fiq = c.cpu.fiq
+
+	// Attach the VFP
+	c.vfp = NewVFP(logOut)
+	c.AttachCoprocessor(10, c.vfp)
+	c.AttachCoprocessor(11, c.vfp)
+
+	// Attach CP15 (with the MMU disabled, addresses aren't translated)
+	c.cp15 = NewCP15(c.cpu, logOut)
+	c.AttachCoprocessor(15, c.cp15)
 
 	// Trace Log File
 	if err := c.EnableTracing(); err != nil {
@@ -105,7 +165,9 @@ func NewComputer(memSize uint32, logOut io.Writer) (c *Computer) {
 }
 
 // Simulates the running of the a computer. It executes the fetch, execute,
-// decode cycle until fetch returns false (signifying an instruction of 0x0).
+// decode cycle until fetch returns false (signifying an ARM instruction of 0x0
+// or a watchpoint hit). Snapshots taken while it runs (see SaveSnapshot) wait
+// for the current step to finish.
 //
 // Parameters:
 //  halting - channel to enable midstream halting of running (for Stop/Break in gui)
@@ -120,7 +182,10 @@ func (c *Computer) Run(halting, finishing chan bool) {
 			}
 		}
 
-		if !c.Step() {
+		c.mutex.Lock()
+		status := c.Step()
+		c.mutex.Unlock()
+		if !status {
 			break
 		}
 	}
@@ -166,20 +231,65 @@ func (c *Computer) Status() (status ComputerStatus) {
 
 	status.Disassembly = make([]string, 8)
 	i = 0
-	for address := status.Registers[15] - 8; address < status.Registers[15]+4*6; address += 4 {
-		iBits, _ := c.ram.ReadWord(address)
-		instruction := Decode(c.cpu, address, iBits)
-		status.Disassembly[i] = fmt.Sprintf("%x||%s", iBits, instruction.Disassemble())
-		i++
+	if c.cpu.Thumb() {
+		status.State = "Thumb"
+		for address := status.Registers[15] - 4; address < status.Registers[15]+2*6; address += 2 {
+			iBits, _ := c.ram.ReadHalfWord(address)
+			instruction := DecodeThumb(c.cpu, address, uint32(iBits))
+			status.Disassembly[i] = fmt.Sprintf("%04x||%s", iBits, instruction.Disassemble())
+			i++
+		}
+	} else {
+		status.State = "ARM"
+		for address := status.Registers[15] - 8; address < status.Registers[15]+4*6; address += 4 {
+			iBits, _ := c.ram.ReadWord(address)
+			instruction := Decode(c.cpu, address, iBits)
+			status.Disassembly[i] = fmt.Sprintf("%x||%s", iBits, instruction.Disassemble())
+			i++
+		}
 	}
 
-	status.Memory = make([]string, c.memSize)
-	for i = 0; i < c.memSize; i++ {
-		b, _ := c.ram.ReadByte(i)
-		status.Memory[i] = fmt.Sprintf("%x", b)
+	if c.ram.Sparse() {
+		// Only the allocated pages
+		status.MemoryPages = c.ram.Pages()
+		status.Memory = make([]string, 0, len(status.MemoryPages)*PageSize)
+		for _, base := range status.MemoryPages {
+			for i = 0; i < PageSize; i++ {
+				b, _ := c.ram.ReadByte(base + i)
+				status.Memory = append(status.Memory, fmt.Sprintf("%x", b))
+			}
+		}
+	} else {
+		status.Memory = make([]string, c.ram.Size())
+		for i = 0; uint64(i) < c.ram.Size(); i++ {
+			b, _ := c.ram.ReadByte(i)
+			status.Memory[i] = fmt.Sprintf("%x", b)
+		}
 	}
 
+	status.Pipelined = c.pipelined
+	status.Pipeline = c.pipeline
+
+	if c.cpu.icache != nil {
+		status.ICache = &CacheStatus{c.cpu.icache.Config().String(), c.cpu.icache.Statistics()}
+	}
+	if c.cpu.dcache != nil {
+		status.DCache = &CacheStatus{c.cpu.dcache.Config().String(), c.cpu.dcache.Statistics()}
+	}
+
+	status.Watchpoints = c.Watchpoints()
+	status.WatchHit = c.cpu.watchHit
+
+	status.Endianness = "Little"
+	if c.BigEndian() {
+		status.Endianness = "Big"
+	}
+	status.FPSCR = c.vfp.FPSCR()
+	status.VFPRegisters = c.vfp.Registers()
+
 	status.Steps = c.step_counter
+	status.Cycles = c.cpu.cycles.Total()
+	status.CycleCounts = c.cpu.cycles
 	status.Checksum = c.Checksum()
 
 	mode, _ := c.cpu.FetchRegister(CPSR)
@@ -189,10 +299,16 @@ func (c *Computer) Status() (status ComputerStatus) {
 		status.Mode = "Supervisor"
 	case IRQ:
 		status.Mode = "IRQ"
+	case FIQ:
+		status.Mode = "FIQ"
 	case System:
 		status.Mode = "System"
 	case User:
 		status.Mode = "User"
+	case Undefined:
+		status.Mode = "Undefined"
+	case Abort:
+		status.Mode = "Abort"
 	default:
 		status.Mode = "Unknown"
 	}
@@ -200,18 +316,43 @@ func (c *Computer) Status() (status ComputerStatus) {
 	return
 }
 
-// Performs a single execution cycle. Take no parameters and returns a boolean
-// signifying if the cycle was completed (a cycle will not complete if the
-// instrution fetched is 0x0).
+// Performs a single execution cycle (or pipeline cycle, see stepPipeline).
+// Take no parameters and returns a boolean signifying if the cycle was
+// completed (a cycle will not complete if the ARM instrution fetched is 0x0, or
+// if the instruction hit a watchpoint, see WatchHit). A Thumb halfword of 0x0
+// is lsls r0, r0, #0 and executes normally.
 func (c *Computer) Step() (status bool) {
-	// For trace
-	pc, _ := c.cpu.FetchRegister(PC)
+	c.cpu.watchHit = nil
+	if c.pipelined {
+		return c.stepPipeline()
+	}
+
+	// For trace (address of the instruction about to be fetched)
+	pc, _ := c.registers.ReadWord(PC)
+	thumb := c.cpu.Thumb()
 
-	instructionBits := c.cpu.Fetch()
+	// A failed fetch has already taken the Prefetch Abort
+	instructionBits, err := c.cpu.Fetch()
+	status = true
+	if err == nil {
+		instruction := c.cpu.Decode(instructionBits)
+		status = c.cpu.Execute(instruction)
+	}
 
-	instruction := c.cpu.Decode(instructionBits)
-	status = c.cpu.Execute(instruction)
+	return c.completeStep(pc, status && (err != nil || thumb || instructionBits != 0x0))
+}
 
+// Finishes a step once an instruction has executed: writes the trace,
+// increments the step counter and takes any pending interrupt (unless the CPU
+// halted or hit a watchpoint).
+//
+// Parameters:
+//  pc - the address of the instruction that was executed
+//  status - false if the CPU should halt
+//
+// Returns:
+//  status - false if the CPU halted
+func (c *Computer) completeStep(pc uint32, status bool) bool {
 	// Write trace
 	cpsr, _ := c.cpu.FetchRegister(CPSR)
 	mode := ExtractBits(cpsr, 0, 5)
@@ -222,10 +363,26 @@ func (c *Computer) Step() (status bool) {
 	// Increment step counter
 	c.step_counter++
 
-	if !status || instructionBits == 0x0 {
+	if !status || c.cpu.err != nil || c.cpu.watchHit != nil {
 		return false
 	}
 
+	// FIQs have priority over IRQs
+	fiqs_disabled, _ := c.cpu.registers.TestFlag(CPSR, FQ)
+	if !fiqs_disabled && len(c.cpu.fiq) > 0 {
+		// Switch to FIQ mode and handle the fast interrupt
+
+		// remove bool
+		<-c.cpu.fiq
+
+		// Return address is the next instruction + 4, so handlers return with
+		// subs pc, lr, #4
+		pc, _ := c.registers.ReadWord(PC)
+		c.cpu.enterException(FIQ, FIQVector, pc+4, true)
+		c.cpu.cycles.add(interruptCycles)
+		return true
+	}
+
 	interrupts_disabled, _ := c.cpu.registers.TestFlag(CPSR, 7)
 	if !interrupts_disabled && len(c.cpu.irq) > 0 {
 		// Switch to IRQ mode and handle the interrupt
@@ -233,35 +390,21 @@ func (c *Computer) Step() (status bool) {
 		// remove bool
 		<-c.cpu.irq
 
-		// Save return address
-		pc, _ := c.cpu.FetchRegister(PC)
-		c.cpu.WriteRegister(r14_irq, pc-4)
-
-		// Save CPSR
-		cpsr, _ := c.cpu.FetchRegister(CPSR)
-		c.cpu.WriteRegister(SPSR_irq, cpsr)
-		c.log.Printf("Old CPSR: %032b", cpsr)
-
-		// Set mode bits
-		cpsr &= 0xFFFFFFE0
-		cpsr |= IRQ
-
-		// Set CPSR
-		c.cpu.WriteRegister(CPSR, cpsr)
-
-		// Set I bit
-		c.cpu.registers.SetFlag(CPSR, I, true)
-
-		c.log.Printf("New CPSR: %032b", cpsr)
-
-		// Set PC
-		c.cpu.WriteRegister(PC, 0x18)
+		// Return address is the next instruction + 4, so handlers return with
+		// subs pc, lr, #4
+		pc, _ := c.registers.ReadWord(PC)
+		c.cpu.enterException(IRQ, IRQVector, pc+4, true)
+		c.cpu.cycles.add(interruptCycles)
 	}
 
 	return true
 }
 
-// Builds a three-line status output to debug simulator.
+// Builds a three-line status output to debug simulator (followed by the VFP
+// registers once the program has used the VFP).
+//
+// Parameters:
+//  program_counter - the address of the instruction that was executed
 //
 // Returns:
 //  string contining trace output
@@ -270,8 +413,11 @@ func (c *Computer) Trace(program_counter uint32) (output string) {
 	cpsr, _ := c.cpu.FetchRegister(CPSR)
 	flags := ExtractShiftBits(cpsr, V, 32)
 
-	output = fmt.Sprintf("%06d %08X %08X %04b\t", c.step_counter, program_counter-4,
+	output = fmt.Sprintf("%06d %08X %08X %04b\t", c.step_counter, program_counter,
 		c.ram.Checksum(), flags)
+	if c.cpu.Thumb() {
+		output += "T\t"
+	}
 	for i := 0; i < 15; i++ {
 		reg, _ := c.cpu.FetchRegister(uint32(i * 4))
 		output += fmt.Sprintf("%2d=%08X", i, reg)
@@ -281,6 +427,12 @@ func (c *Computer) Trace(program_counter uint32) (output string) {
 			output += "\t"
 		}
 	}
+	if c.CycleTrace {
+		output += fmt.Sprintf("\n\tcycles=%d (%s)", c.cpu.cycles.Total(), c.cpu.cycles)
+	}
+	if c.vfp.Used() {
+		output += "\n\t" + c.vfp.trace()
+	}
 	c.log.Print(output)
 
 	return
@@ -301,6 +453,7 @@ func (c *Computer) LoadELF(filePath string) (err error) {
 
 	// Get a clean system
 	c.Reset()
+	c.cpu.segments = nil
 
 	// Setup Logging
 	defer c.log.SetPrefix(c.log.Prefix())
@@ -322,11 +475,25 @@ func (c *Computer) LoadELF(filePath string) (err error) {
 		return
 	}
 
+	// Detect byte order (EI_DATA), which also sets the computer's endianness
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	ident := make([]byte, elf.EI_NIDENT)
+	file.Seek(0, 0)
+	if _, err = file.Read(ident); err != nil {
+		c.log.Println("Error reading ELF identification...")
+		return
+	}
+	if elf.Data(ident[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		c.log.Println("Big-endian ELF...")
+		byteOrder = binary.BigEndian
+	}
+	c.SetBigEndian(byteOrder == binary.BigEndian)
+
 	// Read ELF Header
 	c.log.Println("Reading ELF header...")
 	file.Seek(0, 0)
 	elfHeader := new(elf.Header32)
-	err = binary.Read(file, binary.LittleEndian, elfHeader)
+	err = binary.Read(file, byteOrder, elfHeader)
 	if err != nil {
 		c.log.Println("Error reading ELF header...")
 		return
@@ -352,13 +519,22 @@ func (c *Computer) LoadELF(filePath string) (err error) {
 		file.Seek(offset, 0)
 
 		// Read program header
-		err = binary.Read(file, binary.LittleEndian, pHeader)
+		err = binary.Read(file, byteOrder, pHeader)
 		if err != nil {
 			c.log.Printf("Error reading program header %d...", i)
 			return
 		}
 
 		c.log.Printf("Reading program header %d of %d - Offset: %d, Size: %d, Address: %d", i+1, elfHeader.Phnum, pHeader.Off, pHeader.Filesz, pHeader.Vaddr)
+
+		// Record the segment's permissions
+		if elf.ProgType(pHeader.Type) == elf.PT_LOAD && pHeader.Memsz > 0 {
+			segment := Region{fmt.Sprintf("segment %d", i), pHeader.Vaddr, pHeader.Memsz,
+				Permissions(pHeader.Flags) & (PermRead | PermWrite | PermExecute)}
+			c.log.Println("Segment:", segment)
+			c.cpu.segments = append(c.cpu.segments, segment)
+		}
+
 		// Seek to offset
 		file.Seek(int64(pHeader.Off), 0)
 
@@ -438,13 +614,33 @@ func (c *Computer) DisableSystemTracing() {
 	c.SystemTrace = false
 }
 
+// Enables appending the cycle counts (total and by type) to traces.
+func (c *Computer) EnableCycleTracing() {
+	c.CycleTrace = true
+}
+
+// Disables appending the cycle counts to traces (the default, which keeps
+// traces comparable with the reference logs).
+func (c *Computer) DisableCycleTracing() {
+	c.CycleTrace = false
+}
+
+// Returns the cycles executed since the last reset, by type (see Cycles.Total
+// for the total).
+//
+// Parameters: None
+//
+// Returns:
+//  cycles - the cycle counts
+func (c *Computer) Cycles() (cycles Cycles) {
+	return c.cpu.cycles
+}
+
 // Resets memory and registers to a clean state (all values zeroed out).
 func (c *Computer) Reset() {
-	for i := 0; uint32(i) < c.memSize; i += 4 {
-		c.ram.WriteWord(uint32(i), 0x0)
-	}
+	c.ram.Clear()
 
-	for i := 0; uint32(i) < 100; i += 4 {
+	for i := 0; uint32(i) < registerBankSize; i += 4 {
 		c.registers.WriteWord(uint32(i), 0x0)
 	}
 
@@ -460,7 +656,323 @@ func (c *Computer) Reset() {
 	// Set mode
 	c.cpu.WriteRegister(CPSR, System)
 
+	c.vfp.Reset()
+	c.cp15.Reset()
+	for _, cache := range []*Cache{c.cpu.icache, c.cpu.dcache} {
+		if cache != nil {
+			cache.Reset()
+		}
+	}
+
 	c.step_counter = 1
+	c.cpu.cycles = Cycles{}
+	c.pipeline = Pipeline{}
+	c.cpu.err = nil
+	c.cpu.watchHit = nil
+}
+
+// Returns the error that halted the computer, if any (e.g., an undefined
+// instruction when halting on undefined instructions is enabled).
+//
+// Parameters: None
+//
+// Returns:
+//  err - the error or nil
+func (c *Computer) Err() (err error) {
+	return c.cpu.err
+}
+
+// Enables halting with an error (see Err) on undefined instructions instead of
+// taking the Undefined Instruction exception.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) EnableHaltOnUndefined() {
+	c.cpu.haltOnUndefined = true
+}
+
+// Disables halting on undefined instructions (the default), so they take the
+// Undefined Instruction exception.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisableHaltOnUndefined() {
+	c.cpu.haltOnUndefined = false
+}
+
+// Enables enforcing the permissions of the memory regions (see AddRegion and
+// Regions) on every fetch, load and store.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) EnablePermissionChecking() {
+	c.cpu.permissionChecking = true
+}
+
+// Disables enforcing the permissions of the memory regions (the default, as
+// some programs keep data in their read-only text segment).
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisablePermissionChecking() {
+	c.cpu.permissionChecking = false
+}
+
+// Enables halting with an error (a *PermissionFault, see Err) on accesses a
+// memory region doesn't permit, instead of taking the Prefetch Abort or Data
+// Abort exception.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) EnableHaltOnFault() {
+	c.cpu.haltOnFault = true
+}
+
+// Disables halting on permission faults (the default), so they take the
+// Prefetch Abort or Data Abort exception.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisableHaltOnFault() {
+	c.cpu.haltOnFault = false
+}
+
+// Adds a memory region whose permissions are enforced on every fetch, load
+// and store (see EnablePermissionChecking). Added regions take priority over the segments of the loaded ELF
+// file, and are kept when another file is loaded.
+//
+// Parameters:
+//  region - the region to add
+//
+// Returns: None
+func (c *Computer) AddRegion(region Region) {
+	c.cpu.regions = append(c.cpu.regions, region)
+}
+
+// Returns the memory regions: the added regions followed by the segments of
+// the loaded ELF file.
+//
+// Parameters: None
+//
+// Returns:
+//  regions - the regions
+func (c *Computer) Regions() (regions []Region) {
+	regions = append(regions, c.cpu.regions...)
+	return append(regions, c.cpu.segments...)
+}
+
+// Enables raising a Data Abort for unaligned halfword and word accesses,
+// instead of ignoring the low address bits (the default).
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) EnableAlignmentChecking() {
+	c.cpu.alignmentChecking = true
+}
+
+// Disables alignment checking (the default), so unaligned accesses ignore the
+// low address bits and unaligned LDR and SWP rotate the loaded word.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisableAlignmentChecking() {
+	c.cpu.alignmentChecking = false
+}
+
+// Sets the byte order of memory (halfword and word accesses, including
+// instruction fetch). LoadELF sets it from the ELF file.
+//
+// Parameters:
+//  bigEndian - true for big-endian, false for little-endian
+//
+// Returns: None
+func (c *Computer) SetBigEndian(bigEndian bool) {
+	c.cpu.bus.SetBigEndian(bigEndian)
+	c.vfp.bigEndian = bigEndian
+}
+
+// Returns true if memory is big-endian.
+func (c *Computer) BigEndian() (bigEndian bool) {
+	return c.cpu.bus.BigEndian()
+}
+
+// Adds a data watchpoint: Step (and so Run) stops after an instruction whose
+// access to RAM matches it, and WatchHit reports the access. Watchpoints are
+// kept when the computer is reset.
+//
+// Parameters:
+//  w - the watchpoint to add
+//
+// Returns:
+//  err - an error if the watchpoint's range is invalid
+func (c *Computer) AddWatchpoint(w Watchpoint) (err error) {
+	if err = w.validate(); err != nil {
+		return
+	}
+	c.cpu.watchpoints = append(c.cpu.watchpoints, w)
+	c.ram.Watch(c.cpu.watch)
+	return
+}
+
+// Removes a data watchpoint.
+//
+// Parameters:
+//  w - the watchpoint to remove
+//
+// Returns:
+//  err - an error if there is no such watchpoint
+func (c *Computer) RemoveWatchpoint(w Watchpoint) (err error) {
+	for i, existing := range c.cpu.watchpoints {
+		if existing == w {
+			c.cpu.watchpoints = append(c.cpu.watchpoints[:i], c.cpu.watchpoints[i+1:]...)
+			if len(c.cpu.watchpoints) == 0 {
+				c.ram.Watch(nil)
+			}
+			return
+		}
+	}
+	return fmt.Errorf("No watchpoint %s.", w)
+}
+
+// Removes every data watchpoint.
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) ClearWatchpoints() {
+	c.cpu.watchpoints = nil
+	c.ram.Watch(nil)
+}
+
+// Returns the data watchpoints.
+//
+// Parameters: None
+//
+// Returns:
+//  watchpoints - the watchpoints
+func (c *Computer) Watchpoints() (watchpoints []Watchpoint) {
+	return append(watchpoints, c.cpu.watchpoints...)
+}
+
+// Returns the watchpoint hit that stopped the last step, if any. It is
+// cleared when the next step starts.
+//
+// Parameters: None
+//
+// Returns:
+//  hit - the hit or nil
+func (c *Computer) WatchHit() (hit *WatchHit) {
+	return c.cpu.watchHit
+}
+
+// Enables an instruction cache between the CPU and RAM (replacing any
+// existing one), which counts the hits and misses of instruction fetches. The
+// CP15 control register's I bit is set; a program can clear it to disable the
+// cache, and maintain the cache with CP15 c7 operations.
+//
+// Parameters:
+//  config - the geometry and policies of the cache
+//
+// Returns:
+//  err - an error if the configuration is invalid
+func (c *Computer) EnableInstructionCache(config CacheConfig) (err error) {
+	cache, err := NewCache(config, c.cpu.logOut)
+	if err == nil {
+		c.cpu.icache = cache
+		c.cp15.enableCaches()
+	}
+	return
+}
+
+// Disables the instruction cache (the default).
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisableInstructionCache() {
+	c.cpu.icache = nil
+	c.cp15.control &^= controlI
+}
+
+// Enables a data cache between the CPU and RAM (replacing any existing one),
+// which counts the hits and misses of loads and stores. The CP15 control
+// register's C bit is set (see EnableInstructionCache).
+//
+// Parameters:
+//  config - the geometry and policies of the cache
+//
+// Returns:
+//  err - an error if the configuration is invalid
+func (c *Computer) EnableDataCache(config CacheConfig) (err error) {
+	cache, err := NewCache(config, c.cpu.logOut)
+	if err == nil {
+		c.cpu.dcache = cache
+		c.cp15.enableCaches()
+	}
+	return
+}
+
+// Disables the data cache (the default).
+//
+// Parameters: None
+//
+// Returns: None
+func (c *Computer) DisableDataCache() {
+	c.cpu.dcache = nil
+	c.cp15.control &^= controlC
+}
+
+// Returns the instruction and data caches (nil if disabled).
+//
+// Parameters: None
+//
+// Returns:
+//  icache - the instruction cache
+//  dcache - the data cache
+func (c *Computer) Caches() (icache, dcache *Cache) {
+	return c.cpu.icache, c.cpu.dcache
+}
+
+// Returns the bus, to map devices such as ROM or peripherals into the address
+// space (RAM, the console and the keyboard are already mapped).
+//
+// Parameters: None
+//
+// Returns:
+//  bus - the CPU's bus
+func (c *Computer) Bus() (bus *Bus) {
+	return c.cpu.bus
+}
+
+// Attaches a coprocessor to handle the CDP, LDC, STC, MCR and MRC
+// instructions for a coprocessor number. Attaching nil detaches it. A *CP15
+// attached as coprocessor 15 translates addresses with its MMU (CP15 is
+// attached by default).
+//
+// Parameters:
+//  number - the coprocessor number (0-15)
+//  cp - the coprocessor
+//
+// Returns:
+//  err - an error if the coprocessor number is invalid
+func (c *Computer) AttachCoprocessor(number uint32, cp Coprocessor) (err error) {
+	if number > 15 {
+		return fmt.Errorf("Invalid coprocessor number %d.", number)
+	}
+	c.cpu.coprocessors[number] = cp
+
+	if number == 15 {
+		c.cpu.mmu, _ = cp.(*CP15)
+	}
+	return
 }
 
 // Helper Methods
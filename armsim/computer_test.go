@@ -4,7 +4,10 @@
 package armsim
 
 import (
+	"debug/elf"
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -36,11 +39,11 @@ func TestNewComputer(t *testing.T) {
 	if computer.registers == nil {
 		t.Fatal("Did not initialize Registers.")
 	}
-	_, err = computer.registers.ReadByte(99)
+	_, err = computer.registers.ReadByte(registerBankSize - 1)
 	if err != nil {
 		t.Fatal("Did not initialize registers to correct size (too small).")
 	}
-	_, err = computer.registers.ReadByte(100)
+	_, err = computer.registers.ReadByte(registerBankSize)
 	if err == nil {
 		t.Fatal("Did not initialize registers to correct size (too big).")
 	}
@@ -146,6 +149,70 @@ func TestStep(t *testing.T) {
 	}
 }
 
+func TestFIQ(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE3A08001) // mov r8, #1
+	c.ram.WriteWord(0x104, 0xE1A00000) // mov r0, r0
+	c.ram.WriteWord(0x1C, 0xE3A08002)  // mov r8, #2
+	c.ram.WriteWord(0x20, 0xE25EF004)  // subs pc, lr, #4
+
+	// FIQs take priority over IRQs
+	c.Fiq <- true
+	c.Irq <- true
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x1C {
+		t.Fatalf("expected pc 0x1c, got %#x", pc)
+	}
+	if mode := c.Status().Mode; mode != "FIQ" {
+		t.Fatal("expected FIQ mode, got", mode)
+	}
+	if lr, _ := c.registers.ReadWord(r14_fiq); lr != 0x108 {
+		t.Fatalf("expected r14_fiq 0x108, got %#x", lr)
+	}
+	if spsr, _ := c.registers.ReadWord(SPSR_fiq); spsr != System {
+		t.Fatalf("expected SPSR_fiq %#x, got %#x", System, spsr)
+	}
+	i, _ := c.registers.TestFlag(CPSR, I)
+	f, _ := c.registers.TestFlag(CPSR, FQ)
+	if !i || !f {
+		t.Fatal("expected I and F bits to be set")
+	}
+
+	// r8 is banked in FIQ mode
+	c.Step()
+	if r, _ := c.registers.ReadWord(r8_fiq); r != 2 {
+		t.Fatal("expected r8_fiq 2, got", r)
+	}
+	if r, _ := c.registers.ReadWord(r8); r != 1 {
+		t.Fatal("expected r8 1, got", r)
+	}
+
+	// Returning re-enables IRQs, so the pending IRQ is taken immediately
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x18 {
+		t.Fatalf("expected pc 0x18, got %#x", pc)
+	}
+	if lr, _ := c.registers.ReadWord(r14_irq); lr != 0x108 {
+		t.Fatalf("expected r14_irq 0x108, got %#x", lr)
+	}
+	if spsr, _ := c.registers.ReadWord(SPSR_irq); spsr != System {
+		t.Fatalf("expected SPSR_irq %#x, got %#x", System, spsr)
+	}
+
+	// The F bit masks FIQs
+	c.Reset()
+	c.registers.WriteWord(CPSR, System|1<<FQ)
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE1A00000) // mov r0, r0
+	c.Fiq <- true
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x104 {
+		t.Fatalf("expected masked FIQ to be ignored, pc %#x", pc)
+	}
+}
+
 /*
 func TestTrace(t *testing.T) {
 	// Setup
@@ -258,6 +325,50 @@ func TestLoadELF(t *testing.T) {
 	}
 }
 
+func TestLoadELFBigEndian(t *testing.T) {
+	// A big-endian ELF with mov r0, #5 at 0x100
+	path := filepath.Join(t.TempDir(), "be.exe")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := elf.Header32{Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_ARM),
+		Version: 1, Entry: 0x100, Phoff: 52, Ehsize: 52, Phentsize: 32, Phnum: 1}
+	copy(header.Ident[:], []byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS32), byte(elf.ELFDATA2MSB), 1})
+	prog := elf.Prog32{Type: uint32(elf.PT_LOAD), Off: 84, Vaddr: 0x100, Filesz: 8, Memsz: 8}
+	binary.Write(file, binary.BigEndian, header)
+	binary.Write(file, binary.BigEndian, prog)
+	binary.Write(file, binary.BigEndian, []uint32{0xE3A00005, 0x0})
+	file.Close()
+
+	c := NewComputer(1024, nil)
+	if err = c.LoadELF(path); err != nil {
+		t.Fatal(err)
+	}
+	if !c.BigEndian() || c.Status().Endianness != "Big" {
+		t.Fatal("expected a big-endian computer")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x100 {
+		t.Fatalf("expected pc 0x100, got %#x", pc)
+	}
+	if b, _ := c.ram.ReadByte(0x100); b != 0xE3 {
+		t.Fatalf("expected 0xe3 at 0x100, got %#x", b)
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 5 {
+		t.Fatal("expected 5, got", r)
+	}
+
+	// Doubles are stored high word first
+	c.vfp.write(true, 0, 1)
+	c.registers.WriteWord(r1, 0x200)
+	c.ram.WriteWord(0x104, 0xED810B00) // fstd d0, [r1]
+	c.Step()
+	if w, _ := c.ram.ReadWord(0x200); w != 0x3FF00000 {
+		t.Fatalf("expected 0x3ff00000, got %#x", w)
+	}
+}
+
 func TestCompChecksum(t *testing.T) {
 	computer := NewComputer(32*1024, os.Stderr)
 
@@ -333,3 +444,35 @@ func TestReset(t *testing.T) {
 		}
 	}
 }
+
+func TestSparseComputer(t *testing.T) {
+	c := NewComputerWithMemory(NewSparseMemory(nil), nil)
+	c.registers.WriteWord(PC, 0x80000000)
+	c.registers.WriteWord(SP, 0xFFFFFFF0)
+	c.registers.WriteWord(r0, 'A')
+	c.registers.WriteWord(r1, ConsoleAddress)
+	c.ram.WriteWord(0x80000000, 0xE52D0004) // push {r0}
+	c.ram.WriteWord(0x80000004, 0xE5C10000) // strb r0, [r1]
+	c.ram.WriteWord(0x80000008, 0xE59D2000) // ldr r2, [sp]
+
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+	if word, _ := c.ram.ReadWord(0xFFFFFFEC); word != 'A' {
+		t.Fatalf("expected 'A' on the stack, got %#x", word)
+	}
+	if b := string(c.Console.Read()); b != "A" {
+		t.Fatalf("expected \"A\" on the console, got %q", b)
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 'A' {
+		t.Fatalf("expected r2 'A', got %#x", r)
+	}
+
+	status := c.Status()
+	if len(status.MemoryPages) != 2 || len(status.Memory) != 2*PageSize {
+		t.Fatalf("expected 2 pages in the memory view, got %#x", status.MemoryPages)
+	}
+	if status.Memory[PageSize+0xFEC] != "41" {
+		t.Fatalf("expected 41 in the memory view, got %s", status.Memory[PageSize+0xFEC])
+	}
+}
@@ -0,0 +1,361 @@
+// Filename: coprocessor.go
+// Contents: The Coprocessor interface and the coprocessorInstruction struct.
+//	CDP, LDC, STC, MCR, MRC, MCRR and MRRC are decoded by the CPU and handed
+//	to the coprocessor attached to the instruction's coprocessor number.
+//	Without one (or if the coprocessor rejects the instruction) the Undefined
+//	Instruction exception is taken.
+
+package armsim
+
+import (
+	"fmt"
+)
+
+// A coprocessor attached to the CPU (see Computer.AttachCoprocessor). Every
+// method reports whether the coprocessor accepts the operation; returning
+// false makes the CPU take the Undefined Instruction exception.
+type Coprocessor interface {
+	// CDP: performs a data operation internal to the coprocessor.
+	DataOperation(op CoprocessorOperation) (ok bool)
+
+	// MCR: receives data from an ARM register.
+	MoveTo(op CoprocessorOperation, data uint32) (ok bool)
+
+	// MRC: returns data for an ARM register (or the NZCV flags if Rd is r15).
+	MoveFrom(op CoprocessorOperation) (data uint32, ok bool)
+
+	// LDC/STC: returns the number of words transferred by the instruction.
+	TransferLength(op CoprocessorOperation) (words uint32, ok bool)
+
+	// LDC: receives the index-th word loaded from memory.
+	Load(op CoprocessorOperation, index, data uint32)
+
+	// STC: returns the index-th word to store to memory.
+	Store(op CoprocessorOperation, index uint32) (data uint32)
+}
+
+// A coprocessor that provides its own disassembly (e.g., "fadds s0, s1, s2").
+// Coprocessors without it use the generic cdp/ldc/stc/mcr/mrc forms.
+type CoprocessorDisassembler interface {
+	// Returns the assembly for the operation, or false to use the generic form.
+	Disassemble(op CoprocessorOperation) (assembly string, ok bool)
+}
+
+// A coprocessor that supports two-register transfers (MCRR and MRRC).
+// Coprocessors without it leave both instructions undefined.
+type CoprocessorDoubleTransfer interface {
+	// MCRR: receives data from two ARM registers (Rd, then Rn).
+	MoveToDouble(op CoprocessorOperation, low, high uint32) (ok bool)
+
+	// MRRC: returns data for two ARM registers (Rd, then Rn).
+	MoveFromDouble(op CoprocessorOperation) (low, high uint32, ok bool)
+}
+
+// The fields of a coprocessor instruction, as passed to a Coprocessor.
+type CoprocessorOperation struct {
+	InstructionBits uint32 // The original bits of the instruction
+	Number          uint32 // Coprocessor number (0-15)
+	Opcode1         uint32 // CDP bits 20-23, MCR/MRC bits 21-23, MCRR/MRRC bits 4-7
+	Opcode2         uint32 // CDP/MCR/MRC bits 5-7
+	CRd             uint32 // Coprocessor destination register (CDP, LDC, STC)
+	CRn             uint32 // Coprocessor first operand register
+	CRm             uint32 // Coprocessor second operand register
+	Rd              uint32 // ARM register (MCR, MRC, MCRR, MRRC)
+	Rn              uint32 // Second ARM register (MCRR, MRRC)
+	N               bool   // LDC/STC N bit (long transfer)
+	Offset          uint32 // LDC/STC 8-bit word offset (or option)
+}
+
+// Kinds of coprocessor instructions
+const (
+	coprocessorDataOperation  = iota // CDP
+	coprocessorTransfer              // LDC, STC
+	coprocessorRegister              // MCR, MRC
+	coprocessorDoubleRegister        // MCRR, MRRC
+)
+
+// Holds values typical to coprocessor instructions.
+type coprocessorInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	Kind int                  // CDP, LDC/STC, MCR/MRC or MCRR/MRRC
+	Op   CoprocessorOperation // Fields passed to the coprocessor
+
+	P bool // P bit (LDC/STC)
+	U bool // U bit (LDC/STC)
+	W bool // W bit (LDC/STC)
+	L bool // L bit (LDC or MRC)
+}
+
+// Decodes a coprocessor instruction
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (ci *coprocessorInstruction) decode(base *baseInstruction) {
+	ci.baseInstruction = base
+	ci.log.SetPrefix("Coprocessor Decoder: ")
+
+	bits := base.InstructionBits
+	if bits&0x0FE00000 == 0x0C400000 {
+		// cond 1100 010L Rn Rd cp_num opcode CRm
+		ci.Kind = coprocessorDoubleRegister
+	} else if base.Type == 0x6 {
+		ci.Kind = coprocessorTransfer
+	} else if ExtractShiftBits(bits, 4, 5) == 0 {
+		ci.Kind = coprocessorDataOperation
+	} else {
+		ci.Kind = coprocessorRegister
+	}
+
+	ci.P = ExtractShiftBits(bits, 24, 25) == 1
+	ci.U = ExtractShiftBits(bits, 23, 24) == 1
+	ci.W = ExtractShiftBits(bits, 21, 22) == 1
+	ci.L = ExtractShiftBits(bits, 20, 21) == 1
+
+	ci.Op.InstructionBits = bits
+	ci.Op.Number = ExtractShiftBits(bits, 8, 12)
+	ci.Op.CRd = base.Rd
+	ci.Op.CRn = base.Rn
+	ci.Op.CRm = ExtractShiftBits(bits, 0, 4)
+	ci.Op.Opcode2 = ExtractShiftBits(bits, 5, 8)
+	switch ci.Kind {
+	case coprocessorDataOperation:
+		ci.Op.Opcode1 = ExtractShiftBits(bits, 20, 24)
+	case coprocessorRegister:
+		ci.Op.Opcode1 = ExtractShiftBits(bits, 21, 24)
+		ci.Op.Rd = base.Rd
+	case coprocessorDoubleRegister:
+		ci.Op.Opcode1 = ExtractShiftBits(bits, 4, 8)
+		ci.Op.Rd = base.Rd
+		ci.Op.Rn = base.Rn
+	case coprocessorTransfer:
+		ci.Op.N = ExtractShiftBits(bits, 22, 23) == 1
+		ci.Op.Offset = ExtractShiftBits(bits, 0, 8)
+	}
+	ci.log.Printf("Coprocessor: p%d; Kind: %d", ci.Op.Number, ci.Kind)
+
+	return
+}
+
+// Executes a coprocessor instruction by handing it to the attached
+// coprocessor.
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (ci *coprocessorInstruction) Execute() (status bool) {
+	if !ConditionPassed(ci.baseInstruction) {
+		return true
+	}
+
+	cp := ci.cpu.coprocessors[ci.Op.Number]
+	if cp == nil {
+		ci.log.Printf("No coprocessor p%d", ci.Op.Number)
+		ci.cycles = Cycles{S: 1, I: 1}
+		return ci.cpu.undefinedInstruction(ci.Address, ci.InstructionBits)
+	}
+
+	// CDP: 1S; MCR: 1S + 1C; MRC: 1S + 1I + 1C; LDC/STC: (n-1)S + 2N (set
+	// by executeTransfer)
+	var ok bool
+	switch ci.Kind {
+	case coprocessorDataOperation:
+		ci.cycles = Cycles{S: 1}
+		ok = cp.DataOperation(ci.Op)
+	case coprocessorRegister, coprocessorDoubleRegister:
+		ci.cycles = Cycles{S: 1, C: 1}
+		if ci.L {
+			ci.cycles.I = 1
+		}
+		if ci.Kind == coprocessorRegister {
+			ok = ci.executeRegister(cp)
+		} else {
+			ok = ci.executeDoubleRegister(cp)
+		}
+	case coprocessorTransfer:
+		var words uint32
+		if words, ok = cp.TransferLength(ci.Op); ok {
+			ci.executeTransfer(cp, words)
+		}
+	}
+
+	if !ok {
+		ci.log.Printf("Coprocessor p%d rejected 0x%08X", ci.Op.Number, ci.InstructionBits)
+		ci.cycles = Cycles{S: 1, I: 1}
+		return ci.cpu.undefinedInstruction(ci.Address, ci.InstructionBits)
+	}
+
+	return true
+}
+
+// Executes MCR or MRC.
+//
+// Parameters:
+//  cp - the attached coprocessor
+//
+// Returns:
+//  ok - false if the coprocessor rejected the instruction
+func (ci *coprocessorInstruction) executeRegister(cp Coprocessor) (ok bool) {
+	if !ci.L { // MCR
+		data, _ := ci.cpu.FetchRegisterFromInstruction(ci.Op.Rd)
+		return cp.MoveTo(ci.Op, data)
+	}
+
+	// MRC
+	data, ok := cp.MoveFrom(ci.Op)
+	if !ok {
+		return
+	}
+	if ci.Op.Rd == 15 {
+		// Only the NZCV flags are transferred
+		cpsr, _ := ci.cpu.FetchRegister(CPSR)
+		cpsr = cpsr&0x0FFFFFFF | data&0xF0000000
+		ci.cpu.WriteRegister(CPSR, cpsr)
+	} else {
+		ci.cpu.WriteRegisterFromInstruction(ci.Op.Rd, data)
+	}
+
+	return
+}
+
+// Executes MCRR or MRRC.
+//
+// Parameters:
+//  cp - the attached coprocessor
+//
+// Returns:
+//  ok - false if the coprocessor rejected the instruction
+func (ci *coprocessorInstruction) executeDoubleRegister(cp Coprocessor) (ok bool) {
+	dt, ok := cp.(CoprocessorDoubleTransfer)
+	if !ok {
+		return
+	}
+
+	if !ci.L { // MCRR
+		low, _ := ci.cpu.FetchRegisterFromInstruction(ci.Op.Rd)
+		high, _ := ci.cpu.FetchRegisterFromInstruction(ci.Op.Rn)
+		return dt.MoveToDouble(ci.Op, low, high)
+	}
+
+	// MRRC
+	low, high, ok := dt.MoveFromDouble(ci.Op)
+	if ok {
+		ci.cpu.WriteRegisterFromInstruction(ci.Op.Rd, low)
+		ci.cpu.WriteRegisterFromInstruction(ci.Op.Rn, high)
+	}
+
+	return
+}
+
+// Executes LDC or STC.
+//
+// Parameters:
+//  cp - the attached coprocessor
+//  words - the number of words to transfer
+//
+// Returns: None
+func (ci *coprocessorInstruction) executeTransfer(cp Coprocessor, words uint32) {
+	Rn, _ := ci.cpu.FetchRegisterFromInstruction(ci.Rn)
+	ci.cycles = Cycles{N: 2}
+	if words > 1 {
+		ci.cycles.S = uint64(words) - 1
+	}
+
+	offset := ci.Op.Offset * 4
+	updated := Rn + offset
+	if !ci.U {
+		updated = Rn - offset
+	}
+
+	// Pre-indexed transfers start at the updated address; post-indexed and
+	// unindexed transfers start at the base.
+	address := Rn
+	if ci.P {
+		address = updated
+	}
+
+	for i := uint32(0); i < words; i++ {
+		// A failed access aborts the instruction
+		if ci.L { // LDC
+			data, err := ci.cpu.ReadInWord(address)
+			if err != nil {
+				return
+			}
+			cp.Load(ci.Op, i, data)
+		} else { // STC
+			if ci.cpu.WriteOutWord(address, cp.Store(ci.Op, i)) != nil {
+				return
+			}
+		}
+		address += 4
+	}
+
+	if ci.W {
+		ci.cpu.WriteRegisterFromInstruction(ci.Rn, updated)
+		ci.log.Printf("Write-back: r%d = %#x", ci.Rn, updated)
+	}
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (ci *coprocessorInstruction) Disassemble() (assembly string) {
+	if d, ok := ci.cpu.coprocessors[ci.Op.Number].(CoprocessorDisassembler); ok {
+		if assembly, ok = d.Disassemble(ci.Op); ok {
+			return
+		}
+	}
+
+	cond := ConditionMnemonic(ci.CondCode)
+	switch ci.Kind {
+	case coprocessorDataOperation:
+		assembly = fmt.Sprintf("cdp%s p%d, %d, c%d, c%d, c%d, %d", cond, ci.Op.Number,
+			ci.Op.Opcode1, ci.Op.CRd, ci.Op.CRn, ci.Op.CRm, ci.Op.Opcode2)
+	case coprocessorRegister:
+		mnemonic := "mcr"
+		if ci.L {
+			mnemonic = "mrc"
+		}
+		assembly = fmt.Sprintf("%s%s p%d, %d, r%d, c%d, c%d, %d", mnemonic, cond, ci.Op.Number,
+			ci.Op.Opcode1, ci.Op.Rd, ci.Op.CRn, ci.Op.CRm, ci.Op.Opcode2)
+	case coprocessorDoubleRegister:
+		mnemonic := "mcrr"
+		if ci.L {
+			mnemonic = "mrrc"
+		}
+		assembly = fmt.Sprintf("%s%s p%d, %d, r%d, r%d, c%d", mnemonic, cond, ci.Op.Number,
+			ci.Op.Opcode1, ci.Op.Rd, ci.Op.Rn, ci.Op.CRm)
+	case coprocessorTransfer:
+		mnemonic := "stc"
+		if ci.L {
+			mnemonic = "ldc"
+		}
+		mnemonic += cond
+		if ci.Op.N {
+			mnemonic += "l"
+		}
+
+		sign := ""
+		if !ci.U {
+			sign = "-"
+		}
+		var address string
+		if ci.P {
+			address = fmt.Sprintf("[r%d, #%s%d]", ci.Rn, sign, ci.Op.Offset*4)
+			if ci.W {
+				address += "!"
+			}
+		} else if ci.W {
+			address = fmt.Sprintf("[r%d], #%s%d", ci.Rn, sign, ci.Op.Offset*4)
+		} else {
+			address = fmt.Sprintf("[r%d], {%d}", ci.Rn, ci.Op.Offset)
+		}
+		assembly = fmt.Sprintf("%s p%d, c%d, %s", mnemonic, ci.Op.Number, ci.Op.CRd, address)
+	}
+
+	return
+}
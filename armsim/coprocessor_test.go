@@ -0,0 +1,177 @@
+package armsim
+
+import "testing"
+
+// A coprocessor with 16 registers that transfers two words with LDC/STC
+type testCoprocessor struct {
+	registers [16]uint32
+	ops       []CoprocessorOperation
+}
+
+func (tc *testCoprocessor) DataOperation(op CoprocessorOperation) (ok bool) {
+	if op.Opcode1 == 0x7 {
+		return false
+	}
+	tc.ops = append(tc.ops, op)
+	tc.registers[op.CRd] = tc.registers[op.CRn] + tc.registers[op.CRm]
+	return true
+}
+
+func (tc *testCoprocessor) MoveTo(op CoprocessorOperation, data uint32) (ok bool) {
+	tc.registers[op.CRn] = data
+	return true
+}
+
+func (tc *testCoprocessor) MoveFrom(op CoprocessorOperation) (data uint32, ok bool) {
+	return tc.registers[op.CRn], true
+}
+
+func (tc *testCoprocessor) TransferLength(op CoprocessorOperation) (words uint32, ok bool) {
+	return 2, true
+}
+
+func (tc *testCoprocessor) Load(op CoprocessorOperation, index, data uint32) {
+	tc.registers[op.CRd+index] = data
+}
+
+func (tc *testCoprocessor) Store(op CoprocessorOperation, index uint32) (data uint32) {
+	return tc.registers[op.CRd+index]
+}
+
+func TestCoprocessorRegisterTransfer(t *testing.T) {
+	c := NewComputer(1024, nil)
+	cp := new(testCoprocessor)
+	if err := c.AttachCoprocessor(1, cp); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AttachCoprocessor(16, cp); err == nil {
+		t.Fatal("expected an error for coprocessor 16")
+	}
+
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x60000005)
+	c.ram.WriteWord(0x100, 0xEE010110) // mcr p1, 0, r0, c1, c0, 0
+	c.ram.WriteWord(0x104, 0xEE112110) // mrc p1, 0, r2, c1, c0, 0
+	c.ram.WriteWord(0x108, 0xEE11F110) // mrc p1, 0, r15, c1, c0, 0
+
+	c.Step()
+	if cp.registers[1] != 0x60000005 {
+		t.Fatalf("expected c1 0x60000005, got %#x", cp.registers[1])
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r2); r != 0x60000005 {
+		t.Fatalf("expected r2 0x60000005, got %#x", r)
+	}
+
+	// MRC to r15 only sets the flags
+	c.Step()
+	expectFlags(t, c, false, true, true, false)
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10C {
+		t.Fatalf("expected pc 0x10c, got %#x", pc)
+	}
+}
+
+func TestCoprocessorDataOperation(t *testing.T) {
+	c := NewComputer(1024, nil)
+	cp := new(testCoprocessor)
+	c.AttachCoprocessor(1, cp)
+	cp.registers[2] = 3
+	cp.registers[3] = 4
+
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xEE221183) // cdp p1, 2, c1, c2, c3, 4
+	c.Step()
+	if cp.registers[1] != 7 {
+		t.Fatal("expected c1 7, got", cp.registers[1])
+	}
+	op := cp.ops[0]
+	if op.Number != 1 || op.Opcode1 != 2 || op.Opcode2 != 4 || op.CRd != 1 || op.CRn != 2 || op.CRm != 3 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+
+	// A rejected operation is undefined
+	c.ram.WriteWord(0x104, 0xEE721183) // cdp p1, 7, c1, c2, c3, 4
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+	if lr, _ := c.registers.ReadWord(r14_und); lr != 0x108 {
+		t.Fatalf("expected r14_und 0x108, got %#x", lr)
+	}
+}
+
+func TestCoprocessorLoadStore(t *testing.T) {
+	c := NewComputer(1024, nil)
+	cp := new(testCoprocessor)
+	c.AttachCoprocessor(1, cp)
+
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r3, 0x200)
+	c.ram.WriteWord(0x204, 0x11111111)
+	c.ram.WriteWord(0x208, 0x22222222)
+	c.ram.WriteWord(0x100, 0xEDB32101) // ldc p1, c2, [r3, #4]!
+	c.ram.WriteWord(0x104, 0xEC232102) // stc p1, c2, [r3], #-8
+
+	c.Step()
+	if cp.registers[2] != 0x11111111 || cp.registers[3] != 0x22222222 {
+		t.Fatalf("expected c2, c3 loaded, got %#x, %#x", cp.registers[2], cp.registers[3])
+	}
+	if r, _ := c.registers.ReadWord(r3); r != 0x204 {
+		t.Fatalf("expected r3 0x204, got %#x", r)
+	}
+
+	c.ram.WriteWord(0x204, 0x0)
+	c.ram.WriteWord(0x208, 0x0)
+	c.Step()
+	if word, _ := c.ram.ReadWord(0x208); word != 0x22222222 {
+		t.Fatalf("expected 0x22222222, got %#x", word)
+	}
+	if r, _ := c.registers.ReadWord(r3); r != 0x1FC {
+		t.Fatalf("expected r3 0x1fc, got %#x", r)
+	}
+}
+
+func TestCoprocessorMissing(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.AttachCoprocessor(1, new(testCoprocessor))
+	c.AttachCoprocessor(1, nil)
+
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xEE010110) // mcr p1, 0, r0, c1, c0, 0
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+	if mode := c.Status().Mode; mode != "Undefined" {
+		t.Fatal("expected Undefined mode, got", mode)
+	}
+
+	// SWI is still decoded
+	c.Reset()
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xEF000005)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x8 {
+		t.Fatalf("expected pc 0x8, got %#x", pc)
+	}
+}
+
+func TestCoprocessorDisassemble(t *testing.T) {
+	c := NewComputer(1024, nil)
+	tests := []struct {
+		bits     uint32
+		expected string
+	}{
+		{0xEE221183, "cdp p1, 2, c1, c2, c3, 4"},
+		{0xEE010110, "mcr p1, 0, r0, c1, c0, 0"},
+		{0x1E112110, "mrcne p1, 0, r2, c1, c0, 0"},
+		{0xEDB32101, "ldc p1, c2, [r3, #4]!"},
+		{0xEC232102, "stc p1, c2, [r3], #-8"},
+		{0xECC32105, "stcl p1, c2, [r3], {5}"},
+	}
+	for _, test := range tests {
+		if assembly := Decode(c.cpu, 0x100, test.bits).Disassemble(); assembly != test.expected {
+			t.Errorf("%#08x: expected %q, got %q", test.bits, test.expected, assembly)
+		}
+	}
+}
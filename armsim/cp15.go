@@ -0,0 +1,268 @@
+// Filename: cp15.go
+// Contents: The CP15 struct, an ARMv5 system control coprocessor attached as
+//	coprocessor 15. It holds the ID, control, translation table base (TTBR),
+//	domain access control (DACR), fault status (FSR) and fault address (FAR)
+//	registers, and the cache and TLB operations; the MMU itself is in mmu.go.
+//	CP15 is only accessible in privileged modes.
+
+package armsim
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	cp15ID uint32 = 0x41069265 // Main ID of an ARM926EJ-S
+
+	controlM     uint32 = 1 << 0  // MMU enable
+	controlC     uint32 = 1 << 2  // Data cache enable
+	controlS     uint32 = 1 << 8  // System protection (AP = 0)
+	controlR     uint32 = 1 << 9  // ROM protection (AP = 0)
+	controlI     uint32 = 1 << 12 // Instruction cache enable
+	controlReset uint32 = 0x78    // Bits 3-6 read as one
+)
+
+// An ARMv5 system control coprocessor with an MMU
+type CP15 struct {
+	control uint32 // c1: control register
+	ttbr    uint32 // c2: translation table base
+	dacr    uint32 // c3: domain access control
+	fsr     uint32 // c5: data fault status
+	ifsr    uint32 // c5 (opcode2 1): prefetch fault status
+	far     uint32 // c6: fault address
+
+	// The TLB (replaced round-robin) and its statistics
+	tlb     [tlbSize]tlbEntry
+	tlbNext int
+	hits    uint64
+	misses  uint64
+
+	// The CPU, for the current mode and to read page tables from the bus
+	cpu *CPU
+
+	log *log.Logger
+}
+
+// Initializes a CP15 with the MMU disabled
+//
+// Parameters:
+//  cpu - the CPU it is attached to
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns:
+//  cp - a pointer to the newly initialized CP15
+func NewCP15(cpu *CPU, logOut io.Writer) (cp *CP15) {
+	cp = new(CP15)
+
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+	cp.log = log.New(logOut, "CP15: ", 0)
+	cp.cpu = cpu
+	cp.Reset()
+
+	return
+}
+
+// Resets the registers (disabling the MMU) and flushes the TLB. Caches
+// configured on the CPU (see Computer.EnableInstructionCache) stay enabled.
+func (cp *CP15) Reset() {
+	cp.control = controlReset
+	cp.enableCaches()
+	cp.ttbr, cp.dacr, cp.fsr, cp.ifsr, cp.far = 0, 0, 0, 0, 0
+	cp.flushTLB()
+	cp.hits, cp.misses = 0, 0
+}
+
+// Returns true if the MMU is enabled (control register M bit).
+func (cp *CP15) MMUEnabled() (enabled bool) {
+	return cp.control&controlM != 0
+}
+
+// Returns the fault status and fault address registers (FSR and FAR).
+func (cp *CP15) Fault() (fsr, far uint32) {
+	return cp.fsr, cp.far
+}
+
+// Returns the number of TLB hits and misses since the last reset.
+func (cp *CP15) TLBStatistics() (hits, misses uint64) {
+	return cp.hits, cp.misses
+}
+
+// CP15 has no data operations.
+func (cp *CP15) DataOperation(op CoprocessorOperation) (ok bool) {
+	return false
+}
+
+// MCR: writes a CP15 register or performs a cache or TLB operation.
+//
+// Parameters:
+//  op - the MCR fields (CRn selects the register)
+//  data - the value of Rd
+//
+// Returns:
+//  ok - false for an unknown register or in User mode
+func (cp *CP15) MoveTo(op CoprocessorOperation, data uint32) (ok bool) {
+	if op.Opcode1 != 0 || !cp.cpu.privileged() {
+		return false
+	}
+
+	switch op.CRn {
+	case 1:
+		cp.control = data
+		cp.log.Printf("Control: %#08x (MMU enabled: %v)", data, cp.MMUEnabled())
+	case 2:
+		cp.ttbr = data &^ 0x3FFF
+	case 3:
+		cp.dacr = data
+	case 5:
+		if op.Opcode2 == 1 {
+			cp.ifsr = data
+		} else {
+			cp.fsr = data
+		}
+	case 6:
+		cp.far = data
+	case 7:
+		cp.cacheOperation(op, data)
+	case 8:
+		// TLB operations: CRm 5, 6 and 7 are the instruction, data and unified
+		// TLBs (there is one TLB)
+		if op.CRm < 5 || op.CRm > 7 {
+			return false
+		}
+		switch op.Opcode2 {
+		case 0:
+			cp.flushTLB()
+		case 1:
+			cp.flushTLBEntry(data)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Performs a cache operation (MCR to c7) on the CPU's instruction and data
+// caches. CRm selects the operation and opcode2 its scope: the entire cache
+// (0), the line holding a virtual address (1) or a set/way index (2).
+// Operations on a cache that isn't configured, and those with nothing to model
+// (such as draining the write buffer), do nothing.
+//
+// Parameters:
+//  op - the MCR fields
+//  data - the value of Rd (an address or set/way index)
+func (cp *CP15) cacheOperation(op CoprocessorOperation, data uint32) {
+	icache, dcache := cp.cpu.icache, cp.cpu.dcache
+
+	switch op.CRm {
+	case 5:
+		// Invalidate the instruction cache
+		cp.maintain(icache, op.Opcode2, data, false, true)
+	case 6:
+		// Invalidate the data cache
+		cp.maintain(dcache, op.Opcode2, data, false, true)
+	case 7:
+		// Invalidate both caches
+		if op.Opcode2 == 0 {
+			cp.maintain(icache, 0, data, false, true)
+			cp.maintain(dcache, 0, data, false, true)
+		}
+	case 10:
+		// Clean the data cache
+		if op.Opcode2 != 0 {
+			cp.maintain(dcache, op.Opcode2, data, true, false)
+		}
+	case 14:
+		// Clean and invalidate the data cache
+		if op.Opcode2 != 0 {
+			cp.maintain(dcache, op.Opcode2, data, true, true)
+		}
+	}
+}
+
+// Cleans and/or invalidates the entire cache, the line holding a virtual
+// address or the line at a set/way index (see cacheOperation).
+func (cp *CP15) maintain(cache *Cache, opcode2, data uint32, clean, invalidate bool) {
+	if cache == nil {
+		return
+	}
+
+	switch opcode2 {
+	case 0:
+		cache.invalidate()
+	case 1:
+		// The caches hold physical addresses
+		if physical, err := cp.translate(data, PermRead, true); err == nil {
+			cache.maintain(cache.lookup(physical), clean, invalidate)
+		}
+	case 2:
+		cache.maintain(cache.setWay(data), clean, invalidate)
+	}
+}
+
+// Sets the control register's I and C bits for the caches configured on the
+// CPU.
+func (cp *CP15) enableCaches() {
+	if cp.cpu.icache != nil {
+		cp.control |= controlI
+	}
+	if cp.cpu.dcache != nil {
+		cp.control |= controlC
+	}
+}
+
+// MRC: reads a CP15 register.
+//
+// Parameters:
+//  op - the MRC fields (CRn selects the register)
+//
+// Returns:
+//  data - the register's value
+//  ok - false for an unknown register or in User mode
+func (cp *CP15) MoveFrom(op CoprocessorOperation) (data uint32, ok bool) {
+	if op.Opcode1 != 0 || !cp.cpu.privileged() {
+		return
+	}
+
+	ok = true
+	switch {
+	case op.CRn == 0 && op.Opcode2 == 0:
+		data = cp15ID
+	case op.CRn == 1:
+		data = cp.control
+	case op.CRn == 2:
+		data = cp.ttbr
+	case op.CRn == 3:
+		data = cp.dacr
+	case op.CRn == 5 && op.Opcode2 == 1:
+		data = cp.ifsr
+	case op.CRn == 5:
+		data = cp.fsr
+	case op.CRn == 6:
+		data = cp.far
+	default:
+		ok = false
+	}
+
+	return
+}
+
+// CP15 has no loads or stores.
+func (cp *CP15) TransferLength(op CoprocessorOperation) (words uint32, ok bool) {
+	return 0, false
+}
+
+// CP15 has no loads or stores.
+func (cp *CP15) Load(op CoprocessorOperation, index, data uint32) {
+}
+
+// CP15 has no loads or stores.
+func (cp *CP15) Store(op CoprocessorOperation, index uint32) (data uint32) {
+	return
+}
@@ -0,0 +1,136 @@
+package armsim
+
+import "testing"
+
+func TestCP15Registers(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x4567)
+	c.ram.WriteWord(0x100, 0xEE105F10) // mrc p15, 0, r5, c0, c0, 0
+	c.ram.WriteWord(0x104, 0xEE020F10) // mcr p15, 0, r0, c2, c0, 0
+	c.ram.WriteWord(0x108, 0xEE126F10) // mrc p15, 0, r6, c2, c0, 0
+	c.ram.WriteWord(0x10C, 0xEE020F10) // mcr p15, 0, r0, c2, c0, 0
+
+	c.Step()
+	if id, _ := c.registers.ReadWord(r5); id != cp15ID {
+		t.Fatalf("expected ID %#x, got %#x", cp15ID, id)
+	}
+	c.Step()
+	c.Step()
+	if ttbr, _ := c.registers.ReadWord(r6); ttbr != 0x4000 {
+		t.Fatalf("expected TTBR 0x4000, got %#x", ttbr)
+	}
+
+	// CP15 is undefined in User mode
+	c.registers.WriteWord(CPSR, User)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != UndefinedVector {
+		t.Fatalf("expected pc %#x, got %#x", UndefinedVector, pc)
+	}
+}
+
+func TestMMU(t *testing.T) {
+	c := NewComputer(0x10000, nil)
+	run := func(mode, instruction uint32) {
+		c.registers.WriteWord(CPSR, mode)
+		c.registers.WriteWord(PC, 0x100)
+		c.ram.WriteWord(0x100, instruction)
+		c.Step()
+	}
+	expectFault := func(vector, fsr, far uint32) {
+		t.Helper()
+		if pc, _ := c.registers.ReadWord(PC); pc != vector {
+			t.Fatalf("expected pc %#x, got %#x", vector, pc)
+		}
+		if vector == PrefetchAbortVector {
+			if c.cp15.ifsr != fsr {
+				t.Fatalf("expected IFSR %#x, got %#x", fsr, c.cp15.ifsr)
+			}
+			return
+		}
+		if s, a := c.cp15.Fault(); s != fsr || a != far {
+			t.Fatalf("expected FSR %#x and FAR %#x, got %#x and %#x", fsr, far, s, a)
+		}
+	}
+	load := func(mode, address uint32) (data uint32) {
+		c.registers.WriteWord(r4, address)
+		run(mode, 0xE5943000) // ldr r3, [r4]
+		data, _ = c.registers.ReadWord(r3)
+		return
+	}
+
+	// With the MMU disabled addresses aren't translated
+	c.ram.WriteWord(0x2010, 0xCAFEF00D)
+	c.ram.WriteWord(0x3010, 0xDEADBEEF)
+	if data := load(System, 0x2010); data != 0xCAFEF00D {
+		t.Fatalf("expected 0xCAFEF00D, got %#x", data)
+	}
+
+	// Page tables at 0x8000 (first level) and 0xC000 (coarse):
+	//  0x000xxxxx - section mapped to itself (domain 0, read/write)
+	//  0x00101xxx - small page mapped to 0x2000 (domain 1, user read-only)
+	//  0x002xxxxx - section in domain 2 (no access)
+	c.ram.WriteWord(0x8000, 0x00000C02)
+	c.ram.WriteWord(0x8004, 0x0000C021)
+	c.ram.WriteWord(0x8008, 0x00000C42)
+	c.ram.WriteWord(0xC004, 0x00002AAA)
+
+	c.registers.WriteWord(r0, 0x8000)
+	run(System, 0xEE020F10) // mcr p15, 0, r0, c2, c0, 0
+	c.registers.WriteWord(r0, 0x5)
+	run(System, 0xEE030F10) // mcr p15, 0, r0, c3, c0, 0
+	c.registers.WriteWord(r0, controlReset|controlM)
+	run(System, 0xEE010F10) // mcr p15, 0, r0, c1, c0, 0
+	if !c.cp15.MMUEnabled() {
+		t.Fatal("expected the MMU to be enabled")
+	}
+
+	// Sections and small pages
+	if data := load(System, 0x2010); data != 0xCAFEF00D {
+		t.Fatalf("expected 0xCAFEF00D, got %#x", data)
+	}
+	if data := load(User, 0x101010); data != 0xCAFEF00D {
+		t.Fatalf("expected 0xCAFEF00D, got %#x", data)
+	}
+	c.registers.WriteWord(r3, 0x12345678)
+	run(System, 0xE5843000) // str r3, [r4]
+	if data, _ := c.ram.ReadWord(0x2010); data != 0x12345678 {
+		t.Fatalf("expected 0x12345678 at 0x2010, got %#x", data)
+	}
+
+	// Permission, translation and domain faults
+	run(User, 0xE5843000) // str r3, [r4]
+	expectFault(DataAbortVector, 0x1F, 0x101010)
+	load(System, 0x100010)
+	expectFault(DataAbortVector, 0x17, 0x100010)
+	load(System, 0x200010)
+	expectFault(DataAbortVector, 0x29, 0x200010)
+	load(System, 0x300010)
+	expectFault(DataAbortVector, 0x05, 0x300010)
+
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(PC, 0x300000)
+	c.Step()
+	expectFault(PrefetchAbortVector, 0x05, 0)
+
+	// Remapping a page needs a TLB flush
+	c.ram.WriteWord(0xC004, 0x00003AAA)
+	if data := load(System, 0x101010); data != 0x12345678 {
+		t.Fatalf("expected the stale translation, got %#x", data)
+	}
+	run(System, 0xEE084F37) // mcr p15, 0, r4, c8, c7, 1
+	if data := load(System, 0x101010); data != 0xDEADBEEF {
+		t.Fatalf("expected 0xDEADBEEF, got %#x", data)
+	}
+	hits, misses := c.cp15.TLBStatistics()
+	if hits == 0 || misses == 0 {
+		t.Fatalf("expected TLB hits and misses, got %d and %d", hits, misses)
+	}
+
+	// Reset disables the MMU
+	c.Reset()
+	if c.cp15.MMUEnabled() {
+		t.Fatal("expected the MMU to be disabled")
+	}
+}
@@ -4,6 +4,7 @@
 package armsim
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -37,10 +38,29 @@ const (
 	SPSR_svc // SPSR for Supervisor
 	_
 	SPSR_irq // SPSR for IRQ
+	r13_und  // Banked r13 for Undefined
+	r14_und  // Banked r14 for Undefined
+	SPSR_und // SPSR for Undefined
+	r13_abt  // Banked r13 for Abort
+	r14_abt  // Banked r14 for Abort
+	SPSR_abt // SPSR for Abort
+	r8_fiq   // Banked r8 for FIQ
+	r9_fiq   // Banked r9 for FIQ
+	r10_fiq  // Banked r10 for FIQ
+	r11_fiq  // Banked r11 for FIQ
+	r12_fiq  // Banked r12 for FIQ
+	r13_fiq  // Banked r13 for FIQ
+	r14_fiq  // Banked r14 for FIQ
+	SPSR_fiq // SPSR for FIQ
+
+	registerBankSize // Size of the register bank in bytes
 
 	SP     = r13     // Stack Pointer
 	SP_irq = r13_irq // Stack Pointer (IRQ mode)
 	SP_svc = r13_svc // Stack Pointer (Supervisor mode)
+	SP_und = r13_und // Stack Pointer (Undefined mode)
+	SP_abt = r13_abt // Stack Pointer (Abort mode)
+	SP_fiq = r13_fiq // Stack Pointer (FIQ mode)
 	LR     = r14     // Link Register
 	PC     = r15     // Program Counter
 
@@ -48,47 +68,111 @@ const (
 
 // Flags
 const (
-	_        = iota      // Ignore first result
-	N uint32 = 32 - iota // Negative Flag
-	Z                    // Zero Flag
-	C                    // Carry Flag
-	V                    // Overflow Flag
-	F = V                // Overflow Flag (alternative spelling)
-	I = 7                // Interrupt Bit
+	_         = iota      // Ignore first result
+	N  uint32 = 32 - iota // Negative Flag
+	Z                     // Zero Flag
+	C                     // Carry Flag
+	V                     // Overflow Flag
+	F  = V                // Overflow Flag (alternative spelling)
+	I  = 7                // Interrupt Bit
+	FQ = 6                // FIQ Disable Bit (the ARM ARM's F bit)
+	T  = 5                // Thumb State Bit
 )
 
 // Modes
 const (
 	User       = iota + 0x10 // PC, R14 to R0, CPSR
-	_                        // FIQ (Not implemented)
+	FIQ                      // PC, R14_fiq to R8_fiq, R7 to R0, CPSR, SPSR_fiq
 	IRQ                      // PC, R14_irq, R13_irq, R12 to R0, CPSR, SPSR_irq
 	Supervisor               // PC, R14_svc, R13_svc, R12 to R0, CPSR, SPSR_svc
+	Abort      = 0x17        // PC, R14_abt, R13_abt, R12 to R0, CPSR, SPSR_abt
+	Undefined  = 0x1B        // PC, R14_und, R13_und, R12 to R0, CPSR, SPSR_und
 	System     = 0x1F        // PC, R14 to R0, CPSR
 )
 
+// Exception vectors
+const (
+	UndefinedVector     = 0x04 // Undefined instruction
+	SWIVector           = 0x08 // Software interrupt
+	PrefetchAbortVector = 0x0C // Instruction fetch from an invalid address
+	DataAbortVector     = 0x10 // Data access to an invalid address
+	IRQVector           = 0x18 // Interrupt request
+	FIQVector           = 0x1C // Fast interrupt request
+)
+
 // A CPU holds references for RAM and registers a CPU needs to function.
 type CPU struct {
 	// A reference to the assigned memory bank
 	ram *Memory
 
+	// The bus that loads, stores and fetches go through (RAM is mapped at 0)
+	bus *Bus
+
 	// A reference to the assigned registers bank
 	registers *Memory
 
-	// A channel for the Keyboard (since we don't have a true bus)
-	keyboard chan byte
+	// The Keyboard device
+	keyboard *Keyboard
 
-	// A channel for the Console (since we don't have a true bus)
-	console chan byte
+	// The Console device
+	console *Console
 
 	// The IRQ pin
 	irq chan bool
 
+	// The FIQ pin
+	fiq chan bool
+
+	// The address of the instruction being executed
+	current uint32
+
+	// Halt instead of taking the Undefined Instruction exception
+	haltOnUndefined bool
+
+	// Raise a Data Abort for unaligned halfword and word accesses instead of
+	// ignoring the low address bits
+	alignmentChecking bool
+
+	// Memory regions configured by the user and from the loaded ELF file's
+	// segments (see regions.go), and whether their permissions are enforced
+	regions            []Region
+	segments           []Region
+	permissionChecking bool
+
+	// Halt on permission faults instead of taking the abort exception
+	haltOnFault bool
+
+	// The error that halted the CPU (nil if none)
+	err error
+
+	// Cycles executed so far (see cycles.go)
+	cycles Cycles
+
+	// Attached coprocessors, by coprocessor number
+	coprocessors [16]Coprocessor
+
+	// The system control coprocessor, whose MMU translates every access (nil
+	// if none is attached)
+	mmu *CP15
+
+	// The instruction and data caches (nil if disabled, see cache.go)
+	icache *Cache
+	dcache *Cache
+
+	// Data watchpoints (see watch.go), the hit that stopped the last step (if
+	// any), and whether an instruction is executing (only its accesses are
+	// watched)
+	watchpoints []Watchpoint
+	watchHit    *WatchHit
+	executing   bool
+
 	// Logging class
 	log    *log.Logger
 	logOut io.Writer
 }
 
-// Initializes a CPU
+// Initializes a CPU with a bus mapping the RAM at address 0, the console at
+// ConsoleAddress and the keyboard at KeyboardAddress
 //
 // Parameters:
 //  ram - a pointer to an initialized Memory struct
@@ -97,8 +181,8 @@ type CPU struct {
 //
 // Returns:
 //  a pointer to the newly created CPU
-func NewCPU(ram *Memory, registers *Memory, keyboard chan byte,
-	console chan byte, logOut io.Writer) (cpu *CPU) {
+func NewCPU(ram *Memory, registers *Memory, keyboard *Keyboard,
+	console *Console, logOut io.Writer) (cpu *CPU) {
 	cpu = new(CPU)
 
 	if logOut == nil {
@@ -120,36 +204,97 @@ func NewCPU(ram *Memory, registers *Memory, keyboard chan byte,
 	cpu.keyboard = keyboard
 	cpu.console = console
 
-	// Setup IRQ
+	// Map the devices and RAM (around the devices if it is larger)
+	cpu.bus = NewBus(logOut)
+	cpu.bus.Map("Console", ConsoleAddress, 1, console)
+	cpu.bus.Map("Keyboard", KeyboardAddress, 1, keyboard)
+	if size := ram.Size(); size <= ConsoleAddress {
+		cpu.bus.Map("RAM", 0, uint32(size), ram)
+	} else {
+		high := uint32(KeyboardAddress + 1)
+		cpu.bus.Map("RAM", 0, ConsoleAddress, ram)
+		cpu.bus.Map("RAM (high)", high, uint32(size-uint64(high)), &window{ram, high})
+	}
+
+	// Setup IRQ and FIQ
 	cpu.irq = make(chan bool, 1)
+	cpu.fiq = make(chan bool, 1)
 
 	return
 }
 
-// Fetches the next instruction and increments the program counter
+// Fetches the next instruction and increments the program counter. An
+// invalid PC raises a Prefetch Abort instead of returning an instruction.
 //
 // Returns:
-//  encoded instruction - 32-bit unsigned integer (i.e., a word)
-func (cpu *CPU) Fetch() (instruction uint32) {
+//  encoded instruction - 32-bit unsigned integer (i.e., a word, or a halfword
+//  in Thumb state)
+//  err - any error that may have occurred (the instruction should not be
+//  executed)
+func (cpu *CPU) Fetch() (instruction uint32, err error) {
 	// Read address stored in the PC
 	address, err := cpu.registers.ReadWord(PC)
 	if err != nil {
-		cpu.log.Panic("Unable to read PC.")
+		cpu.log.Println("ERROR: Unable to read PC.")
+		return
 	}
 	cpu.log.Printf("Current PC: %#x", address)
+	cpu.current = address
 
-	// Read instruction stored at address
-	instruction, err = cpu.ram.ReadWord(address)
+	instruction, err = cpu.readInstruction(address)
 	if err != nil {
-		cpu.log.Panic("Unable to read next instruction.")
+		cpu.fetchAbort(address, err)
+		return
 	}
-	cpu.log.Printf("Instruction fetched: %#x", instruction)
 
 	// Increment PC
-	cpu.registers.WriteWord(PC, address+4)
+	cpu.registers.WriteWord(PC, address+cpu.instructionSize())
 	return
 }
 
+// Reads the instruction stored at an address (a word, or a halfword in Thumb
+// state) without changing the PC.
+//
+// Parameters:
+//  address - the address of the instruction
+//
+// Returns:
+//  instruction - the encoded instruction
+//  err - any error that may have occurred (e.g., a PermissionFault or an
+//  MMUFault)
+func (cpu *CPU) readInstruction(address uint32) (instruction uint32, err error) {
+	if err = cpu.permissionFault(address, PermExecute); err != nil {
+		return
+	}
+	if address, err = cpu.translateFetch(address); err != nil {
+		return
+	}
+
+	if cpu.Thumb() {
+		var halfword uint16
+		halfword, err = cpu.bus.ReadHalfWord(address)
+		instruction = uint32(halfword)
+		cpu.log.Printf("Thumb instruction fetched: %#x", instruction)
+	} else {
+		instruction, err = cpu.bus.ReadWord(address)
+		cpu.log.Printf("Instruction fetched: %#x", instruction)
+	}
+
+	if err == nil {
+		cpu.cacheAccess(cpu.icache, address, false)
+	}
+	return
+}
+
+// Returns the size of an instruction in the current state (4 bytes, or 2 in
+// Thumb state).
+func (cpu *CPU) instructionSize() (size uint32) {
+	if cpu.Thumb() {
+		return 2
+	}
+	return 4
+}
+
 // Decodes an instruction.
 //
 // Parameters:
@@ -159,22 +304,45 @@ func (cpu *CPU) Fetch() (instruction uint32) {
 //	instruction - a decoded instruction of type Instruction
 func (cpu *CPU) Decode(instructionBits uint32) (instruction Instruction) {
 	cpu.log.Println("Decoding...")
+
+	// The PC has already been incremented past the instruction
 	address, _ := cpu.registers.ReadWord(PC)
-	instruction = Decode(cpu, address, instructionBits)
+	if cpu.Thumb() {
+		instruction = DecodeThumb(cpu, address-2, instructionBits)
+	} else {
+		instruction = Decode(cpu, address-4, instructionBits)
+	}
 	return
 }
 
-// Executes an instruction.
+// Executes an instruction and counts its cycles.
 //
 // Parameter: i - Instruction interface
 //
 // Returns: status - bool determining if the CPU should continue executing
 func (cpu *CPU) Execute(i Instruction) (status bool) {
 	cpu.log.Println("Executing...", i.Disassemble())
-	return i.Execute()
+
+	// The PC already holds the address of the next instruction
+	next, _ := cpu.registers.ReadWord(PC)
+	cpu.executing = true
+	status = i.Execute()
+	cpu.executing = false
+
+	cycles := i.timing()
+	if cycles.Total() == 0 {
+		cycles = conditionFailedCycles
+	}
+	if pc, _ := cpu.registers.ReadWord(PC); pc != next {
+		cycles.add(pipelineRefillCycles)
+	}
+	cpu.cycles.add(cycles)
+
+	return
 }
 
 // Fetches a register's value. This function accounts for the fact PC should be R[PC] + 8
+// (or R[PC] + 4 in Thumb state)
 //
 // Parameters:
 //  r - register (equal to one of the constants defined above)
@@ -185,13 +353,53 @@ func (cpu *CPU) FetchRegister(r uint32) (value uint32, err error) {
 	value, err = cpu.registers.ReadWord(cpu.bankedRegister(r))
 
 	// Because of pipelining, any PC access will need to be +8. However, the PC is already
-	// incremented, so it will only be +4.
+	// incremented, so it will only be +4. Thumb instructions are half the size.
 	if r == PC {
-		value += 4
+		if cpu.Thumb() {
+			value += 2
+		} else {
+			value += 4
+		}
 	}
 	return
 }
 
+// Checks the CPSR mode bits to determine if the CPU is in a privileged mode
+// (any mode but User).
+//
+// Returns:
+//  privileged - true in a privileged mode
+func (cpu *CPU) privileged() (privileged bool) {
+	cpsr, _ := cpu.registers.ReadWord(CPSR)
+	return cpsr&0x1F != User
+}
+
+// Checks the CPSR T bit to determine if the CPU is executing Thumb instructions.
+//
+// Returns:
+//  thumb - true in Thumb state, false in ARM state
+func (cpu *CPU) Thumb() (thumb bool) {
+	thumb, _ = cpu.registers.TestFlag(CPSR, T)
+	return
+}
+
+// Branches to an address, using bit 0 to select the instruction set as ARMv5
+// does for BX and for loads into the PC (LDR, LDM and POP): 1 enters Thumb
+// state and 0 ARM state. The address is aligned for the new state.
+//
+// Parameters:
+//  address - the branch target (with the state in bit 0)
+func (cpu *CPU) interwork(address uint32) {
+	thumb := address&1 == 1
+	cpu.registers.SetFlag(CPSR, T, thumb)
+	if thumb {
+		address &= 0xFFFFFFFE
+	} else {
+		address &= 0xFFFFFFFC
+	}
+	cpu.WriteRegister(PC, address)
+}
+
 // Wraps FetchRegister to allow a register index value obtained from an instruction.
 // Parameters and return value are the same as FetchRegister.
 func (cpu *CPU) FetchRegisterFromInstruction(r uint32) (value uint32, err error) {
@@ -216,7 +424,9 @@ func (cpu *CPU) WriteRegisterFromInstruction(r, data uint32) (err error) {
 	return cpu.WriteRegister(r<<2, data)
 }
 
-// Wraps Memory.WriteByte to allow for memory-mapped IO
+// Wraps Bus.StoreByte for instructions. An invalid address, a permission fault
+// (see checkAccess) or an MMU fault raises a Data Abort, and the instruction
+// should stop executing.
 //
 // Parameters:
 //  address - 32-bit address of write location in memory
@@ -225,18 +435,24 @@ func (cpu *CPU) WriteRegisterFromInstruction(r, data uint32) (err error) {
 // Returns:
 //  err - any error that may have occurred
 func (c *CPU) WriteOutByte(address uint32, data byte) (err error) {
-	if address == 0x100001 {
-		c.log.Printf("ERROR: Attempted to write to keyboard...")
-	} else if address == 0x100000 {
-		// add byte to console buffer
-		c.console <- data
-	} else {
-		err = c.ram.WriteByte(address, data)
+	if err = c.checkAccess(address, PermWrite); err != nil {
+		return
+	}
+	physical, err := c.translateData(address, PermWrite)
+	if err != nil {
+		return
+	}
+	if err = c.bus.StoreByte(physical, data); err != nil {
+		c.dataAbort(address)
+		return
 	}
+	c.cacheAccess(c.dcache, physical, true)
 	return
 }
 
-// Wraps Memory.ReadByte to allow for memory-mapped IO
+// Wraps Bus.LoadByte for instructions. An invalid address, a permission fault
+// (see checkAccess) or an MMU fault raises a Data Abort, and the instruction
+// should stop executing.
 //
 // Parameters:
 //  address - 32-bit address of read location in memory
@@ -245,23 +461,167 @@ func (c *CPU) WriteOutByte(address uint32, data byte) (err error) {
 //  data - byte of data at address
 //  err - any error that may have occurred
 func (c *CPU) ReadInByte(address uint32) (data byte, err error) {
-	if address == 0x100000 {
-		c.log.Printf("ERROR: Attempted to read from console...")
+	if err = c.checkAccess(address, PermRead); err != nil {
+		return
+	}
+	physical, err := c.translateData(address, PermRead)
+	if err != nil {
+		return
+	}
+	if data, err = c.bus.LoadByte(physical); err != nil {
+		c.dataAbort(address)
+		return
+	}
+	c.cacheAccess(c.dcache, physical, false)
+	return
+}
+
+// Wraps Bus.WriteHalfWord for instructions. An invalid address, a permission
+// fault (see checkAccess) or an MMU fault raises a Data Abort, and the
+// instruction should stop executing. Bit 0 of the address is ignored (see
+// alignAddress).
+//
+// Parameters:
+//  address - 32-bit address of write location in memory
+//  data - halfword of data to write
+//
+// Returns:
+//  err - any error that may have occurred
+func (c *CPU) WriteOutHalfWord(address uint32, data uint16) (err error) {
+	aligned, err := c.alignAddress(address, 2)
+	if err != nil {
+		return
+	}
+	if err = c.checkAccess(aligned, PermWrite); err != nil {
+		return
+	}
+	physical, err := c.translateData(aligned, PermWrite)
+	if err != nil {
+		return
+	}
+	if err = c.bus.WriteHalfWord(physical, data); err != nil {
+		c.dataAbort(address)
 		return
-	} else if address == 0x100001 {
-		// read char from keyboard
-		if len(c.keyboard) > 0 {
-			data = byte(<-c.keyboard)
-		} else {
-			data = 0
-		}
-	} else {
-		data, err = c.ram.ReadByte(address)
 	}
+	c.cacheAccess(c.dcache, physical, true)
+	return
+}
 
+// Wraps Bus.ReadHalfWord for instructions. An invalid address, a permission
+// fault (see checkAccess) or an MMU fault raises a Data Abort, and the
+// instruction should stop executing. Bit 0 of the address is ignored (see
+// alignAddress).
+//
+// Parameters:
+//  address - 32-bit address of read location in memory
+//
+// Returns:
+//  data - halfword of data at address
+//  err - any error that may have occurred
+func (c *CPU) ReadInHalfWord(address uint32) (data uint16, err error) {
+	aligned, err := c.alignAddress(address, 2)
+	if err != nil {
+		return
+	}
+	if err = c.checkAccess(aligned, PermRead); err != nil {
+		return
+	}
+	physical, err := c.translateData(aligned, PermRead)
+	if err != nil {
+		return
+	}
+	if data, err = c.bus.ReadHalfWord(physical); err != nil {
+		c.dataAbort(address)
+		return
+	}
+	c.cacheAccess(c.dcache, physical, false)
 	return
 }
 
+// Wraps Bus.WriteWord for instructions. An invalid address, a permission fault
+// (see checkAccess) or an MMU fault raises a Data Abort, and the instruction
+// should stop executing. Bits 0-1 of the address are ignored (see
+// alignAddress).
+//
+// Parameters:
+//  address - 32-bit address of write location in memory
+//  data - word of data to write
+//
+// Returns:
+//  err - any error that may have occurred
+func (c *CPU) WriteOutWord(address, data uint32) (err error) {
+	aligned, err := c.alignAddress(address, 4)
+	if err != nil {
+		return
+	}
+	if err = c.checkAccess(aligned, PermWrite); err != nil {
+		return
+	}
+	physical, err := c.translateData(aligned, PermWrite)
+	if err != nil {
+		return
+	}
+	if err = c.bus.WriteWord(physical, data); err != nil {
+		c.dataAbort(address)
+		return
+	}
+	c.cacheAccess(c.dcache, physical, true)
+	return
+}
+
+// Wraps Bus.ReadWord for instructions. An invalid address, a permission fault
+// (see checkAccess) or an MMU fault raises a Data Abort, and the instruction
+// should stop executing. Bits 0-1 of the address are ignored (see
+// alignAddress); LDR and SWP rotate the word themselves.
+//
+// Parameters:
+//  address - 32-bit address of read location in memory
+//
+// Returns:
+//  data - word of data at address
+//  err - any error that may have occurred
+func (c *CPU) ReadInWord(address uint32) (data uint32, err error) {
+	aligned, err := c.alignAddress(address, 4)
+	if err != nil {
+		return
+	}
+	if err = c.checkAccess(aligned, PermRead); err != nil {
+		return
+	}
+	physical, err := c.translateData(aligned, PermRead)
+	if err != nil {
+		return
+	}
+	if data, err = c.bus.ReadWord(physical); err != nil {
+		c.dataAbort(address)
+		return
+	}
+	c.cacheAccess(c.dcache, physical, false)
+	return
+}
+
+// Aligns the address of a halfword or word access as ARMv4/v5 does, by
+// ignoring the low address bits. With alignment checking enabled, an unaligned
+// address raises a Data Abort instead.
+//
+// Parameters:
+//  address - 32-bit address of the access
+//  size - size of the access in bytes (2 or 4)
+//
+// Returns:
+//  aligned - the aligned address
+//  err - an error if the access was aborted
+func (c *CPU) alignAddress(address, size uint32) (aligned uint32, err error) {
+	if address&(size-1) != 0 && c.alignmentChecking {
+		err = fmt.Errorf("Unaligned access to address 0x%08X.", address)
+		c.log.Println(err)
+		c.dataAbort(address)
+		return
+	}
+
+	return address &^ (size - 1), nil
+}
+
 // Banked register locations
 //
 // Parameters:
@@ -271,19 +631,124 @@ func (c *CPU) ReadInByte(address uint32) (data byte, err error) {
 //	actualR - proper address for the register based on mode
 func (cpu *CPU) bankedRegister(r uint32) (actualR uint32) {
 	// Check for banked register
-	if r == r13 || r == r14 || r == SPSR {
+	if (r8 <= r && r <= r14) || r == SPSR {
 		cpsr, _ := cpu.FetchRegister(CPSR)
 		mode := ExtractBits(cpsr, 0, 5)
 
+		// FIQ mode banks r8 to r14
+		if mode == FIQ {
+			cpu.log.Printf("Using banked FIQ register %d...", r)
+			if r == SPSR {
+				return SPSR_fiq
+			}
+			return r8_fiq + r - r8
+		}
+
+		// Other modes bank r13, r14 and SPSR
+		var banked [3]uint32
 		switch mode {
 		case Supervisor:
-			r += 5 << 2
 			cpu.log.Printf("Using banked supervisor register %d...", r)
+			banked = [3]uint32{r13_svc, r14_svc, SPSR_svc}
 		case IRQ:
 			cpu.log.Printf("Using banked IRQ register %d...", r)
-			r += 7 << 2
+			banked = [3]uint32{r13_irq, r14_irq, SPSR_irq}
+		case Undefined:
+			cpu.log.Printf("Using banked undefined register %d...", r)
+			banked = [3]uint32{r13_und, r14_und, SPSR_und}
+		case Abort:
+			cpu.log.Printf("Using banked abort register %d...", r)
+			banked = [3]uint32{r13_abt, r14_abt, SPSR_abt}
+		default:
+			return r
+		}
+
+		switch r {
+		case r13:
+			r = banked[0]
+		case r14:
+			r = banked[1]
+		case SPSR:
+			r = banked[2]
 		}
 	}
 
 	return r
 }
+
+// Enters an exception: saves the CPSR into the SPSR of the new mode, switches
+// to the mode in ARM state, sets the banked link register and jumps to the
+// vector. Entering FIQ mode also sets the F bit.
+//
+// Parameters:
+//  mode - the mode to enter (e.g., Supervisor)
+//  vector - the address of the exception vector
+//  returnAddress - the value for the banked r14
+//  disableIRQ - whether to set the I bit
+func (cpu *CPU) enterException(mode, vector, returnAddress uint32, disableIRQ bool) {
+	cpsr, _ := cpu.FetchRegister(CPSR)
+	cpu.log.Printf("Old CPSR: %032b", cpsr)
+
+	// Set mode bits and return to ARM state
+	newCPSR := cpsr &^ 0x1F &^ (1 << T)
+	newCPSR |= mode
+	if disableIRQ {
+		newCPSR |= 1 << I
+	}
+	if mode == FIQ {
+		newCPSR |= 1 << FQ
+	}
+	cpu.WriteRegister(CPSR, newCPSR)
+	cpu.log.Printf("New CPSR: %032b", newCPSR)
+
+	// Save the CPSR and return address in the new mode's banked registers
+	cpu.WriteRegister(SPSR, cpsr)
+	cpu.WriteRegister(LR, returnAddress)
+
+	// Set PC
+	cpu.WriteRegister(PC, vector)
+}
+
+// Takes the Prefetch Abort exception for an instruction fetched from an invalid
+// address. r14_abt is the address of the aborted instruction + 4.
+//
+// Parameters:
+//  address - the address of the aborted instruction
+func (cpu *CPU) prefetchAbort(address uint32) {
+	cpu.log.Printf("Prefetch abort at address %#08x...", address)
+	cpu.enterException(Abort, PrefetchAbortVector, address+4, true)
+}
+
+// Takes the Data Abort exception for an access to an invalid address. r14_abt
+// is the address of the aborted instruction + 8.
+//
+// Parameters:
+//  address - the address of the failed access
+func (cpu *CPU) dataAbort(address uint32) {
+	cpu.log.Printf("Data abort accessing %#08x (instruction at %#08x)...", address, cpu.current)
+	cpu.enterException(Abort, DataAbortVector, cpu.current+8, true)
+}
+
+// Handles an undefined instruction by either taking the Undefined Instruction
+// exception or halting the CPU with an error (see haltOnUndefined).
+//
+// Parameters:
+//  address - the address of the instruction
+//  instructionBits - the undefined instruction
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues
+func (cpu *CPU) undefinedInstruction(address, instructionBits uint32) (status bool) {
+	if cpu.haltOnUndefined {
+		cpu.err = fmt.Errorf("Undefined instruction 0x%08X at address 0x%08X.", instructionBits, address)
+		cpu.log.Println(cpu.err)
+		return false
+	}
+
+	// r14_und holds the address of the next instruction
+	cpu.log.Printf("Undefined instruction 0x%08X at address 0x%08X...", instructionBits, address)
+	next, _ := cpu.registers.ReadWord(PC)
+	cpu.enterException(Undefined, UndefinedVector, next, true)
+
+	return true
+}
@@ -35,7 +35,7 @@ func TestFetch(t *testing.T) {
 	ram.WriteWord(0x0, test)
 	// Simulate setting PC to 0
 	registers.WriteWord(PC, 0)
-	word = cpu.Fetch()
+	word, _ = cpu.Fetch()
 	if word != test {
 		t.Fatalf("Incorrect word fetched. Expected %#x got %#x", test, word)
 	}
@@ -48,7 +48,7 @@ func TestFetch(t *testing.T) {
 	test = 0xFF00FF15
 	// PC is now at 0x4
 	ram.WriteWord(0x4, test)
-	word = cpu.Fetch()
+	word, _ = cpu.Fetch()
 	if word != test {
 		t.Fatalf("Incorrect word fetched. Expected %#x got %#x", test, word)
 	}
@@ -0,0 +1,71 @@
+// Filename: cycles.go
+// Contents: The Cycles struct and helpers for the ARM7TDMI cycle model. Each
+//	instruction records its cost in S (sequential), N (non-sequential), I
+//	(internal) and C (coprocessor) cycles when it executes; the CPU adds the
+//	pipeline refill (1S + 1N) whenever the PC is written.
+
+package armsim
+
+import (
+	"fmt"
+)
+
+// Cycle counts by type
+type Cycles struct {
+	S uint64 // Sequential memory cycles
+	N uint64 // Non-sequential memory cycles
+	I uint64 // Internal cycles
+	C uint64 // Coprocessor register transfer cycles
+}
+
+// Returns the total number of cycles.
+func (c Cycles) Total() (total uint64) {
+	return c.S + c.N + c.I + c.C
+}
+
+// Builds a summary string (e.g., "S=3 N=1 I=1 C=0").
+func (c Cycles) String() string {
+	return fmt.Sprintf("S=%d N=%d I=%d C=%d", c.S, c.N, c.I, c.C)
+}
+
+// Adds cycle counts.
+//
+// Parameters:
+//  other - the cycles to add
+//
+// Returns: None
+func (c *Cycles) add(other Cycles) {
+	c.S += other.S
+	c.N += other.N
+	c.I += other.I
+	c.C += other.C
+}
+
+// The cost of an instruction that failed its condition
+var conditionFailedCycles = Cycles{S: 1}
+
+// The cost of refilling the pipeline after the PC is written
+var pipelineRefillCycles = Cycles{S: 1, N: 1}
+
+// The cost of entering an IRQ or FIQ handler
+var interruptCycles = Cycles{S: 2, N: 1}
+
+// Returns the number of internal cycles (m) a multiply takes with the given
+// multiplier. The multiplier array terminates early when the remaining bits
+// are all zeros (or, for signed multiplies, all ones).
+//
+// Parameters:
+//  rs - the multiplier (Rs)
+//  unsigned - true for UMULL and UMLAL, which only terminate on zeros
+//
+// Returns:
+//  m - 1 to 4 internal cycles
+func multiplyCycles(rs uint32, unsigned bool) (m uint64) {
+	for m = 1; m < 4; m++ {
+		rest := rs >> (8 * m)
+		if rest == 0 || (!unsigned && rest == 0xFFFFFFFF>>(8*m)) {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package armsim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultiplyCycles(t *testing.T) {
+	tests := []struct {
+		rs       uint32
+		unsigned bool
+		expected uint64
+	}{
+		{0x0, false, 1},
+		{0xFF, false, 1},
+		{0xFFFFFF80, false, 1},
+		{0x100, false, 2},
+		{0xFFFF8000, false, 2},
+		{0x10000, false, 3},
+		{0x1000000, false, 4},
+		{0x80000000, false, 4},
+		{0xFFFFFFFF, true, 4},
+		{0xFFFF, true, 2},
+	}
+	for _, test := range tests {
+		if m := multiplyCycles(test.rs, test.unsigned); m != test.expected {
+			t.Errorf("%#x (unsigned %v): expected %d, got %d", test.rs, test.unsigned, test.expected, m)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r3, 0x200)
+	c.ram.WriteWord(0x100, 0xE3A00001) // mov r0, #1
+	c.ram.WriteWord(0x104, 0x03A00002) // moveq r0, #2
+	c.ram.WriteWord(0x108, 0xEA000000) // b 0x110
+	c.ram.WriteWord(0x110, 0xE0010190) // mul r1, r0, r1
+	c.ram.WriteWord(0x114, 0xE5932000) // ldr r2, [r3]
+	c.ram.WriteWord(0x118, 0xE8930006) // ldmia r3, {r1, r2}
+	c.ram.WriteWord(0x11C, 0xE8830006) // stmia r3, {r1, r2}
+
+	tests := []Cycles{
+		{S: 1},             // data processing
+		{S: 1},             // condition failed
+		{S: 2, N: 1},       // branch and pipeline refill
+		{S: 1, I: 1},       // multiply by zero
+		{S: 1, N: 1, I: 1}, // single load
+		{S: 2, N: 1, I: 1}, // load multiple
+		{S: 1, N: 2},       // store multiple
+	}
+	var expected Cycles
+	for i, test := range tests {
+		c.Step()
+		expected.add(test)
+		if cycles := c.Cycles(); cycles != expected {
+			t.Fatalf("step %d: expected %s, got %s", i+1, expected, cycles)
+		}
+	}
+	if total := c.Status().Cycles; total != 17 {
+		t.Fatal("expected 17 cycles, got", total)
+	}
+
+	c.Reset()
+	if cycles := c.Cycles(); cycles.Total() != 0 {
+		t.Fatal("expected no cycles after reset, got", cycles)
+	}
+}
+
+func TestCycleTracing(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE3A00001) // mov r0, #1
+	c.Step()
+	if strings.Contains(c.Trace(0x100), "cycles=") {
+		t.Fatal("expected no cycle counts in the default trace")
+	}
+
+	c.EnableCycleTracing()
+	if trace := c.Trace(0x100); !strings.Contains(trace, "cycles=1 (S=1 N=0 I=0 C=0)") {
+		t.Fatal("expected cycle counts in the trace, got", trace)
+	}
+	c.DisableCycleTracing()
+}
@@ -15,6 +15,7 @@ type Instruction interface {
 	Execute() (status bool)
 	Disassemble() string
 	decode(base *baseInstruction)
+	timing() (cycles Cycles)
 }
 
 // Holds values typical to all ARM instructions.
@@ -29,6 +30,13 @@ type baseInstruction struct {
 	log     *log.Logger
 	shifter *BarrelShifter
 	cpu     *CPU
+	cycles  Cycles // Cost of the last execution (zero if the condition failed)
+}
+
+// Returns the cycles taken by the last execution of the instruction (zero if
+// its condition failed).
+func (bi *baseInstruction) timing() (cycles Cycles) {
+	return bi.cycles
 }
 
 // Decodes an instruction.
@@ -79,29 +87,64 @@ func (bi *baseInstruction) BuildFromBase() (instruction Instruction) {
 	// Check type of instruction and call proper decode method
 	switch bi.Type {
 	case 0x0, 0x1:
-		// Check for BX
-		if !(ExtractShiftBits(bi.InstructionBits, 21, 25) == 0x9) {
-			bi.log.Printf("Data Processing")
-			instruction = new(dataInstruction)
-		} else {
+		if bi.InstructionBits&0x0FFFFFF0 == 0x012FFF10 {
+			// BX (cond 0001 0010 1111 1111 1111 0001 Rm), which would otherwise
+			// look like a TEQ
 			bi.log.Printf("Branch (BX)")
 			instruction = new(branchInstruction)
+		} else if bi.InstructionBits&0x0F0000F0 == 0x00000090 {
+			// Multiply (cond 0000 xxxx xxxx xxxx xxxx 1001 Rm)
+			bi.log.Printf("Multiply")
+			instruction = new(multiplyInstruction)
+		} else if bi.InstructionBits&0x0FB00FF0 == 0x01000090 {
+			// Swap (cond 0001 0B00 Rn Rd 0000 1001 Rm)
+			bi.log.Printf("Swap")
+			instruction = new(swapInstruction)
+		} else if bi.InstructionBits&0x0E000090 == 0x00000090 && ExtractShiftBits(bi.InstructionBits, 5, 7) != 0 {
+			// Miscellaneous loads and stores (cond 000P UIWL Rn Rd xxxx 1SH1 xxxx)
+			bi.log.Printf("Load/Store: Halfword and Signed Byte")
+			instruction = new(loadStoreHalfwordInstruction)
+		} else if bi.InstructionBits&0x0FBF0FFF == 0x010F0000 ||
+			bi.InstructionBits&0x0FB0FFF0 == 0x0120F000 ||
+			bi.InstructionBits&0x0FB0F000 == 0x0320F000 {
+			// MRS, MSR (register) and MSR (immediate), which would otherwise look
+			// like a TST, TEQ, CMP or CMN without the S bit
+			bi.log.Printf("PSR Transfer")
+			instruction = new(psrTransferInstruction)
+		} else {
+			bi.log.Printf("Data Processing")
+			instruction = new(dataInstruction)
 		}
 	case 0x2:
 		bi.log.Printf("Load/Store: Immediate Offset")
 		instruction = new(loadStoreInstruction)
 	case 0x3:
-		bi.log.Printf("Load/Store: Register Offset")
-		instruction = new(loadStoreInstruction)
+		if ExtractShiftBits(bi.InstructionBits, 4, 5) == 1 {
+			// cond 011x xxxx xxxx xxxx xxxx xxx1 xxxx is architecturally undefined
+			bi.log.Printf("Undefined")
+			instruction = new(unimplementedInstruction)
+		} else {
+			bi.log.Printf("Load/Store: Register Offset")
+			instruction = new(loadStoreInstruction)
+		}
 	case 0x4:
 		bi.log.Printf("Load/Store: Multiple")
 		instruction = new(loadStoreMultipleInstruction)
 	case 0x5:
 		bi.log.Printf("Branch")
 		instruction = new(branchInstruction)
+	case 0x6:
+		bi.log.Printf("Coprocessor: Load/Store")
+		instruction = new(coprocessorInstruction)
 	case 0x7:
-		bi.log.Printf("Software Interrupt")
-		instruction = new(swiInstruction)
+		if ExtractShiftBits(bi.InstructionBits, 24, 25) == 1 {
+			bi.log.Printf("Software Interrupt")
+			instruction = new(swiInstruction)
+		} else {
+			// CDP (bit 4 clear), MCR and MRC (bit 4 set)
+			bi.log.Printf("Coprocessor: Data Operation/Register Transfer")
+			instruction = new(coprocessorInstruction)
+		}
 	default:
 		bi.log.Printf("Unknown")
 		instruction = new(unimplementedInstruction)
@@ -124,17 +167,22 @@ type dataInstruction struct {
 }
 
 const (
-	AND byte = 0x0  // 0000
-	EOR      = 0x1  // 0001
-	SUB      = 0x2  // 0010
-	RSB      = 0x3  // 0011
-	ADD      = 0x4  // 0100
-	CMP      = 0xA  // 1010
-	ORR      = 0xC  // 1100
-	BIC      = 0xE  // 1110
-	MOV      = 0xD  // 1101
-	MNV      = 0xF  // 1111
-	MUL      = 0x10 // 1 0000 (custom opcode)
+	AND byte = 0x0 // 0000
+	EOR      = 0x1 // 0001
+	SUB      = 0x2 // 0010
+	RSB      = 0x3 // 0011
+	ADD      = 0x4 // 0100
+	ADC      = 0x5 // 0101
+	SBC      = 0x6 // 0110
+	RSC      = 0x7 // 0111
+	TST      = 0x8 // 1000
+	TEQ      = 0x9 // 1001
+	CMP      = 0xA // 1010
+	CMN      = 0xB // 1011
+	ORR      = 0xC // 1100
+	BIC      = 0xE // 1110
+	MOV      = 0xD // 1101
+	MNV      = 0xF // 1111
 )
 
 // Decodes a data instruction
@@ -155,11 +203,6 @@ func (di *dataInstruction) decode(base *baseInstruction) {
 	di.Opcode = byte(ExtractShiftBits(di.InstructionBits, 21, 25))
 	di.log.Printf("Opcode bits: %04b", di.Opcode)
 
-	// Check for MUL
-	if di.I == false && ExtractShiftBits(di.InstructionBits, 4, 5) == 1 && ExtractShiftBits(di.InstructionBits, 7, 8) == 1 {
-		di.Opcode = MUL
-	}
-
 	// Get Operand2
 	di.Operand2 = ExtractShiftBits(di.InstructionBits, 0, 12)
 	di.log.Printf("Op2 bits: %012b", di.Operand2)
@@ -189,72 +232,113 @@ func (di *dataInstruction) Execute() (status bool) {
 		return true
 	}
 
-	shifter_operand := di.shifter.Shift()
+	// 1S, plus 1I for a register-specified shift
+	di.cycles = Cycles{S: 1}
+	if !di.I && ExtractShiftBits(di.InstructionBits, 4, 5) == 1 {
+		di.cycles.I = 1
+	}
+
+	c, _ := di.cpu.registers.TestFlag(CPSR, C)
+	shifter_operand, shifter_carry_out := di.shifter.ShiftWithCarry(c)
 	rn, _ := di.cpu.FetchRegisterFromInstruction(di.Rn)
 
+	var result uint32
+	carry, overflow := shifter_carry_out, false
+	arithmetic, writeback := false, true
+
 	// Ascertain specific instruction
 	switch di.Opcode {
-	case MOV, MNV:
-		// Negate for MNV
-		if di.Opcode == MNV {
-			shifter_operand ^= 0xFFFFFFFF
-		}
-		di.cpu.WriteRegisterFromInstruction(di.Rd, shifter_operand)
-
-		if di.S {
-			// MOVS for r15
-			spsr, _ := di.cpu.FetchRegister(SPSR)
-			di.log.Printf("New CPSR: %b", spsr)
-			di.cpu.WriteRegister(CPSR, spsr)
-		}
-
+	case MOV:
+		// Rd = shifter_operand
+		result = shifter_operand
+	case MNV:
+		// Rd = NOT shifter_operand
+		result = ^shifter_operand
 	case ADD:
 		// Rd = Rn + shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn+shifter_operand)
+		result, carry, overflow = addWithCarry(rn, shifter_operand, false)
+		arithmetic = true
 	case SUB:
 		// Rd = Rn - shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn-shifter_operand)
+		result, carry, overflow = addWithCarry(rn, ^shifter_operand, true)
+		arithmetic = true
 	case RSB:
 		// Rd = shifter_operand - Rn
-		di.cpu.WriteRegisterFromInstruction(di.Rd, shifter_operand-rn)
+		result, carry, overflow = addWithCarry(shifter_operand, ^rn, true)
+		arithmetic = true
+	case ADC:
+		// Rd = Rn + shifter_operand + C Flag
+		result, carry, overflow = addWithCarry(rn, shifter_operand, c)
+		arithmetic = true
+	case SBC:
+		// Rd = Rn - shifter_operand - NOT(C Flag)
+		result, carry, overflow = addWithCarry(rn, ^shifter_operand, c)
+		arithmetic = true
+	case RSC:
+		// Rd = shifter_operand - Rn - NOT(C Flag)
+		result, carry, overflow = addWithCarry(shifter_operand, ^rn, c)
+		arithmetic = true
 	case AND:
 		// Rd = Rn AND shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn&shifter_operand)
+		result = rn & shifter_operand
 	case EOR:
 		// Rd = Rn XOR shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn^shifter_operand)
+		result = rn ^ shifter_operand
 	case ORR:
 		// Rd = Rn OR shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn|shifter_operand)
+		result = rn | shifter_operand
 	case BIC:
 		// Rd = Rn AND NOT shifter_operand
-		di.cpu.WriteRegisterFromInstruction(di.Rd, rn&^shifter_operand)
-	case MUL:
-		// Rd = Rm * Rs
-		// This instruction is highly irregular, so the actual calculation is:
-		// Rn = Rm * Rs
-		di.cpu.WriteRegisterFromInstruction(di.Rn, di.shifter.GetRm()*di.shifter.GetRs())
+		result = rn &^ shifter_operand
+	case TST:
+		// alu_out = Rn AND shifter_operand
+		result = rn & shifter_operand
+		writeback = false
+	case TEQ:
+		// alu_out = Rn XOR shifter_operand
+		result = rn ^ shifter_operand
+		writeback = false
 	case CMP:
 		// alu_out = Rn - shifter_operand
-		alu_out := rn - shifter_operand
+		result, carry, overflow = addWithCarry(rn, ^shifter_operand, true)
+		arithmetic, writeback = true, false
+	case CMN:
+		// alu_out = Rn + shifter_operand
+		result, carry, overflow = addWithCarry(rn, shifter_operand, false)
+		arithmetic, writeback = true, false
+	default:
+		di.log.Printf("Unknown Opcode: %04b", di.Opcode)
+		return true
+	}
+
+	if writeback {
+		di.cpu.WriteRegisterFromInstruction(di.Rd, result)
+	}
 
-		// N Flag = alu_out[31]
-		di.cpu.registers.SetFlag(CPSR, N, alu_out>>31 == 1)
+	if !di.S {
+		return true
+	}
 
-		// Z Flag = if alu_out == 0 then 1 else 0
-		di.cpu.registers.SetFlag(CPSR, Z, alu_out == 0)
+	if writeback && di.Rd == 15 {
+		// Returning from an exception (e.g., MOVS pc, lr): CPSR = SPSR
+		spsr, _ := di.cpu.FetchRegister(SPSR)
+		di.log.Printf("New CPSR: %b", spsr)
+		di.cpu.WriteRegister(CPSR, spsr)
+		return true
+	}
 
-		// C Flag = NOT BorrowFrom(Rn - shifter_operand)
-		di.cpu.registers.SetFlag(CPSR, C, shifter_operand <= rn)
+	// N Flag = result[31], Z Flag = if result == 0 then 1 else 0
+	di.setNZ(result)
 
-		// V Flag = OverflowFrom(Rn - shifter_operand)
-		// Shift to sign bits and compare
-		v := (shifter_operand>>31 != rn>>31) && (shifter_operand>>31 == alu_out>>31)
-		di.cpu.registers.SetFlag(CPSR, V, v)
+	// C Flag = shifter_carry_out for logical operations, otherwise
+	// CarryFrom/NOT BorrowFrom the operation
+	di.cpu.registers.SetFlag(CPSR, C, carry)
 
-	default:
-		di.log.Printf("Unknown Opcode: %04b", di.Opcode)
+	// V Flag = OverflowFrom the operation (unaffected by logical operations)
+	if arithmetic {
+		di.cpu.registers.SetFlag(CPSR, V, overflow)
 	}
+
 	return true
 }
 
@@ -274,6 +358,12 @@ func (di *dataInstruction) Disassemble() (assembly string) {
 		assembly += "sub"
 	case RSB:
 		assembly += "rsb"
+	case ADC:
+		assembly += "adc"
+	case SBC:
+		assembly += "sbc"
+	case RSC:
+		assembly += "rsc"
 	case AND:
 		assembly += "and"
 	case EOR:
@@ -282,26 +372,354 @@ func (di *dataInstruction) Disassemble() (assembly string) {
 		assembly += "orr"
 	case BIC:
 		assembly += "bic"
-	case MUL:
-		assembly += "mul"
+	case TST:
+		assembly += "tst"
+	case TEQ:
+		assembly += "teq"
 	case CMP:
 		assembly += "cmp"
+	case CMN:
+		assembly += "cmn"
 	default:
 		assembly += "unk"
 	}
 
-	if di.S && di.Opcode != CMP {
+	// Comparisons always set the flags, so the S is implied
+	compare := di.Opcode >= TST && di.Opcode <= CMN
+
+	if di.S && !compare {
 		assembly += "s"
 	}
 
 	assembly += ConditionMnemonic(di.CondCode)
 
-	if di.Opcode == MUL {
-		// Handle this special case
-		assembly += fmt.Sprintf(" r%d, r%d, r%d", di.Rn, di.shifter.Rn, di.shifter.Rs)
+	switch {
+	case di.Opcode == MOV, di.Opcode == MNV:
+		// Rd, shifter_operand
+		assembly += fmt.Sprintf(" r%d, %s", di.Rd, di.shifter.Disassemble())
+	case compare:
+		// Rn, shifter_operand
+		assembly += fmt.Sprintf(" r%d, %s", di.Rn, di.shifter.Disassemble())
+	default:
+		// Rd, Rn, shifter_operand
+		assembly += fmt.Sprintf(" r%d, r%d, %s", di.Rd, di.Rn, di.shifter.Disassemble())
+	}
+
+	return
+}
+
+// Holds values typical to Multiply instructions (MUL, MLA, UMULL, UMLAL, SMULL
+// and SMLAL).
+type multiplyInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	L      bool // Long bit (64-bit result)
+	Signed bool // U bit (signed long multiply when set)
+	A      bool // Accumulate bit
+	S      bool // S bit
+
+	Rs   uint32 // Multiplier register
+	Rm   uint32 // Multiplicand register
+	RdHi uint32 // Destination register for the high word (long only)
+	RdLo uint32 // Destination register for the low word (long only)
+}
+
+// Decodes a multiply instruction
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (mi *multiplyInstruction) decode(base *baseInstruction) {
+	mi.baseInstruction = base
+	mi.log.SetPrefix("Multiply Instruction (Decode): ")
+
+	// L bit
+	mi.L = ExtractShiftBits(mi.InstructionBits, 23, 24) == 1
+	mi.log.Printf("L bit: %t", mi.L)
+	// U bit
+	mi.Signed = ExtractShiftBits(mi.InstructionBits, 22, 23) == 1
+	mi.log.Printf("U bit: %t", mi.Signed)
+	// A bit
+	mi.A = ExtractShiftBits(mi.InstructionBits, 21, 22) == 1
+	mi.log.Printf("A bit: %t", mi.A)
+	// S bit
+	mi.S = ExtractShiftBits(mi.InstructionBits, 20, 21) == 1
+	mi.log.Printf("S bit: %t", mi.S)
+
+	mi.Rs = ExtractShiftBits(mi.InstructionBits, 8, 12)
+	mi.Rm = ExtractShiftBits(mi.InstructionBits, 0, 4)
+
+	// The register fields don't line up with the other data instructions: Rd
+	// lives in bits 19-16 and Rn in bits 15-12.
+	if mi.L {
+		mi.RdHi = ExtractShiftBits(mi.InstructionBits, 16, 20)
+		mi.RdLo = ExtractShiftBits(mi.InstructionBits, 12, 16)
+		mi.log.Printf("RdHi: %d RdLo: %d", mi.RdHi, mi.RdLo)
+	} else {
+		mi.Rd = ExtractShiftBits(mi.InstructionBits, 16, 20)
+		mi.Rn = ExtractShiftBits(mi.InstructionBits, 12, 16)
+		mi.log.Printf("Rd: %d Rn: %d", mi.Rd, mi.Rn)
+	}
+	mi.log.Printf("Rs: %d Rm: %d", mi.Rs, mi.Rm)
+
+	mi.log.Printf("Decoded: %s", mi.Disassemble())
+
+	return
+}
+
+// Executes a multiply instruction
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (mi *multiplyInstruction) Execute() (status bool) {
+	mi.log.SetPrefix("Multiply Instruction (Execute): ")
+
+	if !ConditionPassed(mi.baseInstruction) {
+		return true
+	}
+
+	rm, _ := mi.cpu.FetchRegisterFromInstruction(mi.Rm)
+	rs, _ := mi.cpu.FetchRegisterFromInstruction(mi.Rs)
+
+	// MUL: 1S + mI; MLA and MULL: 1S + (m+1)I; MLAL: 1S + (m+2)I
+	mi.cycles = Cycles{S: 1, I: multiplyCycles(rs, mi.L && !mi.Signed)}
+	if mi.A {
+		mi.cycles.I++
+	}
+	if mi.L {
+		mi.cycles.I++
+	}
+
+	if !mi.L {
+		// Rd = (Rm * Rs)[31:0] (+ Rn for MLA)
+		result := rm * rs
+		if mi.A {
+			rn, _ := mi.cpu.FetchRegisterFromInstruction(mi.Rn)
+			result += rn
+		}
+		mi.cpu.WriteRegisterFromInstruction(mi.Rd, result)
+
+		if mi.S {
+			// C and V are unaffected
+			mi.setNZ(result)
+		}
+		return true
+	}
+
+	// RdHi:RdLo = Rm * Rs (+ RdHi:RdLo for UMLAL and SMLAL)
+	var result uint64
+	if mi.Signed {
+		result = uint64(int64(int32(rm)) * int64(int32(rs)))
+	} else {
+		result = uint64(rm) * uint64(rs)
+	}
+
+	if mi.A {
+		hi, _ := mi.cpu.FetchRegisterFromInstruction(mi.RdHi)
+		lo, _ := mi.cpu.FetchRegisterFromInstruction(mi.RdLo)
+		result += uint64(hi)<<32 | uint64(lo)
+	}
+
+	mi.cpu.WriteRegisterFromInstruction(mi.RdLo, uint32(result))
+	mi.cpu.WriteRegisterFromInstruction(mi.RdHi, uint32(result>>32))
+
+	if mi.S {
+		// N Flag = RdHi[31], Z Flag = if (RdHi == 0 and RdLo == 0) then 1 else 0
+		mi.cpu.registers.SetFlag(CPSR, N, result>>63 == 1)
+		mi.cpu.registers.SetFlag(CPSR, Z, result == 0)
+	}
+
+	return true
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (mi *multiplyInstruction) Disassemble() (assembly string) {
+	switch {
+	case !mi.L && !mi.A:
+		assembly = "mul"
+	case !mi.L:
+		assembly = "mla"
+	case mi.Signed && mi.A:
+		assembly = "smlal"
+	case mi.Signed:
+		assembly = "smull"
+	case mi.A:
+		assembly = "umlal"
+	default:
+		assembly = "umull"
+	}
+
+	if mi.S {
+		assembly += "s"
+	}
+
+	assembly += ConditionMnemonic(mi.CondCode)
+
+	if mi.L {
+		assembly += fmt.Sprintf(" r%d, r%d, r%d, r%d", mi.RdLo, mi.RdHi, mi.Rm, mi.Rs)
 	} else {
-		assembly += fmt.Sprintf(" r%d, ", di.Rd)
-		assembly += di.shifter.Disassemble()
+		assembly += fmt.Sprintf(" r%d, r%d, r%d", mi.Rd, mi.Rm, mi.Rs)
+		if mi.A {
+			assembly += fmt.Sprintf(", r%d", mi.Rn)
+		}
+	}
+
+	return
+}
+
+// Holds values typical to PSR transfer instructions (MRS and MSR).
+type psrTransferInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	R         bool   // R bit (SPSR when set, otherwise CPSR)
+	msr       bool   // MSR (otherwise MRS)
+	I         bool   // I bit (immediate operand for MSR)
+	FieldMask uint32 // Field mask bits (f, s, x, c)
+	Rm        uint32 // Source register (MSR register form)
+	immediate uint32 // Rotated immediate (MSR immediate form)
+}
+
+// Decodes a PSR transfer instruction
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (pi *psrTransferInstruction) decode(base *baseInstruction) {
+	pi.baseInstruction = base
+	pi.log.SetPrefix("PSR Transfer Instruction (Decode): ")
+
+	// R bit
+	pi.R = ExtractShiftBits(pi.InstructionBits, 22, 23) == 1
+	pi.log.Printf("R bit: %t", pi.R)
+
+	// MSR has bit 21 set
+	pi.msr = ExtractShiftBits(pi.InstructionBits, 21, 22) == 1
+	if !pi.msr {
+		pi.log.Printf("Decoded: %s", pi.Disassemble())
+		return
+	}
+
+	// I bit
+	pi.I = ExtractShiftBits(pi.InstructionBits, 25, 26) == 1
+	pi.log.Printf("I bit: %t", pi.I)
+
+	// Field mask
+	pi.FieldMask = ExtractShiftBits(pi.InstructionBits, 16, 20)
+	pi.log.Printf("Field mask: %04b", pi.FieldMask)
+
+	if pi.I {
+		// 8-bit immediate rotated right by twice the rotate field
+		pi.immediate = ror(ExtractShiftBits(pi.InstructionBits, 0, 8), ExtractShiftBits(pi.InstructionBits, 8, 12)*2)
+		pi.log.Printf("Immediate: %#x", pi.immediate)
+	} else {
+		pi.Rm = ExtractShiftBits(pi.InstructionBits, 0, 4)
+		pi.log.Printf("Rm: %d", pi.Rm)
+	}
+
+	pi.log.Printf("Decoded: %s", pi.Disassemble())
+
+	return
+}
+
+// Executes a PSR transfer instruction
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (pi *psrTransferInstruction) Execute() (status bool) {
+	pi.log.SetPrefix("PSR Transfer Instruction (Execute): ")
+
+	if !ConditionPassed(pi.baseInstruction) {
+		return true
+	}
+
+	pi.cycles = Cycles{S: 1}
+
+	cpsr, _ := pi.cpu.FetchRegister(CPSR)
+	mode := ExtractBits(cpsr, 0, 5)
+
+	if !pi.msr {
+		// MRS: Rd = CPSR or SPSR (SPSR is banked based on the current mode)
+		psr := cpsr
+		if pi.R {
+			psr, _ = pi.cpu.FetchRegister(SPSR)
+		}
+		pi.cpu.WriteRegisterFromInstruction(pi.Rd, psr)
+		return true
+	}
+
+	operand := pi.immediate
+	if !pi.I {
+		operand, _ = pi.cpu.FetchRegisterFromInstruction(pi.Rm)
+	}
+
+	// Build a mask of the bytes selected by the field mask (c = bits 7-0,
+	// x = bits 15-8, s = bits 23-16, f = bits 31-24)
+	var mask uint32
+	for field := uint32(0); field < 4; field++ {
+		if pi.FieldMask&(1<<field) != 0 {
+			mask |= 0xFF << (field * 8)
+		}
+	}
+
+	if pi.R {
+		// User and System modes have no SPSR
+		if mode == User || mode == System {
+			pi.log.Printf("No SPSR in the current mode...ignoring.")
+			return true
+		}
+		spsr, _ := pi.cpu.FetchRegister(SPSR)
+		pi.cpu.WriteRegister(SPSR, spsr&^mask|operand&mask)
+		return true
+	}
+
+	// User mode may only update the condition flags
+	if mode == User {
+		mask &= 0xFF000000
+	}
+
+	cpsr = cpsr&^mask | operand&mask
+	pi.log.Printf("New CPSR: %032b", cpsr)
+	pi.cpu.WriteRegister(CPSR, cpsr)
+
+	return true
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (pi *psrTransferInstruction) Disassemble() (assembly string) {
+	psr := "cpsr"
+	if pi.R {
+		psr = "spsr"
+	}
+
+	if !pi.msr {
+		return fmt.Sprintf("mrs%s r%d, %s", ConditionMnemonic(pi.CondCode), pi.Rd, psr)
+	}
+
+	// Field mask bits 3-0 select the f, s, x and c fields respectively
+	psr += "_"
+	for i, field := range "fsxc" {
+		if pi.FieldMask&(8>>uint(i)) != 0 {
+			psr += string(field)
+		}
+	}
+
+	assembly = fmt.Sprintf("msr%s %s, ", ConditionMnemonic(pi.CondCode), psr)
+	if pi.I {
+		assembly += fmt.Sprintf("#%#x", pi.immediate)
+	} else {
+		assembly += fmt.Sprintf("r%d", pi.Rm)
 	}
 
 	return
@@ -379,37 +797,56 @@ func (lsi *loadStoreInstruction) Execute() (status bool) {
 		return true
 	}
 
+	// LDR: 1S + 1N + 1I; STR: 2N
+	if lsi.L {
+		lsi.cycles = Cycles{S: 1, N: 1, I: 1}
+	} else {
+		lsi.cycles = Cycles{N: 2}
+	}
+
 	var address, base, offset, data uint32
 	var data8 byte
+	var err error
 
 	// Get base and offset
 	base, _ = lsi.cpu.FetchRegisterFromInstruction(lsi.Rn)
 	if !lsi.I {
 		offset = lsi.offset12
 	} else {
-		offset = lsi.shifter.Shift()
+		// A scaled RRX offset uses the C flag
+		c, _ := lsi.cpu.registers.TestFlag(CPSR, C)
+		offset, _ = lsi.shifter.ShiftWithCarry(c)
 	}
 
-	// Pre-Index
+	// Pre-Index (post-index transfers at the unmodified base)
+	address = base
 	if lsi.P {
 		address = lsi.calculateAddress(base, offset)
 		lsi.log.Printf("Pre-Address: %#x", address)
 	}
 
-	// Load or Store
+	// Load or Store (a failed access aborts the instruction)
 	if lsi.L {
 		// Load
 		if lsi.B {
 			// Byte
-			data8, _ = lsi.cpu.ReadInByte(address)
+			data8, err = lsi.cpu.ReadInByte(address)
 			data = uint32(data8)
 		} else {
-			// Word
-			data, _ = lsi.cpu.ram.ReadWord(address)
+			// Word (an unaligned word is rotated)
+			data, err = lsi.cpu.ReadInWord(address)
+			data = rotateUnaligned(data, address)
+		}
+		if err != nil {
+			return true
 		}
 
-		// Write to register
-		lsi.cpu.WriteRegisterFromInstruction(lsi.Rd, data)
+		// Write to register (LDR pc interworks: bit 0 selects Thumb state)
+		if lsi.Rd == 15 {
+			lsi.cpu.interwork(data)
+		} else {
+			lsi.cpu.WriteRegisterFromInstruction(lsi.Rd, data)
+		}
 	} else {
 		// Store
 		data, _ = lsi.cpu.FetchRegisterFromInstruction(lsi.Rd)
@@ -418,10 +855,13 @@ func (lsi *loadStoreInstruction) Execute() (status bool) {
 			// Byte
 			data8 = byte(data)
 			// Write to memory
-			lsi.cpu.WriteOutByte(address, data8)
+			err = lsi.cpu.WriteOutByte(address, data8)
 		} else {
 			// Write to memory
-			lsi.cpu.ram.WriteWord(address, data)
+			err = lsi.cpu.WriteOutWord(address, data)
+		}
+		if err != nil {
+			return true
 		}
 	}
 
@@ -431,8 +871,8 @@ func (lsi *loadStoreInstruction) Execute() (status bool) {
 		lsi.log.Printf("Post-Address: %#x", address)
 	}
 
-	// Writeback
-	if lsi.W {
+	// Writeback (always for post-index)
+	if lsi.W || !lsi.P {
 		lsi.cpu.WriteRegisterFromInstruction(lsi.Rn, address)
 		lsi.log.Printf("Write-back: %#d = %#x", lsi.Rn, address)
 	}
@@ -492,6 +932,287 @@ func (lsi *loadStoreInstruction) calculateAddress(base, offset uint32) (address
 	return
 }
 
+// Holds values typical to the halfword and signed byte Load / Store
+// instructions (LDRH, STRH, LDRSB and LDRSH).
+type loadStoreHalfwordInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	P bool // P bit
+	U bool // U bit
+	I bool // I bit (immediate offset when set)
+	W bool // W bit
+	L bool // L bit
+	S bool // S bit (signed)
+	H bool // H bit (halfword)
+
+	offset8 uint32 // Immediate offset
+	Rm      uint32 // Offset register
+}
+
+// Decodes a halfword or signed byte load/store instruction
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (lsi *loadStoreHalfwordInstruction) decode(base *baseInstruction) {
+	lsi.baseInstruction = base
+	lsi.log.SetPrefix("Load/Store Halfword Decoder: ")
+	// P bit
+	lsi.P = ExtractShiftBits(base.InstructionBits, 24, 25) == 1
+	lsi.log.Printf("P bit: %t", lsi.P)
+	// U bit
+	lsi.U = ExtractShiftBits(base.InstructionBits, 23, 24) == 1
+	lsi.log.Printf("U bit: %t", lsi.U)
+	// I bit
+	lsi.I = ExtractShiftBits(base.InstructionBits, 22, 23) == 1
+	lsi.log.Printf("I bit: %t", lsi.I)
+	// W bit
+	lsi.W = ExtractShiftBits(base.InstructionBits, 21, 22) == 1
+	lsi.log.Printf("W bit: %t", lsi.W)
+	// L bit
+	lsi.L = ExtractShiftBits(base.InstructionBits, 20, 21) == 1
+	lsi.log.Printf("L bit: %t", lsi.L)
+	// S bit
+	lsi.S = ExtractShiftBits(base.InstructionBits, 6, 7) == 1
+	lsi.log.Printf("S bit: %t", lsi.S)
+	// H bit
+	lsi.H = ExtractShiftBits(base.InstructionBits, 5, 6) == 1
+	lsi.log.Printf("H bit: %t", lsi.H)
+
+	if lsi.I {
+		// Immediate offset is split into immedH (bits 11-8) and immedL (bits 3-0)
+		lsi.offset8 = ExtractShiftBits(base.InstructionBits, 8, 12)<<4 | ExtractShiftBits(base.InstructionBits, 0, 4)
+		lsi.log.Printf("Immediate offset: %#x", lsi.offset8)
+	} else {
+		lsi.Rm = ExtractShiftBits(base.InstructionBits, 0, 4)
+		lsi.log.Printf("Rm: %d", lsi.Rm)
+	}
+
+	return
+}
+
+// Executes a halfword or signed byte load/store instruction
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (lsi *loadStoreHalfwordInstruction) Execute() (status bool) {
+	if !ConditionPassed(lsi.baseInstruction) {
+		return true
+	}
+
+	// LDRH: 1S + 1N + 1I; STRH: 2N
+	if lsi.L {
+		lsi.cycles = Cycles{S: 1, N: 1, I: 1}
+	} else {
+		lsi.cycles = Cycles{N: 2}
+	}
+
+	var address, base, offset, data uint32
+	var err error
+
+	// Get base and offset
+	base, _ = lsi.cpu.FetchRegisterFromInstruction(lsi.Rn)
+	if lsi.I {
+		offset = lsi.offset8
+	} else {
+		offset, _ = lsi.cpu.FetchRegisterFromInstruction(lsi.Rm)
+	}
+
+	if lsi.U {
+		address = base + offset
+	} else {
+		address = base - offset
+	}
+
+	// Post-index uses the unmodified base for the transfer
+	transfer := address
+	if !lsi.P {
+		transfer = base
+	}
+	lsi.log.Printf("Address: %#x", transfer)
+
+	// Load or Store (a failed access aborts the instruction)
+	if lsi.L {
+		switch {
+		case lsi.S && lsi.H:
+			// Signed halfword
+			var data16 uint16
+			data16, err = lsi.cpu.ReadInHalfWord(transfer)
+			data = uint32(int32(int16(data16)))
+		case lsi.S:
+			// Signed byte
+			var data8 byte
+			data8, err = lsi.cpu.ReadInByte(transfer)
+			data = uint32(int32(int8(data8)))
+		default:
+			// Unsigned halfword
+			var data16 uint16
+			data16, err = lsi.cpu.ReadInHalfWord(transfer)
+			data = uint32(data16)
+		}
+	} else {
+		// Store halfword
+		data, _ = lsi.cpu.FetchRegisterFromInstruction(lsi.Rd)
+		err = lsi.cpu.WriteOutHalfWord(transfer, uint16(data))
+	}
+	if err != nil {
+		return true
+	}
+
+	// Writeback (always for post-index)
+	if !lsi.P || lsi.W {
+		lsi.cpu.WriteRegisterFromInstruction(lsi.Rn, address)
+		lsi.log.Printf("Write-back: r%d = %#x", lsi.Rn, address)
+	}
+
+	// Write to register
+	if lsi.L {
+		lsi.cpu.WriteRegisterFromInstruction(lsi.Rd, data)
+	}
+
+	return true
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (lsi *loadStoreHalfwordInstruction) Disassemble() (assembly string) {
+	var mnemonic, offset string
+
+	if lsi.L {
+		mnemonic = "ldr"
+	} else {
+		mnemonic = "str"
+	}
+
+	if lsi.S {
+		mnemonic += "s"
+	}
+
+	if lsi.H {
+		mnemonic += "h"
+	} else {
+		mnemonic += "b"
+	}
+
+	mnemonic += ConditionMnemonic(lsi.CondCode)
+
+	if !lsi.U {
+		offset = "-"
+	}
+	if lsi.I {
+		offset = fmt.Sprintf("#%s%d", offset, lsi.offset8)
+	} else {
+		offset = fmt.Sprintf("%sr%d", offset, lsi.Rm)
+	}
+
+	if !lsi.P {
+		// Post-index
+		return fmt.Sprintf("%s r%d, [r%d], %s", mnemonic, lsi.Rd, lsi.Rn, offset)
+	}
+
+	assembly = fmt.Sprintf("%s r%d, [r%d", mnemonic, lsi.Rd, lsi.Rn)
+	if offset != "#0" {
+		assembly += ", " + offset
+	}
+	assembly += "]"
+	if lsi.W {
+		assembly += "!"
+	}
+
+	return
+}
+
+// Holds values typical to Swap instructions (SWP and SWPB).
+type swapInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	B  bool   // B bit
+	Rm uint32 // Source register
+}
+
+// Decodes a swap instruction
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (si *swapInstruction) decode(base *baseInstruction) {
+	si.baseInstruction = base
+	si.log.SetPrefix("Swap Decoder: ")
+
+	// B bit
+	si.B = ExtractShiftBits(base.InstructionBits, 22, 23) == 1
+	si.log.Printf("B bit: %t", si.B)
+
+	// Rm
+	si.Rm = ExtractShiftBits(base.InstructionBits, 0, 4)
+	si.log.Printf("Rm: %d", si.Rm)
+
+	return
+}
+
+// Executes a swap instruction. The old memory contents are read before the
+// new value is written, so Rd and Rm may be the same register.
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (si *swapInstruction) Execute() (status bool) {
+	if !ConditionPassed(si.baseInstruction) {
+		return true
+	}
+
+	si.cycles = Cycles{S: 1, N: 2, I: 1}
+
+	var temp uint32
+
+	address, _ := si.cpu.FetchRegisterFromInstruction(si.Rn)
+	rm, _ := si.cpu.FetchRegisterFromInstruction(si.Rm)
+	si.log.Printf("Swapping r%d with %#x", si.Rm, address)
+
+	// A failed access aborts the instruction
+	if si.B {
+		// Byte
+		data8, err := si.cpu.ReadInByte(address)
+		if err != nil || si.cpu.WriteOutByte(address, byte(rm)) != nil {
+			return true
+		}
+		temp = uint32(data8)
+	} else {
+		// Word (an unaligned word is rotated)
+		var err error
+		temp, err = si.cpu.ReadInWord(address)
+		if err != nil || si.cpu.WriteOutWord(address, rm) != nil {
+			return true
+		}
+		temp = rotateUnaligned(temp, address)
+	}
+
+	si.cpu.WriteRegisterFromInstruction(si.Rd, temp)
+
+	return true
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (si *swapInstruction) Disassemble() (assembly string) {
+	assembly = "swp"
+	if si.B {
+		assembly += "b"
+	}
+	assembly += ConditionMnemonic(si.CondCode)
+
+	return fmt.Sprintf("%s r%d, r%d, [r%d]", assembly, si.Rd, si.Rm, si.Rn)
+}
+
 // Holds values typical to Load / Store Multiple instructions.
 type loadStoreMultipleInstruction struct {
 	*baseInstruction // Embed a general instruction
@@ -549,6 +1270,14 @@ func (lsi *loadStoreMultipleInstruction) Execute() (status bool) {
 	if !ConditionPassed(lsi.baseInstruction) {
 		return true
 	}
+
+	// LDM: nS + 1N + 1I; STM: (n-1)S + 2N
+	n := uint64(lsi.CountSetBits())
+	if lsi.L {
+		lsi.cycles = Cycles{S: n, N: 1, I: 1}
+	} else if n > 0 {
+		lsi.cycles = Cycles{S: n - 1, N: 2}
+	}
 	var address, start_address, end_address, data uint32
 	Rn, _ := lsi.cpu.FetchRegisterFromInstruction(lsi.Rn)
 
@@ -575,22 +1304,67 @@ func (lsi *loadStoreMultipleInstruction) Execute() (status bool) {
 	}
 	lsi.log.Printf("start_address: %#x; end_address: %#x", start_address, end_address)
 
+	// With the S bit, a load including the PC restores the CPSR from the SPSR;
+	// any other form transfers the User mode registers.
+	loadPC := lsi.L && lsi.registerList[15]
+	userBank := lsi.S && !loadPC
+
+	var pc uint32
 	address = start_address
 	for i := 0; i < 16; i++ {
 		if lsi.registerList[i] {
+			r := uint32(i)
+			// A failed access aborts the instruction
 			if lsi.L { // Load
-				data, _ = lsi.cpu.ram.ReadWord(address)
-				lsi.cpu.WriteRegisterFromInstruction(uint32(i), data)
+				data, err := lsi.cpu.ReadInWord(address)
+				if err != nil {
+					return true
+				}
+				if r == 15 {
+					// Written last, after writeback and any CPSR restore
+					pc = data
+				} else if userBank {
+					lsi.cpu.registers.WriteWord(r<<2, data)
+				} else {
+					lsi.cpu.WriteRegisterFromInstruction(r, data)
+				}
 			} else { // Store
-				data, _ = lsi.cpu.FetchRegisterFromInstruction(uint32(i))
-				lsi.cpu.ram.WriteWord(address, data)
+				if userBank && r != 15 {
+					data, _ = lsi.cpu.registers.ReadWord(r << 2)
+				} else {
+					data, _ = lsi.cpu.FetchRegisterFromInstruction(r)
+				}
+				if lsi.cpu.WriteOutWord(address, data) != nil {
+					return true
+				}
 			}
 			address += 4
 		}
 	}
 
-	if lsi.W { // Writeback
-		lsi.cpu.WriteRegister(SP, Rn)
+	// Writeback to the base register (a loaded base takes precedence)
+	if lsi.W && !(lsi.L && lsi.registerList[lsi.Rn]) {
+		lsi.cpu.WriteRegisterFromInstruction(lsi.Rn, Rn)
+		lsi.log.Printf("Write-back: r%d = %#x", lsi.Rn, Rn)
+	}
+
+	if loadPC {
+		if !lsi.S {
+			// LDM and POP {pc} interwork: bit 0 selects Thumb state
+			lsi.cpu.interwork(pc)
+			return true
+		}
+
+		// Exception return: the restored CPSR selects the state
+		spsr, _ := lsi.cpu.FetchRegister(SPSR)
+		lsi.cpu.WriteRegister(CPSR, spsr)
+		lsi.log.Printf("Restored CPSR: %032b", spsr)
+		if lsi.cpu.Thumb() {
+			pc &= 0xFFFFFFFE
+		} else {
+			pc &= 0xFFFFFFFC
+		}
+		lsi.cpu.WriteRegister(PC, pc)
 	}
 
 	return true
@@ -637,6 +1411,9 @@ func (lsi *loadStoreMultipleInstruction) Disassemble() (assembly string) {
 	}
 
 	assembly = fmt.Sprintf("%s %s, {%s}", mnemonic, rn, registers)
+	if lsi.S {
+		assembly += "^"
+	}
 
 	return
 }
@@ -722,6 +1499,9 @@ func (bi *branchInstruction) Execute() (status bool) {
 		return true
 	}
 
+	// 1S (plus the pipeline refill)
+	bi.cycles = Cycles{S: 1}
+
 	if bi.L {
 		pc, _ := bi.cpu.FetchRegister(PC)
 		bi.cpu.WriteRegister(LR, pc-4)
@@ -734,8 +1514,11 @@ func (bi *branchInstruction) Execute() (status bool) {
 		pc, _ := bi.cpu.FetchRegister(PC)
 		newPC = uint32(int32(pc) + bi.Offset)
 	} else {
+		// BX: bit 0 of the target selects Thumb state
 		newPC, _ = bi.cpu.FetchRegisterFromInstruction(bi.Rm)
-		newPC &= 0xFFFFFFFE
+		bi.log.Printf("Branching to %X...", newPC)
+		bi.cpu.interwork(newPC)
+		return true
 	}
 
 	bi.log.Printf("Branching to %X...", newPC)
@@ -801,24 +1584,13 @@ func (swi *swiInstruction) Execute() (status bool) {
 		return true
 	}
 
-	// Set r14_svc
-	pc, _ := swi.cpu.FetchRegister(PC)
-	swi.cpu.WriteRegister(r14_svc, pc-4)
-
-	// Set SPSR_svc
-	cpsr, _ := swi.cpu.FetchRegister(CPSR)
-	swi.cpu.WriteRegister(SPSR_svc, cpsr)
+	// 1S (plus the pipeline refill)
+	swi.cycles = Cycles{S: 1}
 
-	// Set mode bits
-	cpsr &= 0xFFFFFFE0
-	cpsr |= Supervisor
-
-	// Set CPSR
-	swi.cpu.WriteRegister(CPSR, cpsr)
-	swi.log.Printf("New CPSR: %032b", cpsr)
-
-	// Set PC
-	swi.cpu.WriteRegister(PC, 0x8)
+	// r14_svc is the address of the next instruction (ARM or Thumb). IRQs stay
+	// enabled so SWI handlers can wait on keyboard interrupts.
+	next, _ := swi.cpu.registers.ReadWord(PC)
+	swi.cpu.enterException(Supervisor, SWIVector, next, false)
 
 	return true
 }
@@ -830,18 +1602,21 @@ func (swi *swiInstruction) Disassemble() (assembly string) {
 	return fmt.Sprintf("swi #%d", swi.Data)
 }
 
-// Stub class to fake unknown or unimplemented instructions
+// Holds unknown or unimplemented instructions
 type unimplementedInstruction struct {
 	*baseInstruction
 }
 
-// Stub method to fake execution of unimplemented instructions
+// Takes the Undefined Instruction exception (or halts, depending on the CPU)
 func (ui *unimplementedInstruction) Execute() (status bool) {
 	if !ConditionPassed(ui.baseInstruction) {
 		return true
 	}
 
-	return true
+	// 1S + 1I (plus the pipeline refill)
+	ui.cycles = Cycles{S: 1, I: 1}
+
+	return ui.cpu.undefinedInstruction(ui.Address, ui.InstructionBits)
 }
 
 // Stub method to fake decoding of unimplemented instructions
@@ -853,6 +1628,37 @@ func (ui *unimplementedInstruction) decode(base *baseInstruction) {
 // Stub method to fake disassemble of unimplemented instructions
 func (ui *unimplementedInstruction) Disassemble() (assembly string) { return "unk" }
 
+// Sets the N and Z flags in the CPSR based on the result of an operation.
+func (bi *baseInstruction) setNZ(result uint32) {
+	bi.cpu.registers.SetFlag(CPSR, N, result>>31 == 1)
+	bi.cpu.registers.SetFlag(CPSR, Z, result == 0)
+}
+
+// Adds two words and a carry bit (the ARM ARM's AddWithCarry), returning the
+// result along with the unsigned carry out and signed overflow. Subtraction is
+// performed as x + NOT y + 1, so the carry out is NOT BorrowFrom(x - y).
+func addWithCarry(x, y uint32, carryIn bool) (result uint32, carry, overflow bool) {
+	sum := uint64(x) + uint64(y)
+	if carryIn {
+		sum++
+	}
+
+	result = uint32(sum)
+	carry = sum > 0xFFFFFFFF
+	overflow = x>>31 == y>>31 && result>>31 != x>>31
+	return
+}
+
+// Rotates a word loaded from an unaligned address right by 8 times the low
+// address bits, as LDR and SWP do on ARMv4/v5.
+func rotateUnaligned(data, address uint32) uint32 {
+	rotation := 8 * (address & 0x3)
+	if rotation == 0 {
+		return data
+	}
+	return data>>rotation | data<<(32-rotation)
+}
+
 // Conditions
 const (
 	EQ  = iota // Equal
@@ -189,7 +189,7 @@ func TestBIC(t *testing.T) {
 	c := NewComputer(32, nil)
 	c.registers.WriteWord(PC, 0x4)
 	c.registers.WriteWord(r4, 0x10)
-	c.ram.WriteWord(0x4, 0xE2E22030)
+	c.ram.WriteWord(0x4, 0xE3C42030)
 	c.Step()
 	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
 		t.Fatal("expected 0x0, got", word)
@@ -198,7 +198,7 @@ func TestBIC(t *testing.T) {
 	c.Reset()
 	c.registers.WriteWord(PC, 0x4)
 	c.registers.WriteWord(r4, 0x20)
-	c.ram.WriteWord(0x4, 0xE2E22030)
+	c.ram.WriteWord(0x4, 0xE3C42030)
 	c.Step()
 	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
 		t.Fatal("expected 0x0, got", word)
@@ -267,6 +267,811 @@ func TestMUL(t *testing.T) {
 	}
 }
 
+// Helper method to check the CPSR flags after an instruction
+func expectFlags(t *testing.T, c *Computer, n, z, carry, v bool) {
+	fn, _ := c.registers.TestFlag(CPSR, N)
+	fz, _ := c.registers.TestFlag(CPSR, Z)
+	fc, _ := c.registers.TestFlag(CPSR, C)
+	fv, _ := c.registers.TestFlag(CPSR, V)
+	if fn != n || fz != z || fc != carry || fv != v {
+		t.Fatalf("expected NZCV %t %t %t %t, got %t %t %t %t", n, z, carry, v, fn, fz, fc, fv)
+	}
+}
+
+func TestADDS(t *testing.T) {
+	// ADDS r2, r4, #0x30 (unsigned carry)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0xFFFFFFF0)
+	c.ram.WriteWord(0x4, 0xE2942030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x20 {
+		t.Fatalf("expected 0x20, got %#x", word)
+	}
+	expectFlags(t, c, false, false, true, false)
+
+	// Signed overflow
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x7FFFFFF0)
+	c.ram.WriteWord(0x4, 0xE2942030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x80000020 {
+		t.Fatalf("expected 0x80000020, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, true)
+
+	// ADD without the S bit leaves the flags alone
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0xFFFFFFF0)
+	c.ram.WriteWord(0x4, 0xE2842030)
+	c.Step()
+	expectFlags(t, c, false, false, false, false)
+}
+
+func TestSUBS(t *testing.T) {
+	// SUBS r2, r4, #0x30 (zero result, no borrow)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE2542030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, true, false)
+
+	// Borrow
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE2542030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xFFFFFFE0 {
+		t.Fatalf("expected 0xFFFFFFE0, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+
+	// Signed overflow
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x80000000)
+	c.ram.WriteWord(0x4, 0xE2542030)
+	c.Step()
+	expectFlags(t, c, false, false, true, true)
+}
+
+func TestRSBS(t *testing.T) {
+	// RSBS r2, r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x40)
+	c.ram.WriteWord(0x4, 0xE2742030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xFFFFFFF0 {
+		t.Fatalf("expected 0xFFFFFFF0, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE2742030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x20 {
+		t.Fatalf("expected 0x20, got %#x", word)
+	}
+	expectFlags(t, c, false, false, true, false)
+}
+
+func TestANDS(t *testing.T) {
+	// ANDS r2, r4, #0x30 (no rotation, so C is unaffected)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x0F)
+	c.registers.SetFlag(CPSR, C, true)
+	c.registers.SetFlag(CPSR, V, true)
+	c.ram.WriteWord(0x4, 0xE2142030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, true, true)
+
+	// ANDS r2, r4, #0x80000000 (rotated immediate sets C from bit 31)
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0xFFFFFFFF)
+	c.ram.WriteWord(0x4, 0xE2142102)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x80000000 {
+		t.Fatalf("expected 0x80000000, got %#x", word)
+	}
+	expectFlags(t, c, true, false, true, false)
+}
+
+func TestEORS(t *testing.T) {
+	// EORS r2, r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE2342030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, false, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x80000000)
+	c.ram.WriteWord(0x4, 0xE2342030)
+	c.Step()
+	expectFlags(t, c, true, false, false, false)
+}
+
+func TestORRS(t *testing.T) {
+	// ORRS r2, r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x80000000)
+	c.ram.WriteWord(0x4, 0xE3942030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x80000030 {
+		t.Fatalf("expected 0x80000030, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+}
+
+func TestBICS(t *testing.T) {
+	// BICS r2, r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE3D42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, false, false)
+}
+
+func TestMOVS(t *testing.T) {
+	// MOVS r2, r1, lsl #1 (carry out of the shifter)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 0x80000000)
+	c.ram.WriteWord(0x4, 0xE1B02081)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, true, false)
+
+	// MOVS pc, lr (CPSR = SPSR)
+	c.Reset()
+	c.registers.WriteWord(CPSR, IRQ)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r14_irq, 0x10)
+	c.registers.WriteWord(SPSR_irq, System|0x80000000)
+	c.ram.WriteWord(0x4, 0xE1B0F00E)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected PC 0x10, got %#x", pc)
+	}
+	if cpsr, _ := c.registers.ReadWord(CPSR); cpsr != System|0x80000000 {
+		t.Fatalf("expected CPSR to be restored from SPSR, got %#x", cpsr)
+	}
+
+	// SUBS pc, lr, #4 (CPSR = SPSR)
+	c.Reset()
+	c.registers.WriteWord(CPSR, Supervisor)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r14_svc, 0x14)
+	c.registers.WriteWord(SPSR_svc, User)
+	c.ram.WriteWord(0x4, 0xE25EF004)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected PC 0x10, got %#x", pc)
+	}
+	if cpsr, _ := c.registers.ReadWord(CPSR); cpsr != User {
+		t.Fatalf("expected CPSR to be restored from SPSR, got %#x", cpsr)
+	}
+}
+
+func TestMNVS(t *testing.T) {
+	// MVNS r2, #0
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.ram.WriteWord(0x4, 0xE3F02000)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xFFFFFFFF {
+		t.Fatalf("expected 0xFFFFFFFF, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+}
+
+func TestCMP(t *testing.T) {
+	// CMP r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE3540030)
+	c.Step()
+	expectFlags(t, c, false, true, true, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE3540030)
+	c.Step()
+	expectFlags(t, c, true, false, false, false)
+}
+
+func TestADC(t *testing.T) {
+	// ADC r2, r4, #0x30 (carry in)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.registers.SetFlag(CPSR, C, true)
+	c.ram.WriteWord(0x4, 0xE2A42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x41 {
+		t.Fatalf("expected 0x41, got %#x", word)
+	}
+	expectFlags(t, c, false, false, true, false)
+
+	// ADCS r2, r4, #0x30 (carry in and carry out)
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0xFFFFFFCF)
+	c.registers.SetFlag(CPSR, C, true)
+	c.ram.WriteWord(0x4, 0xE2B42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, true, false)
+
+	if a := Decode(c.cpu, 0, 0xE2B42030).Disassemble(); a != "adcs r2, r4, #48" {
+		t.Fatal("expected 'adcs r2, r4, #48', got", a)
+	}
+}
+
+func TestSBC(t *testing.T) {
+	// SBCS r2, r4, #0x30 (C clear borrows one more)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x40)
+	c.ram.WriteWord(0x4, 0xE2D42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xF {
+		t.Fatalf("expected 0xF, got %#x", word)
+	}
+	expectFlags(t, c, false, false, true, false)
+
+	// C set means no extra borrow
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.registers.SetFlag(CPSR, C, true)
+	c.ram.WriteWord(0x4, 0xE2D42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x0 {
+		t.Fatalf("expected 0x0, got %#x", word)
+	}
+	expectFlags(t, c, false, true, true, false)
+
+	if a := Decode(c.cpu, 0, 0xE2D42030).Disassemble(); a != "sbcs r2, r4, #48" {
+		t.Fatal("expected 'sbcs r2, r4, #48', got", a)
+	}
+}
+
+func TestRSC(t *testing.T) {
+	// RSCS r2, r4, #0x30 (C clear borrows one more)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE2F42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xFFFFFFFF {
+		t.Fatalf("expected 0xFFFFFFFF, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.registers.SetFlag(CPSR, C, true)
+	c.ram.WriteWord(0x4, 0xE2F42030)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x20 {
+		t.Fatalf("expected 0x20, got %#x", word)
+	}
+	expectFlags(t, c, false, false, true, false)
+
+	if a := Decode(c.cpu, 0, 0xE2F42030).Disassemble(); a != "rscs r2, r4, #48" {
+		t.Fatal("expected 'rscs r2, r4, #48', got", a)
+	}
+}
+
+func TestTST(t *testing.T) {
+	// TST r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x0F)
+	c.registers.WriteWord(r0, 0xABC)
+	c.ram.WriteWord(0x4, 0xE3140030)
+	c.Step()
+	expectFlags(t, c, false, true, false, false)
+	if word, _ := c.registers.ReadWord(r0); word != 0xABC {
+		t.Fatalf("TST should not write a result, r0 is %#x", word)
+	}
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE3140030)
+	c.Step()
+	expectFlags(t, c, false, false, false, false)
+
+	if a := Decode(c.cpu, 0, 0xE3140030).Disassemble(); a != "tst r4, #48" {
+		t.Fatal("expected 'tst r4, #48', got", a)
+	}
+}
+
+func TestTEQ(t *testing.T) {
+	// TEQ r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x30)
+	c.ram.WriteWord(0x4, 0xE3340030)
+	c.Step()
+	expectFlags(t, c, false, true, false, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x80000000)
+	c.ram.WriteWord(0x4, 0xE3340030)
+	c.Step()
+	expectFlags(t, c, true, false, false, false)
+
+	if a := Decode(c.cpu, 0, 0xE3340030).Disassemble(); a != "teq r4, #48" {
+		t.Fatal("expected 'teq r4, #48', got", a)
+	}
+}
+
+func TestCMN(t *testing.T) {
+	// CMN r4, #0x30
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0xFFFFFFD0)
+	c.ram.WriteWord(0x4, 0xE3740030)
+	c.Step()
+	expectFlags(t, c, false, true, true, false)
+
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r4, 0x7FFFFFF0)
+	c.ram.WriteWord(0x4, 0xE3740030)
+	c.Step()
+	expectFlags(t, c, true, false, false, true)
+
+	if a := Decode(c.cpu, 0, 0xE3740030).Disassemble(); a != "cmn r4, #48" {
+		t.Fatal("expected 'cmn r4, #48', got", a)
+	}
+}
+
+func TestMLA(t *testing.T) {
+	// MLA r2, r3, r4, r5
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r3, 0x30) // Rm
+	c.registers.WriteWord(r4, 0x10) // Rs
+	c.registers.WriteWord(r5, 0x7)  // Rn
+	c.ram.WriteWord(0x4, 0xE0225493)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0x307 {
+		t.Fatalf("expected 0x307, got %#x", word)
+	}
+
+	// MULS r2, r3, r4 sets N and Z
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r3, 0xFFFFFFFF)
+	c.registers.WriteWord(r4, 0x2)
+	c.ram.WriteWord(0x4, 0xE0120493)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r2); word != 0xFFFFFFFE {
+		t.Fatalf("expected 0xFFFFFFFE, got %#x", word)
+	}
+	expectFlags(t, c, true, false, false, false)
+
+	if a := Decode(c.cpu, 0, 0xE0225493).Disassemble(); a != "mla r2, r3, r4, r5" {
+		t.Fatal("expected 'mla r2, r3, r4, r5', got", a)
+	}
+	if a := Decode(c.cpu, 0, 0xE0120493).Disassemble(); a != "muls r2, r3, r4" {
+		t.Fatal("expected 'muls r2, r3, r4', got", a)
+	}
+}
+
+func TestMULL(t *testing.T) {
+	// UMULL r1, r2, r3, r4
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r3, 0xFFFFFFFF)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE0821493)
+	c.Step()
+	lo, _ := c.registers.ReadWord(r1)
+	hi, _ := c.registers.ReadWord(r2)
+	if hi != 0xF || lo != 0xFFFFFFF0 {
+		t.Fatalf("expected 0xF:0xFFFFFFF0, got %#x:%#x", hi, lo)
+	}
+
+	// SMULL r1, r2, r3, r4
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r3, 0xFFFFFFFF) // -1
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE0C21493)
+	c.Step()
+	lo, _ = c.registers.ReadWord(r1)
+	hi, _ = c.registers.ReadWord(r2)
+	if hi != 0xFFFFFFFF || lo != 0xFFFFFFF0 {
+		t.Fatalf("expected 0xFFFFFFFF:0xFFFFFFF0, got %#x:%#x", hi, lo)
+	}
+
+	// UMLAL r1, r2, r3, r4
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 0x10)
+	c.registers.WriteWord(r2, 0x1)
+	c.registers.WriteWord(r3, 0xFFFFFFFF)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE0A21493)
+	c.Step()
+	lo, _ = c.registers.ReadWord(r1)
+	hi, _ = c.registers.ReadWord(r2)
+	if hi != 0x11 || lo != 0x0 {
+		t.Fatalf("expected 0x11:0x0, got %#x:%#x", hi, lo)
+	}
+
+	// SMLALS r1, r2, r3, r4 (-16 + 16 = 0)
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 0x10)
+	c.registers.WriteWord(r2, 0x0)
+	c.registers.WriteWord(r3, 0xFFFFFFFF)
+	c.registers.WriteWord(r4, 0x10)
+	c.ram.WriteWord(0x4, 0xE0F21493)
+	c.Step()
+	lo, _ = c.registers.ReadWord(r1)
+	hi, _ = c.registers.ReadWord(r2)
+	if hi != 0x0 || lo != 0x0 {
+		t.Fatalf("expected 0x0:0x0, got %#x:%#x", hi, lo)
+	}
+	expectFlags(t, c, false, true, false, false)
+
+	if a := Decode(c.cpu, 0, 0xE0821493).Disassemble(); a != "umull r1, r2, r3, r4" {
+		t.Fatal("expected 'umull r1, r2, r3, r4', got", a)
+	}
+	if a := Decode(c.cpu, 0, 0xE0F21493).Disassemble(); a != "smlals r1, r2, r3, r4" {
+		t.Fatal("expected 'smlals r1, r2, r3, r4', got", a)
+	}
+}
+
+func TestLDRH(t *testing.T) {
+	// LDRH r1, [r2, #4]
+	c := NewComputer(64, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r2, 0x20)
+	c.ram.WriteWord(0x24, 0xABCD8765)
+	c.ram.WriteWord(0x4, 0xE1D210B4)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0x8765 {
+		t.Fatalf("expected 0x8765, got %#x", word)
+	}
+	if word, _ := c.registers.ReadWord(r2); word != 0x20 {
+		t.Fatalf("expected r2 to be unchanged, got %#x", word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE1D210B4).Disassemble(); a != "ldrh r1, [r2, #4]" {
+		t.Fatal("expected 'ldrh r1, [r2, #4]', got", a)
+	}
+}
+
+func TestSTRH(t *testing.T) {
+	// STRH r1, [r2, #-4]!
+	c := NewComputer(64, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 0x12345678)
+	c.registers.WriteWord(r2, 0x24)
+	c.ram.WriteWord(0x20, 0xFFFFFFFF)
+	c.ram.WriteWord(0x4, 0xE16210B4)
+	c.Step()
+	if word, _ := c.ram.ReadWord(0x20); word != 0xFFFF5678 {
+		t.Fatalf("expected 0xFFFF5678, got %#x", word)
+	}
+	if word, _ := c.registers.ReadWord(r2); word != 0x20 {
+		t.Fatalf("expected writeback of 0x20, got %#x", word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE16210B4).Disassemble(); a != "strh r1, [r2, #-4]!" {
+		t.Fatal("expected 'strh r1, [r2, #-4]!', got", a)
+	}
+}
+
+func TestLDRSB(t *testing.T) {
+	// LDRSB r1, [r2], r3
+	c := NewComputer(64, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r2, 0x21)
+	c.registers.WriteWord(r3, 0x8)
+	c.ram.WriteWord(0x20, 0x0000F000)
+	c.ram.WriteWord(0x4, 0xE09210D3)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0xFFFFFFF0 {
+		t.Fatalf("expected 0xFFFFFFF0, got %#x", word)
+	}
+	if word, _ := c.registers.ReadWord(r2); word != 0x29 {
+		t.Fatalf("expected post-index writeback of 0x29, got %#x", word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE09210D3).Disassemble(); a != "ldrsb r1, [r2], r3" {
+		t.Fatal("expected 'ldrsb r1, [r2], r3', got", a)
+	}
+}
+
+func TestLDRSH(t *testing.T) {
+	// LDRSH r1, [r2, #0x12]
+	c := NewComputer(64, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r2, 0x10)
+	c.ram.WriteWord(0x20, 0x80010000)
+	c.ram.WriteWord(0x4, 0xE1D211F2)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0xFFFF8001 {
+		t.Fatalf("expected 0xFFFF8001, got %#x", word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE1D211F2).Disassemble(); a != "ldrsh r1, [r2, #18]" {
+		t.Fatal("expected 'ldrsh r1, [r2, #18]', got", a)
+	}
+}
+
+func TestMRS(t *testing.T) {
+	// MRS r0, cpsr
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(CPSR, 0x80000000|System)
+	c.ram.WriteWord(0x4, 0xE10F0000)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r0); word != 0x80000000|System {
+		t.Fatalf("expected %#x, got %#x", 0x80000000|System, word)
+	}
+
+	// MRS r1, spsr (banked for IRQ mode)
+	c.Reset()
+	c.registers.WriteWord(CPSR, IRQ)
+	c.registers.WriteWord(SPSR_irq, 0x40000000|User)
+	c.registers.WriteWord(PC, 0x4)
+	c.ram.WriteWord(0x4, 0xE14F1000)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0x40000000|User {
+		t.Fatalf("expected %#x, got %#x", 0x40000000|User, word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE14F1000).Disassemble(); a != "mrs r1, spsr" {
+		t.Fatal("expected 'mrs r1, spsr', got", a)
+	}
+}
+
+func TestMSR(t *testing.T) {
+	// MSR cpsr_c, r2 (switch to IRQ mode with interrupts disabled)
+	c := NewComputer(32, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(CPSR, 0x20000000|System)
+	c.registers.WriteWord(r2, 0xF0000000|0xC0|IRQ)
+	c.ram.WriteWord(0x4, 0xE121F002)
+	c.Step()
+	if word, _ := c.registers.ReadWord(CPSR); word != 0x20000000|0xC0|IRQ {
+		t.Fatalf("expected only the control field to change, got %#x", word)
+	}
+
+	// The stack pointer is now banked
+	c.registers.WriteWord(SP_irq, 0x7FF0)
+	if sp, _ := c.cpu.FetchRegister(SP); sp != 0x7FF0 {
+		t.Fatalf("expected banked IRQ stack pointer, got %#x", sp)
+	}
+
+	// MSR cpsr_fc, r2 in User mode only updates the flags
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(CPSR, User)
+	c.registers.WriteWord(r2, 0xF0000000|Supervisor)
+	c.ram.WriteWord(0x4, 0xE129F002)
+	c.Step()
+	if word, _ := c.registers.ReadWord(CPSR); word != 0xF0000000|User {
+		t.Fatalf("expected %#x, got %#x", 0xF0000000|User, word)
+	}
+
+	// MSR spsr_f, #0xf0000000
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(CPSR, Supervisor)
+	c.registers.WriteWord(SPSR_svc, User)
+	c.ram.WriteWord(0x4, 0xE368F20F)
+	c.Step()
+	if word, _ := c.registers.ReadWord(SPSR_svc); word != 0xF0000000|User {
+		t.Fatalf("expected %#x, got %#x", 0xF0000000|User, word)
+	}
+	if word, _ := c.registers.ReadWord(CPSR); word != Supervisor {
+		t.Fatalf("expected CPSR to be unchanged, got %#x", word)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE129F002).Disassemble(); a != "msr cpsr_fc, r2" {
+		t.Fatal("expected 'msr cpsr_fc, r2', got", a)
+	}
+	if a := Decode(c.cpu, 0, 0xE368F20F).Disassemble(); a != "msr spsr_f, #0xf0000000" {
+		t.Fatal("expected 'msr spsr_f, #0xf0000000', got", a)
+	}
+}
+
+func TestSWP(t *testing.T) {
+	// SWP r1, r3, [r2]
+	c := NewComputer(64, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r2, 0x20)
+	c.registers.WriteWord(r3, 0x1)
+	c.ram.WriteWord(0x20, 0xDEADBEEF)
+	c.ram.WriteWord(0x4, 0xE1021093)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0xDEADBEEF {
+		t.Fatalf("expected 0xDEADBEEF, got %#x", word)
+	}
+	if word, _ := c.ram.ReadWord(0x20); word != 0x1 {
+		t.Fatalf("expected 0x1 in memory, got %#x", word)
+	}
+
+	// SWPB r1, r1, [r2]
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 0x1234)
+	c.registers.WriteWord(r2, 0x21)
+	c.ram.WriteWord(0x20, 0xDEADBEEF)
+	c.ram.WriteWord(0x4, 0xE1421091)
+	c.Step()
+	if word, _ := c.registers.ReadWord(r1); word != 0xBE {
+		t.Fatalf("expected 0xBE, got %#x", word)
+	}
+	if word, _ := c.ram.ReadWord(0x20); word != 0xDEAD34EF {
+		t.Fatalf("expected 0xDEAD34EF in memory, got %#x", word)
+	}
+
+	// SWPB goes through the memory-mapped console
+	c.Reset()
+	c.registers.WriteWord(PC, 0x4)
+	c.registers.WriteWord(r1, 'A')
+	c.registers.WriteWord(r2, 0x100000)
+	c.ram.WriteWord(0x4, 0xE1421091)
+	c.Step()
+	if b := string(c.Console.Read()); b != "A" {
+		t.Fatalf("expected \"A\" on the console, got %q", b)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE1021093).Disassemble(); a != "swp r1, r3, [r2]" {
+		t.Fatal("expected 'swp r1, r3, [r2]', got", a)
+	}
+	if a := Decode(c.cpu, 0, 0xE1421091).Disassemble(); a != "swpb r1, r1, [r2]" {
+		t.Fatal("expected 'swpb r1, r1, [r2]', got", a)
+	}
+}
+
+func TestLDM(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.ram.WriteWord(0x200, 0x11)
+	c.ram.WriteWord(0x204, 0x22)
+
+	// Writeback goes to the base register, not SP
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(SP, 0x300)
+	c.registers.WriteWord(r0, 0x200)
+	c.ram.WriteWord(0x100, 0xE8B00006) // ldmia r0!, {r1, r2}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 0x208 {
+		t.Fatalf("expected r0 0x208, got %#x", r)
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 0x22 {
+		t.Fatalf("expected r2 0x22, got %#x", r)
+	}
+	if sp, _ := c.registers.ReadWord(SP); sp != 0x300 {
+		t.Fatalf("expected sp to be untouched, got %#x", sp)
+	}
+
+	// A loaded base takes precedence over writeback
+	c.registers.WriteWord(r0, 0x200)
+	c.ram.WriteWord(0x104, 0xE8B00003) // ldmia r0!, {r0, r1}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 0x11 {
+		t.Fatalf("expected r0 0x11, got %#x", r)
+	}
+
+	// Exception return restores the CPSR
+	c.Reset()
+	c.registers.WriteWord(CPSR, IRQ|1<<I)
+	c.registers.WriteWord(SPSR_irq, System|0x80000000)
+	c.registers.WriteWord(SP_irq, 0x200)
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x200, 0x11)
+	c.ram.WriteWord(0x204, 0x40)
+	c.ram.WriteWord(0x100, 0xE8FD8001) // ldmia sp!, {r0, pc}^
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x40 {
+		t.Fatalf("expected pc 0x40, got %#x", pc)
+	}
+	if cpsr, _ := c.registers.ReadWord(CPSR); cpsr != System|0x80000000 {
+		t.Fatalf("expected CPSR %#x, got %#x", System|0x80000000, cpsr)
+	}
+	if sp, _ := c.registers.ReadWord(SP_irq); sp != 0x208 {
+		t.Fatalf("expected sp_irq 0x208, got %#x", sp)
+	}
+
+	// User bank transfer
+	c.Reset()
+	c.registers.WriteWord(CPSR, IRQ)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x200)
+	c.ram.WriteWord(0x200, 0x11)
+	c.ram.WriteWord(0x100, 0xE8D02000) // ldmia r0, {r13}^
+	c.Step()
+	if sp, _ := c.registers.ReadWord(SP); sp != 0x11 {
+		t.Fatalf("expected user sp 0x11, got %#x", sp)
+	}
+	if sp, _ := c.registers.ReadWord(SP_irq); sp != 0x7FF0 {
+		t.Fatalf("expected sp_irq to be untouched, got %#x", sp)
+	}
+
+	if a := Decode(c.cpu, 0, 0xE8FD8001).Disassemble(); a != "ldmia r13!, {r0 r15}^" {
+		t.Fatal("expected ldmia r13!, {r0 r15}^, got", a)
+	}
+}
+
+func TestSTM(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x200)
+	c.registers.WriteWord(r1, 0x11)
+	c.ram.WriteWord(0x100, 0xE9208002) // stmdb r0!, {r1, pc}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 0x1F8 {
+		t.Fatalf("expected r0 0x1f8, got %#x", r)
+	}
+	if word, _ := c.ram.ReadWord(0x1F8); word != 0x11 {
+		t.Fatalf("expected 0x11, got %#x", word)
+	}
+	if word, _ := c.ram.ReadWord(0x1FC); word != 0x108 {
+		t.Fatalf("expected stored pc 0x108, got %#x", word)
+	}
+
+	// User bank transfer
+	c.Reset()
+	c.registers.WriteWord(CPSR, IRQ)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 0x200)
+	c.registers.WriteWord(r14, 0x55)
+	c.registers.WriteWord(r14_irq, 0x66)
+	c.ram.WriteWord(0x100, 0xE8C06000) // stmia r0, {r13, r14}^
+	c.Step()
+	if word, _ := c.ram.ReadWord(0x200); word != 0x7000 {
+		t.Fatalf("expected user sp 0x7000, got %#x", word)
+	}
+	if word, _ := c.ram.ReadWord(0x204); word != 0x55 {
+		t.Fatalf("expected user lr 0x55, got %#x", word)
+	}
+}
+
 func TestSWI(t *testing.T) {
 	c := NewComputer(32, nil)
 	c.registers.WriteWord(PC, 0x4)
@@ -282,6 +1087,203 @@ func TestSWI(t *testing.T) {
 	}
 }
 
+func TestUndefined(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(CPSR, System|0x20000000)
+	c.registers.WriteWord(LR, 0x55)
+	c.ram.WriteWord(0x100, 0xE7F000F0) // Permanently undefined
+	if !c.Step() {
+		t.Fatal("expected execution to continue at the undefined vector")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+	if mode := c.Status().Mode; mode != "Undefined" {
+		t.Fatal("expected Undefined mode, got", mode)
+	}
+	if lr, _ := c.registers.ReadWord(r14_und); lr != 0x104 {
+		t.Fatalf("expected r14_und 0x104, got %#x", lr)
+	}
+	if spsr, _ := c.registers.ReadWord(SPSR_und); spsr != System|0x20000000 {
+		t.Fatalf("expected SPSR_und %#x, got %#x", System|0x20000000, spsr)
+	}
+	if lr, _ := c.registers.ReadWord(LR); lr != 0x55 {
+		t.Fatalf("expected user lr to be untouched, got %#x", lr)
+	}
+	if i, _ := c.registers.TestFlag(CPSR, I); !i {
+		t.Fatal("expected I bit to be set")
+	}
+
+	// Return with movs pc, lr
+	c.ram.WriteWord(0x4, 0xE1B0F00E)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x104 {
+		t.Fatalf("expected pc 0x104, got %#x", pc)
+	}
+	if cpsr, _ := c.registers.ReadWord(CPSR); cpsr != System|0x20000000 {
+		t.Fatalf("expected CPSR to be restored, got %#x", cpsr)
+	}
+
+	// Halt with an error instead
+	c.Reset()
+	c.EnableHaltOnUndefined()
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE7F000F0)
+	if c.Step() {
+		t.Fatal("expected execution to halt")
+	}
+	expected := "Undefined instruction 0xE7F000F0 at address 0x00000100."
+	if err := c.Err(); err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+	c.Reset()
+	if c.Err() != nil {
+		t.Fatal("expected Reset to clear the error")
+	}
+}
+
+func TestDataAbort(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(CPSR, System|0x80000000)
+	c.registers.WriteWord(r2, 0x10000)
+	c.registers.WriteWord(r1, 0x55)
+	c.ram.WriteWord(0x100, 0xE5B21004) // ldr r1, [r2, #4]!
+	if !c.Step() {
+		t.Fatal("expected execution to continue at the data abort vector")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected pc 0x10, got %#x", pc)
+	}
+	if mode := c.Status().Mode; mode != "Abort" {
+		t.Fatal("expected Abort mode, got", mode)
+	}
+	if lr, _ := c.registers.ReadWord(r14_abt); lr != 0x108 {
+		t.Fatalf("expected r14_abt 0x108, got %#x", lr)
+	}
+	if spsr, _ := c.registers.ReadWord(SPSR_abt); spsr != System|0x80000000 {
+		t.Fatalf("expected SPSR_abt %#x, got %#x", System|0x80000000, spsr)
+	}
+	if r, _ := c.registers.ReadWord(r1); r != 0x55 {
+		t.Fatalf("expected r1 to be untouched, got %#x", r)
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 0x10000 {
+		t.Fatalf("expected r2 to be untouched, got %#x", r)
+	}
+
+	// Misaligned halfword store with alignment checking
+	c.Reset()
+	c.EnableAlignmentChecking()
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r2, 0x201)
+	c.ram.WriteWord(0x100, 0xE1C210B0) // strh r1, [r2]
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected pc 0x10, got %#x", pc)
+	}
+
+	// Store multiple running off the end of memory
+	c.Reset()
+	c.DisableAlignmentChecking()
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r2, 0x3FC)
+	c.ram.WriteWord(0x100, 0xE8820003) // stm r2, {r0, r1}
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected pc 0x10, got %#x", pc)
+	}
+}
+
+func TestPrefetchAbort(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE3A0FA01) // mov pc, #0x1000
+	c.Step()
+	if !c.Step() {
+		t.Fatal("expected execution to continue at the prefetch abort vector")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0xC {
+		t.Fatalf("expected pc 0xc, got %#x", pc)
+	}
+	if mode := c.Status().Mode; mode != "Abort" {
+		t.Fatal("expected Abort mode, got", mode)
+	}
+	if lr, _ := c.registers.ReadWord(r14_abt); lr != 0x1004 {
+		t.Fatalf("expected r14_abt 0x1004, got %#x", lr)
+	}
+}
+
 func TestDisassemble(t *testing.T) {
 
 }
+
+func TestUnaligned(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r2, 0x201)
+	c.registers.WriteWord(r3, 0xCAFEBABE)
+	c.ram.WriteWord(0x200, 0x11223344)
+	c.ram.WriteWord(0x100, 0xE5921000) // ldr r1, [r2]
+	c.ram.WriteWord(0x104, 0xE5823004) // str r3, [r2, #4]
+	c.ram.WriteWord(0x108, 0xE1D240B0) // ldrh r4, [r2]
+	c.ram.WriteWord(0x10C, 0xE8920060) // ldm r2, {r5, r6}
+	c.ram.WriteWord(0x110, 0xE1027093) // swp r7, r3, [r2]
+
+	// LDR rotates the aligned word
+	c.Step()
+	if r, _ := c.registers.ReadWord(r1); r != 0x44112233 {
+		t.Fatalf("expected 0x44112233, got %#x", r)
+	}
+
+	// STR, LDRH and LDM ignore the low address bits
+	c.Step()
+	if word, _ := c.ram.ReadWord(0x204); word != 0xCAFEBABE {
+		t.Fatalf("expected 0xcafebabe at 0x204, got %#x", word)
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r4); r != 0x3344 {
+		t.Fatalf("expected 0x3344, got %#x", r)
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r6); r != 0xCAFEBABE {
+		t.Fatalf("expected 0xcafebabe, got %#x", r)
+	}
+
+	// SWP rotates the loaded word and stores aligned
+	c.Step()
+	if r, _ := c.registers.ReadWord(r7); r != 0x44112233 {
+		t.Fatalf("expected 0x44112233, got %#x", r)
+	}
+	if word, _ := c.ram.ReadWord(0x200); word != 0xCAFEBABE {
+		t.Fatalf("expected 0xcafebabe at 0x200, got %#x", word)
+	}
+
+	// Alignment checking raises a Data Abort
+	c.Reset()
+	c.EnableAlignmentChecking()
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r2, 0x202)
+	c.ram.WriteWord(0x100, 0xE5921000) // ldr r1, [r2]
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10 {
+		t.Fatalf("expected pc 0x10, got %#x", pc)
+	}
+
+	// LDR pc ignores bit 1 of an ARM address (no Prefetch Abort)
+	c.Reset()
+	c.EnableAlignmentChecking()
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r2, 0x200)
+	c.ram.WriteWord(0x200, 0x302)
+	c.ram.WriteWord(0x100, 0xE592F000) // ldr pc, [r2]
+	c.ram.WriteWord(0x300, 0xE3A01005) // mov r1, #5
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x300 {
+		t.Fatalf("expected pc 0x300, got %#x", pc)
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r1); r != 5 {
+		t.Fatal("expected 5, got", r)
+	}
+}
@@ -8,16 +8,29 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 )
 
+// The size of the pages of a sparse Memory
+const PageSize = 0x1000
+
 // A Memory holds a memory slice (a variable-length slice of bytes) used to
-// implement RAM or other like memory structures.
+// implement RAM or other like memory structures. A sparse Memory instead
+// covers the whole 32-bit address space with pages allocated on first write.
 type Memory struct {
-	memory []byte
-	Memory *[]byte
-	log    *log.Logger
+	memory    []byte
+	Memory    *[]byte
+	pages     map[uint32][]byte // Pages by base address (sparse only)
+	bigEndian bool              // Byte order of halfwords and words
+	watcher   MemoryWatcher     // Called after each access (see Watch)
+	log       *log.Logger
 }
 
+// A MemoryWatcher is called after each successful read or write of a watched
+// Memory with the address and size (in bytes) of the access, whether it was a
+// write, the previous value (writes only) and the value read or written.
+type MemoryWatcher func(address, size uint32, write bool, old, data uint32)
+
 // Initializes a Memory
 //
 // Parameters:
@@ -42,6 +55,79 @@ func NewMemory(nBytes uint32, logOut io.Writer) (m *Memory) {
 	return
 }
 
+// Initializes a sparse Memory covering the whole 32-bit address space. Pages
+// of PageSize bytes are allocated when they are first written, and untouched
+// addresses read as 0.
+//
+// Parameters:
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns: A pointer to the newly created Memory
+func NewSparseMemory(logOut io.Writer) (m *Memory) {
+	m = NewMemory(0, logOut)
+	m.log.Println("Using sparse memory...")
+	m.pages = make(map[uint32][]byte)
+
+	return
+}
+
+// Returns true if the memory is sparse (see NewSparseMemory).
+func (m *Memory) Sparse() (sparse bool) {
+	return m.pages != nil
+}
+
+// Returns the size of the memory in bytes (1 << 32 for a sparse memory).
+func (m *Memory) Size() (size uint64) {
+	if m.Sparse() {
+		return 1 << 32
+	}
+	return uint64(len(m.memory))
+}
+
+// Returns the base addresses of the allocated pages of a sparse memory in
+// order, or nil if the memory isn't sparse.
+func (m *Memory) Pages() (pages []uint32) {
+	if !m.Sparse() {
+		return
+	}
+
+	pages = make([]uint32, 0, len(m.pages))
+	for base := range m.pages {
+		pages = append(pages, base)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+
+	return
+}
+
+// Zeroes the memory (freeing the pages of a sparse memory).
+func (m *Memory) Clear() {
+	if m.Sparse() {
+		m.pages = make(map[uint32][]byte)
+		return
+	}
+
+	for i := range m.memory {
+		m.memory[i] = 0
+	}
+}
+
+// Sets the byte order of halfword and word accesses. Memory is little-endian
+// by default.
+//
+// Parameters:
+//  bigEndian - true for big-endian, false for little-endian
+//
+// Returns: None
+func (m *Memory) SetBigEndian(bigEndian bool) {
+	m.bigEndian = bigEndian
+}
+
+// Returns true if halfword and word accesses are big-endian.
+func (m *Memory) BigEndian() (bigEndian bool) {
+	return m.bigEndian
+}
+
 // Writes a byte of data to memory at a specified address.
 //
 // Parameters:
@@ -56,7 +142,14 @@ func (m *Memory) WriteByte(address uint32, data byte) (err error) {
 		return
 	}
 
-	m.memory[address] = data
+	var old byte
+	if m.watcher != nil {
+		old = m.byteAt(address)
+	}
+	m.setByte(address, data)
+	if m.watcher != nil {
+		m.watcher(address, 1, true, uint32(old), uint32(data))
+	}
 	return
 }
 
@@ -74,10 +167,23 @@ func (m *Memory) ReadByte(address uint32) (data byte, err error) {
 		return
 	}
 
-	data = m.memory[address]
+	data = m.byteAt(address)
+	if m.watcher != nil {
+		m.watcher(address, 1, false, 0, uint32(data))
+	}
 	return
 }
 
+// Reads a byte for the Device interface (see ReadByte).
+func (m *Memory) LoadByte(address uint32) (data byte, err error) {
+	return m.ReadByte(address)
+}
+
+// Writes a byte for the Device interface (see WriteByte).
+func (m *Memory) StoreByte(address uint32, data byte) (err error) {
+	return m.WriteByte(address, data)
+}
+
 // Writes a halfword (16 bits) of data to memory at a specified address.
 //
 // Parameters:
@@ -99,12 +205,18 @@ func (m *Memory) WriteHalfWord(address uint32, data uint16) (err error) {
 // Reads a halfword (16 bits) of data from memory at a specified address.
 //
 // Parameters:
-//  address - 32-bit address of read location in memory
+//  address - 32-bit address of read location in memory, must be divisible by 2
 //
 // Returns:
 //  data - halfword of data at address
 //  err - any error that may have occurred
 func (m *Memory) ReadHalfWord(address uint32) (data uint16, err error) {
+	if address&1 == 1 {
+		m.log.Println("ERROR: Attempted to read halfword from an odd address.")
+		err = errors.New("ERROR: Attempted to read halfword from an odd address.")
+		return
+	}
+
 	var data32 uint32
 	data32, err = m.readMultiByte(address, 2)
 	data = uint16(data32)
@@ -133,21 +245,42 @@ func (m *Memory) WriteWord(address uint32, data uint32) (err error) {
 // Reads a word (32 bits) of data from memory at a specified address.
 //
 // Parameters:
-//  address - 32-bit address of read location in memory
+//  address - 32-bit address of read location in memory, must be divisible by 4
 //
 // Returns:
 //  data - word of data at address
 //  err - any error that may have occurred
 func (m *Memory) ReadWord(address uint32) (data uint32, err error) {
+	if address%4 != 0 {
+		m.log.Println("ERROR: Attempted to read word from an address indivisible by 4.")
+		err = errors.New("ERROR: Attempted to read word from an address indivisible by 4.")
+		return
+	}
+
 	data, err = m.readMultiByte(address, 4)
 	return
 }
 
+// Sets a function to be called after every read and write (or nil to stop
+// watching). Checksum and Clear aren't watched.
+//
+// Parameters:
+//  watcher - the function to call
+//
+// Returns: None
+func (m *Memory) Watch(watcher MemoryWatcher) {
+	m.watcher = watcher
+}
+
 // Calculates a simple checksum based on the whole memory.
 //
 // Returns:
 //  checksum - 32-bit integer
 func (m *Memory) Checksum() (checksum int32) {
+	if m.Sparse() {
+		return m.sparseChecksum()
+	}
+
 	for i := 0; i < len(m.memory); i++ {
 		var block byte
 		if 0x7ff0 >= i && i >= 0x7000 {
@@ -254,8 +387,60 @@ func ExtractShiftBits(word, startBit, endBit uint32) uint32 {
 
 // Helpers
 
+// Calculates the same checksum as Checksum for a sparse memory without
+// visiting untouched pages: each of their bytes (0) adds its address, so the
+// sum of all addresses is corrected for the bytes of the allocated pages.
+func (m *Memory) sparseChecksum() (checksum int32) {
+	// The sum of 0 to 2^32 - 1 (truncated to 32 bits)
+	n := m.Size()
+	checksum = int32(uint32(n / 2 * (n - 1)))
+
+	for base, page := range m.pages {
+		for j, block := range page {
+			i := base + uint32(j)
+			if 0x7ff0 >= i && i >= 0x7000 {
+				continue
+			}
+			checksum += int32(uint32(block)^i) - int32(i)
+		}
+	}
+
+	return
+}
+
+// Returns the byte at an address (0 if its page of a sparse memory hasn't
+// been allocated).
+func (m *Memory) byteAt(address uint32) (data byte) {
+	if m.Sparse() {
+		if page, ok := m.pages[address&^(PageSize-1)]; ok {
+			data = page[address&(PageSize-1)]
+		}
+		return
+	}
+	return m.memory[address]
+}
+
+// Sets the byte at an address, allocating its page of a sparse memory.
+func (m *Memory) setByte(address uint32, data byte) {
+	if m.Sparse() {
+		base := address &^ (PageSize - 1)
+		page, ok := m.pages[base]
+		if !ok {
+			m.log.Printf("Allocating page %#08x...", base)
+			page = make([]byte, PageSize)
+			m.pages[base] = page
+		}
+		page[address&(PageSize-1)] = data
+		return
+	}
+	m.memory[address] = data
+}
+
 // Checks if an address is in the range of the memory. Returns nil or an error.
 func (m *Memory) catchAddressOutOfBounds(address uint32) (err error) {
+	if m.Sparse() {
+		return
+	}
 	if address >= uint32(len(m.memory)) {
 		m.log.Printf("ERROR: Could not read or write memory address %d. Address is out of range.", address)
 		err = errors.New("ERROR: Could not read or write memory address. Address out of range.")
@@ -266,13 +451,20 @@ func (m *Memory) catchAddressOutOfBounds(address uint32) (err error) {
 
 // Writes multiple bytes at a time in correct endianness
 func (m *Memory) writeMultiByte(address uint32, nBytes int, data uint32) (err error) {
-	err = m.catchAddressOutOfBounds(address)
+	err = m.catchAddressOutOfBounds(address + uint32(nBytes-1))
 	if err != nil {
 		return
 	}
 
+	var old uint32
+	if m.watcher != nil {
+		old = m.peek(address, nBytes)
+	}
 	for i := 0; i < nBytes; i++ {
-		m.memory[address+uint32(i)] = byte(data >> uint(8*i))
+		m.setByte(address+uint32(i), byte(data>>m.byteShift(i, nBytes)))
+	}
+	if m.watcher != nil {
+		m.watcher(address, uint32(nBytes), true, old, data)
 	}
 
 	return
@@ -280,15 +472,32 @@ func (m *Memory) writeMultiByte(address uint32, nBytes int, data uint32) (err er
 
 // Reads multiple bytes at a time in correct endianness
 func (m *Memory) readMultiByte(address uint32, nBytes int) (data uint32, err error) {
-	err = m.catchAddressOutOfBounds(address)
+	err = m.catchAddressOutOfBounds(address + uint32(nBytes-1))
 	if err != nil {
 		return
 	}
 
-	for i := nBytes - 1; i >= 0; i-- {
-		data <<= 8
-		data |= uint32(m.memory[address+uint32(i)])
+	data = m.peek(address, nBytes)
+	if m.watcher != nil {
+		m.watcher(address, uint32(nBytes), false, 0, data)
 	}
 
 	return
 }
+
+// Reads multiple bytes without bounds checks or watching
+func (m *Memory) peek(address uint32, nBytes int) (data uint32) {
+	for i := 0; i < nBytes; i++ {
+		data |= uint32(m.byteAt(address+uint32(i))) << m.byteShift(i, nBytes)
+	}
+	return
+}
+
+// Returns the shift of the i-th byte (by address) of an nBytes value. In
+// big-endian order the most significant byte is at the lowest address.
+func (m *Memory) byteShift(i, nBytes int) (shift uint) {
+	if m.bigEndian {
+		return uint(8 * (nBytes - 1 - i))
+	}
+	return uint(8 * i)
+}
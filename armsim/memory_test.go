@@ -3,7 +3,10 @@
 
 package armsim
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestNewMemory(t *testing.T) {
 	// Test the memory initializer at 32k
@@ -237,6 +240,44 @@ func TestReadWord(t *testing.T) {
 	if err == nil {
 		t.Fatal("Attempted to read out of range address.")
 	}
+
+	// Test misaligned address
+	_, err = memory.ReadWord(2)
+	if err == nil {
+		t.Fatal("Attempted to read misaligned address.")
+	}
+
+	// Test word extending past the end of memory
+	memory = NewMemory(30, nil)
+	_, err = memory.ReadWord(28)
+	if err == nil {
+		t.Fatal("Attempted to read past the end of memory.")
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	memory := NewMemory(32, nil)
+	memory.SetBigEndian(true)
+
+	// The most significant byte is at the lowest address
+	memory.WriteWord(0, 0x11223344)
+	if b, _ := memory.ReadByte(0); b != 0x11 {
+		t.Fatalf("expected 0x11 got %#x", b)
+	}
+	if h, _ := memory.ReadHalfWord(2); h != 0x3344 {
+		t.Fatalf("expected 0x3344 got %#x", h)
+	}
+
+	memory.WriteHalfWord(4, 0xAABB)
+	if b, _ := memory.ReadByte(5); b != 0xBB {
+		t.Fatalf("expected 0xbb got %#x", b)
+	}
+
+	// The same bytes read little-endian
+	memory.SetBigEndian(false)
+	if w, _ := memory.ReadWord(0); w != 0x44332211 {
+		t.Fatalf("expected 0x44332211 got %#x", w)
+	}
 }
 
 func TestChecksum(t *testing.T) {
@@ -398,3 +439,52 @@ func TestExtractShiftBits(t *testing.T) {
 	// Explicitly fails due to typing
 	// ExtractBits(0xb5, -1, 33)
 }
+
+func TestSparseMemory(t *testing.T) {
+	m := NewSparseMemory(nil)
+	if !m.Sparse() || m.Size() != 1<<32 {
+		t.Fatalf("expected a sparse memory of 4GB, got %d bytes", m.Size())
+	}
+
+	// Every byte is 0, so the checksum is the sum of all addresses
+	empty := m.Checksum()
+	if empty != math.MinInt32 {
+		t.Fatalf("expected checksum %d, got %d", math.MinInt32, empty)
+	}
+
+	// Reads don't allocate pages
+	if data, err := m.ReadWord(0x80000000); err != nil || data != 0 {
+		t.Fatalf("expected 0, got %#x (%v)", data, err)
+	}
+	if pages := m.Pages(); len(pages) != 0 {
+		t.Fatal("expected no pages, got", pages)
+	}
+
+	m.WriteWord(0xFFFFFFFC, 0x11223344)
+	m.WriteByte(0x80000001, 0xAB)
+	m.WriteByte(0x7004, 0xCD) // excluded from the checksum
+	if pages := m.Pages(); len(pages) != 3 || pages[0] != 0x7000 || pages[1] != 0x80000000 || pages[2] != 0xFFFFF000 {
+		t.Fatalf("expected pages 0x7000, 0x80000000 and 0xfffff000, got %#x", pages)
+	}
+	if data, _ := m.ReadWord(0xFFFFFFFC); data != 0x11223344 {
+		t.Fatalf("expected 0x11223344, got %#x", data)
+	}
+	if data, _ := m.ReadHalfWord(0x80000000); data != 0xAB00 {
+		t.Fatalf("expected 0xab00, got %#x", data)
+	}
+
+	var expected int32 = empty
+	for address, data := range map[uint32]byte{
+		0xFFFFFFFC: 0x44, 0xFFFFFFFD: 0x33, 0xFFFFFFFE: 0x22, 0xFFFFFFFF: 0x11, 0x80000001: 0xAB,
+	} {
+		expected += int32(uint32(data)^address) - int32(address)
+	}
+	if checksum := m.Checksum(); checksum != expected {
+		t.Fatalf("expected checksum %d, got %d", expected, checksum)
+	}
+
+	m.Clear()
+	if pages := m.Pages(); len(pages) != 0 || m.Checksum() != empty {
+		t.Fatal("expected an empty memory after clearing, got pages", pages)
+	}
+}
@@ -0,0 +1,274 @@
+// Filename: mmu.go
+// Contents: The ARMv5 MMU of CP15: first- and second-level page table walks
+//	(sections, and large and small pages in coarse tables), domain and access
+//	permission checks, and the TLB. While the MMU is disabled (the reset
+//	state), virtual addresses are physical addresses.
+
+package armsim
+
+import (
+	"fmt"
+)
+
+// Fault status codes (FSR bits 3-0)
+const (
+	faultTranslationSection uint32 = 0x5
+	faultTranslationPage    uint32 = 0x7
+	faultDomainSection      uint32 = 0x9
+	faultDomainPage         uint32 = 0xB
+	faultExternalLevel1     uint32 = 0xC
+	faultExternalLevel2     uint32 = 0xE
+	faultPermissionSection  uint32 = 0xD
+	faultPermissionPage     uint32 = 0xF
+)
+
+// Domain access control values (two DACR bits per domain)
+const (
+	domainClient  uint32 = 1 // Accesses are checked against the AP bits
+	domainManager uint32 = 3 // Accesses are never checked
+)
+
+// Number of TLB entries
+const tlbSize = 64
+
+// An MMUFault is an access the MMU aborted. Status and Domain are the values
+// written to the fault status register.
+type MMUFault struct {
+	Address uint32 // The virtual address accessed
+	Status  uint32 // The fault status code (e.g., 0x5 for a section translation fault)
+	Domain  uint32 // The domain of the section or page (0 for a section translation fault)
+}
+
+// Builds the fault report.
+func (f *MMUFault) Error() string {
+	kind := map[uint32]string{
+		faultTranslationSection: "Section translation",
+		faultTranslationPage:    "Page translation",
+		faultDomainSection:      "Section domain",
+		faultDomainPage:         "Page domain",
+		faultExternalLevel1:     "First-level external",
+		faultExternalLevel2:     "Second-level external",
+		faultPermissionSection:  "Section permission",
+		faultPermissionPage:     "Page permission",
+	}[f.Status]
+	return fmt.Sprintf("%s fault at address 0x%08X (domain %d).", kind, f.Address, f.Domain)
+}
+
+// Returns the value of the fault status register for the fault.
+func (f *MMUFault) fsr() uint32 {
+	return f.Domain<<4 | f.Status
+}
+
+// A TLB entry caches one section or page translation.
+type tlbEntry struct {
+	valid    bool
+	virtual  uint32    // Virtual base address
+	mask     uint32    // Size - 1
+	physical uint32    // Physical base address
+	domain   uint32    // Domain (0-15)
+	ap       [4]uint32 // Access permissions of each quarter (all equal for a section)
+	apShift  uint32    // Shift selecting the quarter from the address
+	page     bool      // A page (rather than a section), for the fault status
+}
+
+// Returns true if the entry translates a virtual address.
+func (e *tlbEntry) contains(address uint32) bool {
+	return e.valid && address&^e.mask == e.virtual
+}
+
+// Translates a virtual address to a physical address, walking the page
+// tables on a TLB miss and checking the domain and access permissions.
+//
+// Parameters:
+//  address - the virtual address
+//  access - PermRead, PermWrite or PermExecute
+//  privileged - true if the access is made in a privileged mode
+//
+// Returns:
+//  physical - the physical address
+//  err - an *MMUFault if the access aborts
+func (cp *CP15) translate(address uint32, access Permissions, privileged bool) (physical uint32, err error) {
+	if !cp.MMUEnabled() {
+		return address, nil
+	}
+
+	var entry *tlbEntry
+	for i := range cp.tlb {
+		if cp.tlb[i].contains(address) {
+			entry = &cp.tlb[i]
+			cp.hits++
+			break
+		}
+	}
+	if entry == nil {
+		cp.misses++
+		var walked tlbEntry
+		if walked, err = cp.walk(address); err != nil {
+			return
+		}
+		entry = &cp.tlb[cp.tlbNext]
+		*entry = walked
+		cp.tlbNext = (cp.tlbNext + 1) % tlbSize
+	}
+
+	// Check the domain, then the access permissions for client domains
+	domainFault, permissionFault := faultDomainSection, faultPermissionSection
+	if entry.page {
+		domainFault, permissionFault = faultDomainPage, faultPermissionPage
+	}
+	switch (cp.dacr >> (entry.domain * 2)) & 3 {
+	case domainManager:
+	case domainClient:
+		ap := entry.ap[(address>>entry.apShift)&3]
+		if !cp.permitted(ap, access, privileged) {
+			return 0, &MMUFault{address, permissionFault, entry.domain}
+		}
+	default:
+		return 0, &MMUFault{address, domainFault, entry.domain}
+	}
+
+	return entry.physical | address&entry.mask, nil
+}
+
+// Walks the page tables for a virtual address. Descriptors are read from the
+// bus at their physical addresses, and aren't watched (see watch.go) as the
+// MMU reads them rather than the instruction.
+//
+// Parameters:
+//  address - the virtual address
+//
+// Returns:
+//  entry - the translation for the TLB
+//  err - an *MMUFault for a fault descriptor or an unreadable table
+func (cp *CP15) walk(address uint32) (entry tlbEntry, err error) {
+	executing := cp.cpu.executing
+	cp.cpu.executing = false
+	defer func() { cp.cpu.executing = executing }()
+
+	first, err := cp.cpu.bus.ReadWord(cp.ttbr | address>>20<<2)
+	if err != nil {
+		return entry, &MMUFault{address, faultExternalLevel1, 0}
+	}
+	entry.valid = true
+	entry.domain = ExtractShiftBits(first, 5, 9)
+
+	switch first & 3 {
+	case 2:
+		// Section (1MB)
+		entry.virtual, entry.mask = address&0xFFF00000, 0xFFFFF
+		entry.physical = first & 0xFFF00000
+		ap := ExtractShiftBits(first, 10, 12)
+		entry.ap = [4]uint32{ap, ap, ap, ap}
+		return
+	case 1:
+		// Coarse page table (256 entries), below
+	default:
+		// Fault descriptor (or a fine page table, which isn't supported)
+		return entry, &MMUFault{address, faultTranslationSection, 0}
+	}
+
+	second, err := cp.cpu.bus.ReadWord(first&0xFFFFFC00 | (address>>12)&0xFF<<2)
+	if err != nil {
+		return entry, &MMUFault{address, faultExternalLevel2, entry.domain}
+	}
+	entry.page = true
+
+	switch second & 3 {
+	case 1:
+		// Large page (64KB), with subpages selected by bits 15-14
+		entry.virtual, entry.mask = address&0xFFFF0000, 0xFFFF
+		entry.physical = second & 0xFFFF0000
+		entry.apShift = 14
+	case 2:
+		// Small page (4KB), with subpages selected by bits 11-10
+		entry.virtual, entry.mask = address&0xFFFFF000, 0xFFF
+		entry.physical = second & 0xFFFFF000
+		entry.apShift = 10
+	default:
+		return entry, &MMUFault{address, faultTranslationPage, entry.domain}
+	}
+	for i := range entry.ap {
+		entry.ap[i] = ExtractShiftBits(second, uint32(4+2*i), uint32(6+2*i))
+	}
+
+	return
+}
+
+// Checks an access against access permission (AP) bits. Instruction fetches
+// need read permission.
+//
+// Parameters:
+//  ap - the AP bits of the section or subpage
+//  access - PermRead, PermWrite or PermExecute
+//  privileged - true if the access is made in a privileged mode
+//
+// Returns:
+//  permitted - true if the access is permitted
+func (cp *CP15) permitted(ap uint32, access Permissions, privileged bool) (permitted bool) {
+	write := access == PermWrite
+
+	switch ap {
+	case 0:
+		// Read-only as selected by the S and R bits (both set is unpredictable)
+		system, rom := cp.control&controlS != 0, cp.control&controlR != 0
+		if write || system == rom {
+			return false
+		}
+		return rom || privileged
+	case 1:
+		return privileged
+	case 2:
+		return privileged || !write
+	default:
+		return true
+	}
+}
+
+// Invalidates every TLB entry.
+func (cp *CP15) flushTLB() {
+	cp.tlb = [tlbSize]tlbEntry{}
+	cp.tlbNext = 0
+}
+
+// Invalidates the TLB entry translating a virtual address (if any).
+func (cp *CP15) flushTLBEntry(address uint32) {
+	for i := range cp.tlb {
+		if cp.tlb[i].contains(address) {
+			cp.tlb[i].valid = false
+		}
+	}
+}
+
+// Translates the address of a load or store. An MMU fault records the fault
+// status and address in CP15 and raises a Data Abort, and the instruction
+// should stop executing.
+//
+// Parameters:
+//  address - the virtual address accessed
+//  access - PermRead or PermWrite
+//
+// Returns:
+//  physical - the physical address
+//  err - the fault, if any
+func (cpu *CPU) translateData(address uint32, access Permissions) (physical uint32, err error) {
+	if cpu.mmu == nil {
+		return address, nil
+	}
+
+	physical, err = cpu.mmu.translate(address, access, cpu.privileged())
+	if fault, ok := err.(*MMUFault); ok {
+		cpu.log.Println(err)
+		cpu.mmu.fsr, cpu.mmu.far = fault.fsr(), fault.Address
+		cpu.dataAbort(address)
+	}
+	return
+}
+
+// Translates the address of an instruction fetch. The caller raises the
+// Prefetch Abort for a fault (see fetchAbort).
+func (cpu *CPU) translateFetch(address uint32) (physical uint32, err error) {
+	if cpu.mmu == nil {
+		return address, nil
+	}
+	return cpu.mmu.translate(address, PermExecute, cpu.privileged())
+}
@@ -0,0 +1,123 @@
+// Filename: pipeline.go
+// Contents: The optional three-stage (fetch, decode, execute) pipeline. When
+//	it is enabled, each Step is one pipeline cycle: the fetched instruction
+//	moves to decode, the decoded instruction moves to execute and a new
+//	instruction is fetched. Branches and exceptions flush the pipeline, which
+//	takes two cycles to refill before the next instruction executes.
+
+package armsim
+
+// An instruction in one of the pipeline stages
+type PipelineStage struct {
+	Valid       bool   // False if the stage is empty (a bubble)
+	Address     uint32 // The address the instruction was fetched from
+	Bits        uint32 // The encoded instruction
+	Thumb       bool   // True if the instruction was fetched in Thumb state
+	Aborted     bool   // True if the fetch aborted (Prefetch Abort on execution)
+	Disassembly string // The decoded instruction (empty until it is decoded)
+
+	fault error // Why the fetch aborted
+}
+
+// The three in-flight instructions of the pipeline
+type Pipeline struct {
+	Fetch   PipelineStage
+	Decode  PipelineStage
+	Execute PipelineStage
+}
+
+// Enables the pipelined mode. The pipeline starts empty.
+func (c *Computer) EnablePipeline() {
+	c.pipelined = true
+	c.pipeline = Pipeline{}
+}
+
+// Disables the pipelined mode (the default), where each Step fetches, decodes
+// and executes a single instruction.
+func (c *Computer) DisablePipeline() {
+	c.pipelined = false
+	c.pipeline = Pipeline{}
+}
+
+// Returns whether the pipelined mode is enabled.
+//
+// Parameters: None
+//
+// Returns:
+//  pipelined - true if each Step is one pipeline cycle
+func (c *Computer) Pipelined() (pipelined bool) {
+	return c.pipelined
+}
+
+// Performs a single pipeline cycle. The instruction in the execute stage
+// (if any) is executed, traced and counted like a Step in the default mode.
+//
+// Returns:
+//  status - false if the CPU halted
+func (c *Computer) stepPipeline() (status bool) {
+	p := &c.pipeline
+
+	// Advance the stages
+	p.Execute, p.Decode, p.Fetch = p.Decode, p.Fetch, PipelineStage{}
+
+	// The PC holds the address of the next instruction to execute, so any
+	// stage that doesn't follow on from it was fetched before a branch, an
+	// exception or a change of state and is flushed
+	pc, _ := c.registers.ReadWord(PC)
+	thumb := c.cpu.Thumb()
+	address := pc
+	for _, stage := range []*PipelineStage{&p.Execute, &p.Decode} {
+		if stage.Valid && (stage.Address != address || stage.Thumb != thumb) {
+			c.log.Printf("Flushing pipeline at %#08x...", stage.Address)
+			p.Execute, p.Decode = PipelineStage{}, PipelineStage{}
+			address = pc
+			break
+		}
+		if stage.Valid {
+			address += c.cpu.instructionSize()
+		}
+	}
+
+	// Fetch
+	p.Fetch = PipelineStage{Valid: true, Address: address, Thumb: thumb}
+	var err error
+	p.Fetch.Bits, err = c.cpu.readInstruction(address)
+	p.Fetch.Aborted, p.Fetch.fault = err != nil, err
+
+	// Decode
+	if p.Decode.Valid && !p.Decode.Aborted {
+		p.Decode.Disassembly = c.decodeStage(p.Decode).Disassemble()
+	}
+
+	// Execute
+	if !p.Execute.Valid {
+		return true
+	}
+	c.cpu.current = p.Execute.Address
+	c.registers.WriteWord(PC, p.Execute.Address+c.cpu.instructionSize())
+	if p.Execute.Aborted {
+		c.cpu.fetchAbort(p.Execute.Address, p.Execute.fault)
+		return c.completeStep(p.Execute.Address, true)
+	}
+
+	// Instructions read their operands as they are decoded, so the decode is
+	// repeated now that the previous instruction has written its results
+	status = c.cpu.Execute(c.decodeStage(p.Execute))
+
+	// Only an ARM instruction of 0x0 halts (in Thumb state it's lsls r0, r0, #0)
+	return c.completeStep(p.Execute.Address, status && (p.Execute.Thumb || p.Execute.Bits != 0x0))
+}
+
+// Decodes the instruction held by a pipeline stage.
+//
+// Parameters:
+//  stage - the stage holding the instruction
+//
+// Returns:
+//  instruction - the decoded instruction
+func (c *Computer) decodeStage(stage PipelineStage) (instruction Instruction) {
+	if stage.Thumb {
+		return DecodeThumb(c.cpu, stage.Address, stage.Bits)
+	}
+	return Decode(c.cpu, stage.Address, stage.Bits)
+}
@@ -0,0 +1,108 @@
+package armsim
+
+import "testing"
+
+func expectStage(t *testing.T, name string, stage PipelineStage, valid bool, address uint32) {
+	if stage.Valid != valid || (valid && stage.Address != address) {
+		t.Fatalf("expected %s stage valid %v at %#x, got %+v", name, valid, address, stage)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.EnablePipeline()
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE3A00001) // mov r0, #1
+	c.ram.WriteWord(0x104, 0xEA000002) // b 0x114
+	c.ram.WriteWord(0x108, 0xE3A01002) // mov r1, #2
+	c.ram.WriteWord(0x10C, 0xE3A01002) // mov r1, #2
+	c.ram.WriteWord(0x114, 0xE1A0200F) // mov r2, pc
+
+	tests := []struct {
+		fetch, decode, execute uint32 // 0 for an empty stage
+	}{
+		{0x100, 0, 0},
+		{0x104, 0x100, 0},
+		{0x108, 0x104, 0x100},
+		{0x10C, 0x108, 0x104},
+		{0x114, 0, 0}, // flushed by the branch
+		{0x118, 0x114, 0},
+		{0x11C, 0x118, 0x114},
+	}
+	for i, test := range tests {
+		if !c.Step() {
+			t.Fatalf("cycle %d: unexpected halt", i+1)
+		}
+		p := c.Status().Pipeline
+		expectStage(t, "fetch", p.Fetch, test.fetch != 0, test.fetch)
+		expectStage(t, "decode", p.Decode, test.decode != 0, test.decode)
+		expectStage(t, "execute", p.Execute, test.execute != 0, test.execute)
+	}
+
+	if r, _ := c.registers.ReadWord(r0); r != 1 {
+		t.Fatal("expected r0 1, got", r)
+	}
+	if r, _ := c.registers.ReadWord(r1); r != 0 {
+		t.Fatal("expected the flushed instructions not to execute, got r1", r)
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 0x11C {
+		t.Fatalf("expected r2 0x11c, got %#x", r)
+	}
+	status := c.Status()
+	if !status.Pipelined || status.Steps != 4 {
+		t.Fatalf("expected 3 instructions executed in pipelined mode, got %+v", status.Steps-1)
+	}
+	if status.Pipeline.Execute.Disassembly != "mov r2, r15" {
+		t.Fatalf("expected the executed instruction's disassembly, got %q", status.Pipeline.Execute.Disassembly)
+	}
+}
+
+func TestPipelineAbort(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.EnablePipeline()
+	c.registers.WriteWord(PC, 0x3F8)
+	c.ram.WriteWord(0x3F8, 0xE3A00001) // mov r0, #1
+	c.ram.WriteWord(0x3FC, 0xE3A00002) // mov r0, #2
+
+	// The fetch from 0x400 aborts, but the Prefetch Abort is only taken when
+	// the instruction reaches execute
+	for i := 0; i < 4; i++ {
+		c.Step()
+	}
+	if !c.Status().Pipeline.Fetch.Aborted {
+		t.Fatal("expected the fetch from 0x404 to abort")
+	}
+	if r, _ := c.registers.ReadWord(r0); r != 2 {
+		t.Fatal("expected r0 2, got", r)
+	}
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != PrefetchAbortVector {
+		t.Fatalf("expected pc %#x, got %#x", PrefetchAbortVector, pc)
+	}
+	if lr, _ := c.registers.ReadWord(r14_abt); lr != 0x404 {
+		t.Fatalf("expected r14_abt 0x404, got %#x", lr)
+	}
+
+	c.DisablePipeline()
+	if c.Pipelined() || c.Status().Pipeline.Fetch.Valid {
+		t.Fatal("expected an empty pipeline once disabled")
+	}
+}
+
+func TestPipelineThumbZeroHalfword(t *testing.T) {
+	c := thumbComputer(
+		0x0000, // lsls r0, r0, #0
+		0x2105, // movs r1, #5
+	)
+	c.EnablePipeline()
+
+	// Fill the pipeline, then execute both instructions
+	for i := 0; i < 4; i++ {
+		if !c.Step() {
+			t.Fatalf("cycle %d: unexpected halt", i+1)
+		}
+	}
+	if r, _ := c.registers.ReadWord(r1); r != 5 {
+		t.Fatal("expected 5, got", r)
+	}
+}
@@ -0,0 +1,200 @@
+// Filename: regions.go
+// Contents: Memory regions with read, write and execute permissions, and the
+//	PermissionFault raised by an access a region doesn't permit once
+//	permission checking is enabled. Addresses outside every region (e.g., the
+//	stack and devices) aren't restricted.
+
+package armsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Access permissions (the same bits as the ELF program header flags)
+type Permissions uint8
+
+const (
+	PermExecute Permissions = 1 << iota
+	PermWrite
+	PermRead
+)
+
+// Builds a string such as "r-x".
+func (p Permissions) String() string {
+	s := []byte("---")
+	if p&PermRead != 0 {
+		s[0] = 'r'
+	}
+	if p&PermWrite != 0 {
+		s[1] = 'w'
+	}
+	if p&PermExecute != 0 {
+		s[2] = 'x'
+	}
+	return string(s)
+}
+
+// A range of addresses and the accesses it permits
+type Region struct {
+	Name        string
+	Base        uint32
+	Size        uint32
+	Permissions Permissions
+}
+
+// Returns true if the region contains an address.
+func (r Region) Contains(address uint32) bool {
+	return r.Base <= address && address-r.Base < r.Size
+}
+
+// Builds a string such as "text (0x00001000-0x00001FFF, r-x)".
+func (r Region) String() string {
+	return fmt.Sprintf("%s (0x%08X-0x%08X, %s)", r.Name, r.Base, r.Base+r.Size-1, r.Permissions)
+}
+
+// Parses a region from a string of the form name:base:size:permissions
+// (e.g., "rom:0x0:0x1000:r-x"). The base and size may be decimal or prefixed
+// with 0x, and the permissions are any of r, w and x (with - ignored).
+//
+// Parameters:
+//  s - the string to parse
+//
+// Returns:
+//  region - the parsed region
+//  err - an error if the string is malformed
+func ParseRegion(s string) (region Region, err error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 4 || fields[0] == "" {
+		err = fmt.Errorf("Invalid region %q (expected name:base:size:permissions).", s)
+		return
+	}
+	region.Name = fields[0]
+
+	base, err := strconv.ParseUint(fields[1], 0, 32)
+	if err != nil {
+		err = fmt.Errorf("Invalid base address for region %s: %s.", region.Name, fields[1])
+		return
+	}
+	size, err := strconv.ParseUint(fields[2], 0, 32)
+	if err != nil || size == 0 || base+size-1 > 0xFFFFFFFF {
+		err = fmt.Errorf("Invalid size for region %s: %s.", region.Name, fields[2])
+		return
+	}
+	region.Base, region.Size = uint32(base), uint32(size)
+
+	for _, p := range fields[3] {
+		switch p {
+		case 'r':
+			region.Permissions |= PermRead
+		case 'w':
+			region.Permissions |= PermWrite
+		case 'x':
+			region.Permissions |= PermExecute
+		case '-':
+		default:
+			err = fmt.Errorf("Invalid permissions for region %s: %s.", region.Name, fields[3])
+			return
+		}
+	}
+
+	return
+}
+
+// A PermissionFault is an access to a region that doesn't permit it.
+type PermissionFault struct {
+	Address     uint32      // The address accessed
+	Instruction uint32      // The address of the instruction that made the access
+	Access      Permissions // PermRead, PermWrite or PermExecute
+	Region      Region      // The region that was violated
+}
+
+// Builds the fault report.
+func (f *PermissionFault) Error() string {
+	access := map[Permissions]string{PermRead: "Read from", PermWrite: "Write to", PermExecute: "Execute at"}[f.Access]
+	return fmt.Sprintf("Permission fault: %s 0x%08X by the instruction at 0x%08X violates region %s.",
+		access, f.Address, f.Instruction, f.Region)
+}
+
+// Checks an access against the regions if permission checking is enabled.
+// Configured regions take priority over the segments of the loaded ELF file.
+//
+// Parameters:
+//  address - the address accessed
+//  access - PermRead, PermWrite or PermExecute
+//
+// Returns:
+//  err - a *PermissionFault if the access isn't permitted
+func (cpu *CPU) permissionFault(address uint32, access Permissions) (err error) {
+	if !cpu.permissionChecking {
+		return
+	}
+
+	for _, regions := range [][]Region{cpu.regions, cpu.segments} {
+		for _, r := range regions {
+			if !r.Contains(address) {
+				continue
+			}
+			if r.Permissions&access != 0 {
+				return
+			}
+
+			instruction := cpu.current
+			if access == PermExecute {
+				instruction = address
+			}
+			err = &PermissionFault{address, instruction, access, r}
+			cpu.log.Println(err)
+			return
+		}
+	}
+
+	return
+}
+
+// Checks a load or store against the regions. A permission fault halts the
+// CPU with the fault as its error if halting on faults is enabled, or takes
+// the Data Abort exception, and the instruction should stop executing.
+//
+// Parameters:
+//  address - the address accessed
+//  access - PermRead or PermWrite
+//
+// Returns:
+//  err - the fault, if any
+func (cpu *CPU) checkAccess(address uint32, access Permissions) (err error) {
+	if err = cpu.permissionFault(address, access); err == nil {
+		return
+	}
+
+	if cpu.haltOnFault {
+		cpu.err = err
+	} else {
+		cpu.dataAbort(address)
+	}
+	return
+}
+
+// Handles a failed instruction fetch: a permission fault halts the CPU if
+// halting on faults is enabled, and anything else takes the Prefetch Abort
+// exception (recording the fault status of an MMU fault in CP15).
+//
+// Parameters:
+//  address - the address of the instruction
+//  err - the error from the fetch
+func (cpu *CPU) fetchAbort(address uint32, err error) {
+	var fault *PermissionFault
+	if cpu.haltOnFault && errors.As(err, &fault) {
+		cpu.err = err
+		return
+	}
+
+	var mmuFault *MMUFault
+	if errors.As(err, &mmuFault) && cpu.mmu != nil {
+		cpu.log.Println(err)
+		cpu.mmu.ifsr = mmuFault.fsr()
+	}
+	cpu.prefetchAbort(address)
+}
@@ -0,0 +1,122 @@
+package armsim
+
+import "testing"
+
+func TestParseRegion(t *testing.T) {
+	region, err := ParseRegion("rom:0x1000:4096:r-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region != (Region{"rom", 0x1000, 0x1000, PermRead | PermExecute}) {
+		t.Fatalf("unexpected region %+v", region)
+	}
+	if s := region.String(); s != "rom (0x00001000-0x00001FFF, r-x)" {
+		t.Fatal("unexpected string", s)
+	}
+
+	for _, s := range []string{"rom:0x1000:4096", ":0:4:r", "rom:x:4:r", "rom:0:0:r", "rom:0xFFFFFFFF:2:r", "rom:0:4:q"} {
+		if _, err := ParseRegion(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestPermissionFaults(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.AddRegion(Region{"text", 0x100, 0x100, PermRead | PermExecute})
+	c.AddRegion(Region{"data", 0x200, 0x100, PermRead | PermWrite})
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r1, 0x100)
+	c.registers.WriteWord(r2, 0x200)
+	c.ram.WriteWord(0x100, 0xE5810000) // str r0, [r1]
+	c.ram.WriteWord(0x200, 0xE12FFF12) // bx r2 (never executed)
+
+	// Nothing is enforced until permission checking is enabled
+	c.Step()
+	c.EnablePermissionChecking()
+
+	// Stores to the text region abort
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE5810000)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != DataAbortVector {
+		t.Fatalf("expected pc %#x, got %#x", DataAbortVector, pc)
+	}
+
+	// Fetches from the data region abort
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(PC, 0x200)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != PrefetchAbortVector {
+		t.Fatalf("expected pc %#x, got %#x", PrefetchAbortVector, pc)
+	}
+
+	// Halting reports the instruction and region
+	c.EnableHaltOnFault()
+	c.registers.WriteWord(CPSR, System)
+	c.registers.WriteWord(PC, 0x100)
+	if c.Step() {
+		t.Fatal("expected the computer to halt")
+	}
+	expected := "Permission fault: Write to 0x00000100 by the instruction at 0x00000100 violates region text (0x00000100-0x000001FF, r-x)."
+	if err := c.Err(); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	fault, ok := c.Err().(*PermissionFault)
+	if !ok || fault.Access != PermWrite || fault.Region.Name != "text" {
+		t.Fatalf("unexpected fault %#v", c.Err())
+	}
+
+	// Outside every region, nothing is restricted
+	c.Reset()
+	c.registers.WriteWord(PC, 0x300)
+	c.registers.WriteWord(r1, 0x304)
+	c.ram.WriteWord(0x300, 0xE5810000) // str r0, [r1]
+	if !c.Step() || c.Err() != nil {
+		t.Fatal("expected no fault outside the regions, got", c.Err())
+	}
+}
+
+func TestPipelinePermissionFault(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.AddRegion(Region{"data", 0x104, 0x4, PermRead | PermWrite})
+	c.EnablePermissionChecking()
+	c.EnableHaltOnFault()
+	c.EnablePipeline()
+	c.registers.WriteWord(PC, 0x100)
+	c.ram.WriteWord(0x100, 0xE3A00001) // mov r0, #1
+
+	// The fetch from 0x104 faults, but only halts once it reaches execute
+	for i := 0; i < 3; i++ {
+		if !c.Step() {
+			t.Fatalf("cycle %d: unexpected halt", i+1)
+		}
+	}
+	if c.Step() {
+		t.Fatal("expected the computer to halt")
+	}
+	if fault, ok := c.Err().(*PermissionFault); !ok || fault.Access != PermExecute || fault.Address != 0x104 {
+		t.Fatalf("unexpected fault %v", c.Err())
+	}
+}
+
+func TestLoadELFSegments(t *testing.T) {
+	c := NewComputer(32*1024, nil)
+	c.AddRegion(Region{"stack", 0x6000, 0x1000, PermRead | PermWrite})
+	if err := c.LoadELF("../test_files/sim2/sim2tests/quicksort_no_io.exe"); err != nil {
+		t.Fatal(err)
+	}
+	regions := c.Regions()
+	if len(regions) != 2 || regions[0].Name != "stack" ||
+		regions[1] != (Region{"segment 0", 0x1000, 0x1F8, PermRead | PermExecute}) {
+		t.Fatalf("unexpected regions %v", regions)
+	}
+
+	// The program keeps its array in the text segment
+	c.EnablePermissionChecking()
+	c.EnableHaltOnFault()
+	c.Run(nil, nil)
+	if fault, ok := c.Err().(*PermissionFault); !ok || fault.Access != PermWrite || fault.Region.Name != "segment 0" {
+		t.Fatalf("expected a write fault in segment 0, got %v", c.Err())
+	}
+}
@@ -0,0 +1,242 @@
+// Filename: snapshot.go
+// Contents: Saving and restoring snapshots of a Computer: RAM, the register
+//	bank (including the banked registers), the step and cycle counters,
+//	pending interrupts, the keyboard and console buffers, and the VFP and CP15
+//	registers, in a versioned little-endian binary format.
+
+package armsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The current snapshot format version. LoadSnapshot rejects other versions.
+const SnapshotVersion = 1
+
+var snapshotMagic = [8]byte{'A', 'R', 'M', 'S', 'N', 'A', 'P', 0}
+
+// Snapshot flags
+const (
+	snapshotBigEndian uint32 = 1 << iota
+	snapshotSparse
+	snapshotIRQ // An IRQ is pending
+	snapshotFIQ // An FIQ is pending
+	snapshotVFPUsed
+)
+
+// The fixed-size start of a snapshot. It is followed by the RAM (RAMSize
+// bytes of a flat memory, or RAMSize pages of a sparse memory, each a uint32
+// base address and PageSize bytes), then the keyboard and console buffers.
+type snapshotHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Flags   uint32
+
+	Steps     uint64
+	Cycles    Cycles
+	Registers [registerBankSize]byte
+
+	FPSCR        uint32
+	FPEXC        uint32
+	VFPRegisters [32]uint32
+	CP15         [6]uint32 // Control, TTBR, DACR, FSR, IFSR and FAR
+
+	RAMSize      uint64
+	KeyboardSize uint32
+	ConsoleSize  uint32
+}
+
+// Writes a snapshot of the computer, including the keys and output buffered by
+// the keyboard and console (which stay buffered). If the computer is running
+// (see Run), the snapshot is taken between steps.
+//
+// Parameters:
+//  w - the writer to save the snapshot to
+//
+// Returns:
+//  err - any error from writing the snapshot
+func (c *Computer) SaveSnapshot(w io.Writer) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	h := snapshotHeader{Magic: snapshotMagic, Version: SnapshotVersion}
+
+	if c.BigEndian() {
+		h.Flags |= snapshotBigEndian
+	}
+	if c.ram.Sparse() {
+		h.Flags |= snapshotSparse
+	}
+	if len(c.cpu.irq) > 0 {
+		h.Flags |= snapshotIRQ
+	}
+	if len(c.cpu.fiq) > 0 {
+		h.Flags |= snapshotFIQ
+	}
+	if c.vfp.used {
+		h.Flags |= snapshotVFPUsed
+	}
+
+	h.Steps = c.step_counter
+	h.Cycles = c.cpu.cycles
+	copy(h.Registers[:], c.registers.memory)
+	h.FPSCR, h.FPEXC, h.VFPRegisters = c.vfp.fpscr, c.vfp.fpexc, c.vfp.registers
+	h.CP15 = [6]uint32{c.cp15.control, c.cp15.ttbr, c.cp15.dacr, c.cp15.fsr, c.cp15.ifsr, c.cp15.far}
+
+	pages := c.ram.Pages()
+	h.RAMSize = uint64(len(c.ram.memory))
+	if c.ram.Sparse() {
+		h.RAMSize = uint64(len(pages))
+	}
+	keyboard, console := c.Keyboard.buffered(), c.Console.buffered()
+	h.KeyboardSize, h.ConsoleSize = uint32(len(keyboard)), uint32(len(console))
+
+	if err = binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return
+	}
+
+	if c.ram.Sparse() {
+		for _, base := range pages {
+			if err = binary.Write(w, binary.LittleEndian, base); err != nil {
+				return
+			}
+			if _, err = w.Write(c.ram.pages[base]); err != nil {
+				return
+			}
+		}
+	} else if _, err = w.Write(c.ram.memory); err != nil {
+		return
+	}
+
+	if _, err = w.Write(keyboard); err != nil {
+		return
+	}
+	_, err = w.Write(console)
+	return
+}
+
+// Restores a snapshot written by SaveSnapshot. The RAM must be the same kind
+// (flat or sparse) and, if flat, the same size. Nothing changes if the
+// snapshot is invalid. The pipeline and TLB are flushed, and settings such as
+// tracing, watchpoints and caches are kept. If the computer is running (see
+// Run), the snapshot is restored between steps.
+//
+// Parameters:
+//  r - the reader to load the snapshot from
+//
+// Returns:
+//  err - an error if the snapshot is invalid or doesn't fit the computer
+func (c *Computer) LoadSnapshot(r io.Reader) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var h snapshotHeader
+	if err = binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return fmt.Errorf("Unable to read snapshot: %s.", err)
+	}
+	if h.Magic != snapshotMagic {
+		return errors.New("Not an armsim snapshot.")
+	}
+	if h.Version != SnapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %d (expected %d).", h.Version, SnapshotVersion)
+	}
+
+	// Check the snapshot fits before changing anything
+	sparse := h.Flags&snapshotSparse != 0
+	switch {
+	case sparse != c.ram.Sparse():
+		return errors.New("The snapshot's memory isn't the same kind (flat or sparse) as the RAM.")
+	case !sparse && h.RAMSize != uint64(len(c.ram.memory)):
+		return fmt.Errorf("The snapshot's memory (%d bytes) isn't the same size as the RAM (%d bytes).",
+			h.RAMSize, len(c.ram.memory))
+	case sparse && h.RAMSize > 1<<32/PageSize:
+		return fmt.Errorf("Invalid snapshot page count %d.", h.RAMSize)
+	}
+
+	var memory []byte
+	pages := make(map[uint32][]byte)
+	if sparse {
+		for i := uint64(0); i < h.RAMSize; i++ {
+			var base uint32
+			page := make([]byte, PageSize)
+			if err = binary.Read(r, binary.LittleEndian, &base); err == nil {
+				_, err = io.ReadFull(r, page)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to read snapshot memory: %s.", err)
+			}
+			if base%PageSize != 0 {
+				return fmt.Errorf("Invalid snapshot page address 0x%08X.", base)
+			}
+			pages[base] = page
+		}
+	} else {
+		memory = make([]byte, h.RAMSize)
+		if _, err = io.ReadFull(r, memory); err != nil {
+			return fmt.Errorf("Unable to read snapshot memory: %s.", err)
+		}
+	}
+
+	var keyboard, console []byte
+	if keyboard, err = readBuffer(r, h.KeyboardSize); err == nil {
+		console, err = readBuffer(r, h.ConsoleSize)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read snapshot buffers: %s.", err)
+	}
+
+	// Restore the state
+	if sparse {
+		c.ram.pages = pages
+	} else {
+		copy(c.ram.memory, memory)
+	}
+	copy(c.registers.memory, h.Registers[:])
+	c.SetBigEndian(h.Flags&snapshotBigEndian != 0)
+
+	c.step_counter = h.Steps
+	c.cpu.cycles = h.Cycles
+	c.vfp.fpscr, c.vfp.fpexc, c.vfp.registers = h.FPSCR, h.FPEXC, h.VFPRegisters
+	c.vfp.used = h.Flags&snapshotVFPUsed != 0
+	cp := c.cp15
+	cp.control, cp.ttbr, cp.dacr, cp.fsr, cp.ifsr, cp.far = h.CP15[0], h.CP15[1], h.CP15[2], h.CP15[3], h.CP15[4], h.CP15[5]
+	cp.flushTLB()
+
+	c.Keyboard.setBuffered(keyboard)
+	c.Console.setBuffered(console)
+	setPending(c.cpu.irq, h.Flags&snapshotIRQ != 0)
+	setPending(c.cpu.fiq, h.Flags&snapshotFIQ != 0)
+
+	c.pipeline = Pipeline{}
+	c.cpu.err = nil
+	c.cpu.watchHit = nil
+
+	return
+}
+
+// Helpers
+
+// Reads a keyboard or console buffer of a given size. The buffer grows as it
+// is read, so a corrupt size can't allocate more than the snapshot holds.
+func readBuffer(r io.Reader, size uint32) (data []byte, err error) {
+	var buffer bytes.Buffer
+	if _, err = io.CopyN(&buffer, r, int64(size)); err != nil {
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+// Sets whether an interrupt pin has an interrupt pending.
+func setPending(pin chan bool, pending bool) {
+	select {
+	case <-pin:
+	default:
+	}
+	if pending {
+		pin <- true
+	}
+}
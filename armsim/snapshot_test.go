@@ -0,0 +1,156 @@
+package armsim
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Loads a loop that counts in r0 and stores the count at 0x200
+func loadCounter(c *Computer) {
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r1, 0x200)
+	c.ram.WriteWord(0x100, 0xE2800001) // add r0, r0, #1
+	c.ram.WriteWord(0x104, 0xE5810000) // str r0, [r1]
+	c.ram.WriteWord(0x108, 0xEAFFFFFC) // b 0x100
+}
+
+func TestSnapshot(t *testing.T) {
+	c := NewComputer(1024, nil)
+	loadCounter(c)
+	for i := 0; i < 10; i++ {
+		c.Step()
+	}
+	c.cpu.WriteRegister(SP_irq, 0x3F0)
+	c.vfp.registers[3] = 0x3F800000
+	c.cp15.ttbr = 0x4000
+	c.Keyboard.Press('a')
+	c.Console.StoreByte(0, 'z')
+	c.Irq <- true
+	c.cpu.registers.SetFlag(CPSR, I, true)
+
+	var buffer bytes.Buffer
+	if err := c.SaveSnapshot(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Keyboard.buffered()) != "a" || string(c.Console.buffered()) != "z" || len(c.Irq) != 1 {
+		t.Fatal("expected saving to keep the keyboard and console buffers and pending IRQ")
+	}
+	saved := buffer.Bytes()
+
+	for i := 0; i < 10; i++ {
+		c.Step()
+	}
+	expected := c.Status()
+
+	restored := NewComputer(1024, nil)
+	if err := restored.LoadSnapshot(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if sp, _ := restored.registers.ReadWord(SP_irq); sp != 0x3F0 {
+		t.Fatalf("expected banked r13_irq 0x3F0, got %#x", sp)
+	}
+	if restored.vfp.registers[3] != 0x3F800000 || restored.cp15.ttbr != 0x4000 {
+		t.Fatal("expected the VFP and CP15 registers to be restored")
+	}
+	if string(restored.Keyboard.buffered()) != "a" || len(restored.Irq) != 1 {
+		t.Fatal("expected the keyboard buffer and pending IRQ to be restored")
+	}
+	if output := restored.Console.Read(); string(output) != "z" {
+		t.Fatalf("expected the console buffer to be restored, got %q", output)
+	}
+
+	for i := 0; i < 10; i++ {
+		restored.Step()
+	}
+	status := restored.Status()
+	if status.Registers != expected.Registers || status.Steps != expected.Steps ||
+		status.Cycles != expected.Cycles || status.Checksum != expected.Checksum {
+		t.Fatalf("expected the restored computer to continue as the original:\n%+v\n%+v", status, expected)
+	}
+}
+
+func TestSparseSnapshot(t *testing.T) {
+	c := NewComputerWithMemory(NewSparseMemory(nil), nil)
+	c.SetBigEndian(true)
+	c.ram.WriteWord(0x80000000, 0xDEADBEEF)
+
+	var buffer bytes.Buffer
+	if err := c.SaveSnapshot(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewComputerWithMemory(NewSparseMemory(nil), nil)
+	if err := restored.LoadSnapshot(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := restored.ram.ReadWord(0x80000000); data != 0xDEADBEEF || !restored.BigEndian() {
+		t.Fatalf("expected big-endian 0xDEADBEEF, got %#x", data)
+	}
+	if pages := restored.ram.Pages(); len(pages) != 1 {
+		t.Fatal("expected one page, got", pages)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	c := NewComputer(1024, nil)
+	loadCounter(c)
+	var buffer bytes.Buffer
+	c.SaveSnapshot(&buffer)
+	saved := buffer.Bytes()
+
+	target := NewComputer(1024, nil)
+	checksum := target.Checksum()
+
+	corrupt := func(offset int, b byte) []byte {
+		data := append([]byte(nil), saved...)
+		data[offset] = b
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"magic":     corrupt(0, 'X'),
+		"version":   corrupt(8, 2),
+		"truncated": saved[:len(saved)-100],
+		"empty":     nil,
+	} {
+		if err := target.LoadSnapshot(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	for _, other := range []*Computer{NewComputer(2048, nil), NewComputerWithMemory(NewSparseMemory(nil), nil)} {
+		if err := other.LoadSnapshot(bytes.NewReader(saved)); err == nil {
+			t.Error("expected an error for a different RAM")
+		}
+	}
+
+	if target.Checksum() != checksum {
+		t.Fatal("expected an invalid snapshot not to change the computer")
+	}
+}
+
+func TestSnapshotWhileRunning(t *testing.T) {
+	c := NewComputer(1024, nil)
+	loadCounter(c)
+	c.DisableTracing()
+	halting, finishing := make(chan bool, 1), make(chan bool, 1)
+	go c.Run(halting, finishing)
+
+	// Snapshots wait for the current step, so the saved PC is always at an
+	// instruction of the loop
+	for i := 0; i < 10; i++ {
+		var buffer bytes.Buffer
+		if err := c.SaveSnapshot(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		restored := NewComputer(1024, nil)
+		if err := restored.LoadSnapshot(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		if pc, _ := restored.registers.ReadWord(PC); pc < 0x100 || pc > 0x108 || pc%4 != 0 {
+			t.Fatalf("expected pc in the loop, got %#x", pc)
+		}
+	}
+
+	halting <- true
+	<-finishing
+}
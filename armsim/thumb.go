@@ -0,0 +1,485 @@
+// Filename: thumb.go
+// Contents: The Thumb instruction decoder and the thumbInstruction struct.
+//	Most Thumb instructions have an exact ARM equivalent, so they are
+//	expanded into ARM instruction bits and executed by the ARM instruction
+//	types. Branches and PC-relative arithmetic are executed directly.
+
+package armsim
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Thumb instruction formats (numbered as in the ARM7TDMI data sheet)
+const (
+	thumbUndefined         = iota
+	thumbMoveShifted       // Format 1: LSL, LSR, ASR by immediate
+	thumbAddSubtract       // Format 2: ADD, SUB register or 3-bit immediate
+	thumbImmediate         // Format 3: MOV, CMP, ADD, SUB 8-bit immediate
+	thumbALU               // Format 4: ALU operations
+	thumbHiRegister        // Format 5: Hi register operations and BX
+	thumbPCLoad            // Format 6: PC-relative load
+	thumbRegisterOffset    // Format 7: Load/store with register offset
+	thumbSignExtended      // Format 8: Load/store sign-extended byte/halfword
+	thumbImmediateOffset   // Format 9: Load/store with immediate offset
+	thumbHalfword          // Format 10: Load/store halfword
+	thumbSPLoad            // Format 11: SP-relative load/store
+	thumbLoadAddress       // Format 12: Load address
+	thumbAdjustSP          // Format 13: Add offset to stack pointer
+	thumbPushPop           // Format 14: Push/pop registers
+	thumbMultiple          // Format 15: Multiple load/store
+	thumbConditionalBranch // Format 16: Conditional branch
+	thumbSWI               // Format 17: Software interrupt
+	thumbBranch            // Format 18: Unconditional branch
+	thumbLongBranch        // Format 19: Long branch with link
+)
+
+// Mnemonics for the format 4 ALU operations
+var thumbALUMnemonics = [16]string{"and", "eor", "lsl", "lsr", "asr", "adc",
+	"sbc", "ror", "tst", "neg", "cmp", "cmn", "orr", "mul", "bic", "mvn"}
+
+// ARM data processing opcodes equivalent to the format 4 ALU operations (the
+// shifts are MOVs with a register shift, NEG is an RSB and MUL is special)
+var thumbALUOpcodes = [16]byte{AND, EOR, MOV, MOV, MOV, ADC,
+	SBC, MOV, TST, RSB, CMP, CMN, ORR, 0, BIC, MNV}
+
+// Holds values typical to Thumb instructions.
+type thumbInstruction struct {
+	*baseInstruction // Embed a general instruction
+
+	Format uint32 // Instruction format
+	Rd     uint32 // Destination register
+	Offset int32  // Branch offset or immediate value
+
+	arm      Instruction // Equivalent ARM instruction (nil if executed here)
+	assembly string      // Thumb assembly
+}
+
+// Decodes a Thumb instruction.
+//
+// Parameters:
+//  cpu - the CPU the instruction will execute on
+//  address - the address of the instruction
+//	instructionBits - halfword of data representing the next instruction
+//
+// Returns:
+//	instruction - a decoded instruction of type Instruction
+func DecodeThumb(cpu *CPU, address uint32, instructionBits uint32) (instruction Instruction) {
+	base := new(baseInstruction)
+	base.log = log.New(cpu.logOut, "Thumb Instruction Factory: ", 0)
+
+	base.log.Printf("Decoding instruction: 0x%04x", instructionBits)
+
+	base.cpu = cpu
+	base.Address = address
+	base.InstructionBits = instructionBits & 0xFFFF
+	base.shifter = new(BarrelShifter)
+
+	// Only conditional branches are conditional
+	base.CondCode = AL
+
+	instruction = new(thumbInstruction)
+	instruction.decode(base)
+
+	return
+}
+
+// Decodes a Thumb instruction, expanding it into an ARM instruction when
+// possible.
+//
+// Parameters:
+//  base - a generic instruction containing most information
+//
+// Returns: None
+func (ti *thumbInstruction) decode(base *baseInstruction) {
+	ti.baseInstruction = base
+	ti.log.SetPrefix("Thumb Instruction (Decode): ")
+
+	bits := ti.InstructionBits
+	low3 := func(start uint32) uint32 { return ExtractShiftBits(bits, start, start+3) }
+
+	var arm uint32 // Equivalent ARM instruction bits (0 if none)
+	const al = 0xE0000000
+
+	switch {
+	case bits>>13 == 0x0 && ExtractShiftBits(bits, 11, 13) != 0x3:
+		// Format 1: op Rd, Rm, #imm5 = MOVS Rd, Rm, <shift> #imm5
+		ti.Format = thumbMoveShifted
+		op, imm, rm := ExtractShiftBits(bits, 11, 13), ExtractShiftBits(bits, 6, 11), low3(3)
+		ti.Rd = low3(0)
+		arm = al | 0x01B00000 | ti.Rd<<12 | imm<<7 | op<<5 | rm
+
+		if imm == 0 && op != LSL {
+			imm = 32
+		}
+		ti.assembly = fmt.Sprintf("%s r%d, r%d, #%d", []string{"lsl", "lsr", "asr"}[op], ti.Rd, rm, imm)
+
+	case bits>>11 == 0x3:
+		// Format 2: ADDS/SUBS Rd, Rn, Rm or #imm3
+		ti.Format = thumbAddSubtract
+		i, sub, rn := ExtractShiftBits(bits, 10, 11), ExtractShiftBits(bits, 9, 10) == 1, low3(3)
+		operand := low3(6)
+		ti.Rd = low3(0)
+
+		mnemonic, opcode := "add", uint32(ADD)
+		if sub {
+			mnemonic, opcode = "sub", uint32(SUB)
+		}
+		arm = al | i<<25 | opcode<<21 | 1<<20 | rn<<16 | ti.Rd<<12 | operand
+
+		if i == 1 {
+			ti.assembly = fmt.Sprintf("%s r%d, r%d, #%d", mnemonic, ti.Rd, rn, operand)
+		} else {
+			ti.assembly = fmt.Sprintf("%s r%d, r%d, r%d", mnemonic, ti.Rd, rn, operand)
+		}
+
+	case bits>>13 == 0x1:
+		// Format 3: MOV/CMP/ADD/SUB Rd, #imm8
+		ti.Format = thumbImmediate
+		op, imm := ExtractShiftBits(bits, 11, 13), ExtractShiftBits(bits, 0, 8)
+		ti.Rd = ExtractShiftBits(bits, 8, 11)
+
+		switch op {
+		case 0: // MOVS Rd, #imm8
+			arm = al | 0x03B00000 | ti.Rd<<12 | imm
+		case 1: // CMP Rd, #imm8
+			arm = al | 0x03500000 | ti.Rd<<16 | imm
+		case 2: // ADDS Rd, Rd, #imm8
+			arm = al | 0x02900000 | ti.Rd<<16 | ti.Rd<<12 | imm
+		case 3: // SUBS Rd, Rd, #imm8
+			arm = al | 0x02500000 | ti.Rd<<16 | ti.Rd<<12 | imm
+		}
+		ti.assembly = fmt.Sprintf("%s r%d, #%d", []string{"mov", "cmp", "add", "sub"}[op], ti.Rd, imm)
+
+	case bits>>10 == 0x10:
+		// Format 4: ALU operations
+		ti.Format = thumbALU
+		op, rs := ExtractShiftBits(bits, 6, 10), low3(3)
+		ti.Rd = low3(0)
+
+		opcode := uint32(thumbALUOpcodes[op])
+		switch op {
+		case 0x2, 0x3, 0x4, 0x7:
+			// MOVS Rd, Rd, <shift> Rs
+			shift := op - 0x2 // LSL, LSR or ASR
+			if op == 0x7 {
+				shift = ROR
+			}
+			arm = al | 0x01B00000 | ti.Rd<<12 | rs<<8 | shift<<5 | 1<<4 | ti.Rd
+		case 0x9:
+			// NEG Rd, Rs = RSBS Rd, Rs, #0
+			arm = al | 0x02700000 | rs<<16 | ti.Rd<<12
+		case 0xD:
+			// MULS Rd, Rs, Rd
+			arm = al | 0x00100090 | ti.Rd<<16 | ti.Rd<<8 | rs
+		case 0xF:
+			// MVNS Rd, Rs
+			arm = al | 0x01F00000 | ti.Rd<<12 | rs
+		default:
+			// <op>S Rd, Rd, Rs (comparisons have no destination)
+			arm = al | opcode<<21 | 1<<20 | ti.Rd<<16 | rs
+			if opcode < TST || opcode > CMN {
+				arm |= ti.Rd << 12
+			}
+		}
+		ti.assembly = fmt.Sprintf("%s r%d, r%d", thumbALUMnemonics[op], ti.Rd, rs)
+
+	case bits>>10 == 0x11:
+		// Format 5: Hi register operations and BX
+		ti.Format = thumbHiRegister
+		op := ExtractShiftBits(bits, 8, 10)
+		rm := ExtractShiftBits(bits, 6, 7)<<3 | low3(3)
+		ti.Rd = ExtractShiftBits(bits, 7, 8)<<3 | low3(0)
+
+		switch op {
+		case 0: // ADD Rd, Rd, Rm
+			arm = al | 0x00800000 | ti.Rd<<16 | ti.Rd<<12 | rm
+		case 1: // CMP Rd, Rm
+			arm = al | 0x01500000 | ti.Rd<<16 | rm
+		case 2: // MOV Rd, Rm
+			arm = al | 0x01A00000 | ti.Rd<<12 | rm
+		case 3: // BX Rm
+			arm = al | 0x012FFF10 | rm
+		}
+
+		if op == 3 {
+			ti.assembly = fmt.Sprintf("bx r%d", rm)
+		} else {
+			ti.assembly = fmt.Sprintf("%s r%d, r%d", []string{"add", "cmp", "mov"}[op], ti.Rd, rm)
+		}
+
+	case bits>>11 == 0x9:
+		// Format 6: LDR Rd, [PC, #imm8 * 4]
+		// The PC is word aligned first, so adjust the offset to account for the
+		// unaligned value an ARM instruction will see.
+		ti.Format = thumbPCLoad
+		imm := ExtractShiftBits(bits, 0, 8) * 4
+		ti.Rd = ExtractShiftBits(bits, 8, 11)
+
+		offset := int32(imm) - int32(ti.Address&2)
+		if offset < 0 {
+			arm = al | 0x051F0000 | ti.Rd<<12 | uint32(-offset)
+		} else {
+			arm = al | 0x059F0000 | ti.Rd<<12 | uint32(offset)
+		}
+		ti.assembly = fmt.Sprintf("ldr r%d, [pc, #%d]", ti.Rd, imm)
+
+	case bits>>12 == 0x5 && ExtractShiftBits(bits, 9, 10) == 0:
+		// Format 7: LDR/STR{B} Rd, [Rb, Ro]
+		ti.Format = thumbRegisterOffset
+		l, b, ro, rb := ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 10, 11), low3(6), low3(3)
+		ti.Rd = low3(0)
+		arm = al | 0x07800000 | b<<22 | l<<20 | rb<<16 | ti.Rd<<12 | ro
+
+		mnemonic := []string{"str", "ldr"}[l] + []string{"", "b"}[b]
+		ti.assembly = fmt.Sprintf("%s r%d, [r%d, r%d]", mnemonic, ti.Rd, rb, ro)
+
+	case bits>>12 == 0x5:
+		// Format 8: STRH/LDRH/LDSB/LDSH Rd, [Rb, Ro]
+		ti.Format = thumbSignExtended
+		h, s, ro, rb := ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 10, 11), low3(6), low3(3)
+		ti.Rd = low3(0)
+
+		// Everything but STRH is a load, and STRH is encoded with H set
+		l := uint32(1)
+		if s == 0 && h == 0 {
+			l, h = 0, 1
+		}
+		arm = al | 0x01800090 | l<<20 | rb<<16 | ti.Rd<<12 | s<<6 | h<<5 | ro
+
+		mnemonic := []string{"strh", "ldsb", "ldrh", "ldsh"}[ExtractShiftBits(bits, 10, 12)]
+		ti.assembly = fmt.Sprintf("%s r%d, [r%d, r%d]", mnemonic, ti.Rd, rb, ro)
+
+	case bits>>13 == 0x3:
+		// Format 9: LDR/STR{B} Rd, [Rb, #imm5]
+		ti.Format = thumbImmediateOffset
+		b, l, imm, rb := ExtractShiftBits(bits, 12, 13), ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 6, 11), low3(3)
+		ti.Rd = low3(0)
+
+		// Word transfers scale the offset
+		if b == 0 {
+			imm *= 4
+		}
+		arm = al | 0x05800000 | b<<22 | l<<20 | rb<<16 | ti.Rd<<12 | imm
+
+		mnemonic := []string{"str", "ldr"}[l] + []string{"", "b"}[b]
+		ti.assembly = fmt.Sprintf("%s r%d, [r%d, #%d]", mnemonic, ti.Rd, rb, imm)
+
+	case bits>>12 == 0x8:
+		// Format 10: LDRH/STRH Rd, [Rb, #imm5 * 2]
+		ti.Format = thumbHalfword
+		l, imm, rb := ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 6, 11)*2, low3(3)
+		ti.Rd = low3(0)
+		arm = al | 0x01C000B0 | l<<20 | rb<<16 | ti.Rd<<12 | (imm>>4)<<8 | imm&0xF
+
+		ti.assembly = fmt.Sprintf("%s r%d, [r%d, #%d]", []string{"strh", "ldrh"}[l], ti.Rd, rb, imm)
+
+	case bits>>12 == 0x9:
+		// Format 11: LDR/STR Rd, [SP, #imm8 * 4]
+		ti.Format = thumbSPLoad
+		l, imm := ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 0, 8)*4
+		ti.Rd = ExtractShiftBits(bits, 8, 11)
+		arm = al | 0x058D0000 | l<<20 | ti.Rd<<12 | imm
+
+		ti.assembly = fmt.Sprintf("%s r%d, [sp, #%d]", []string{"str", "ldr"}[l], ti.Rd, imm)
+
+	case bits>>12 == 0xA:
+		// Format 12: ADD Rd, PC/SP, #imm8 * 4
+		ti.Format = thumbLoadAddress
+		sp, imm := ExtractShiftBits(bits, 11, 12) == 1, ExtractShiftBits(bits, 0, 8)
+		ti.Rd = ExtractShiftBits(bits, 8, 11)
+		ti.Offset = int32(imm * 4)
+
+		if sp {
+			// ADD Rd, SP, #imm8 ROR 30
+			arm = al | 0x028D0F00 | ti.Rd<<12 | imm
+			ti.assembly = fmt.Sprintf("add r%d, sp, #%d", ti.Rd, ti.Offset)
+		} else {
+			// The PC is word aligned first, so this is executed directly
+			ti.assembly = fmt.Sprintf("add r%d, pc, #%d", ti.Rd, ti.Offset)
+		}
+
+	case bits>>8 == 0xB0:
+		// Format 13: ADD SP, #+/-imm7 * 4 = ADD/SUB SP, SP, #imm7 ROR 30
+		ti.Format = thumbAdjustSP
+		imm := ExtractShiftBits(bits, 0, 7)
+
+		if ExtractShiftBits(bits, 7, 8) == 1 {
+			arm = al | 0x024DDF00 | imm
+			ti.assembly = fmt.Sprintf("sub sp, #%d", imm*4)
+		} else {
+			arm = al | 0x028DDF00 | imm
+			ti.assembly = fmt.Sprintf("add sp, #%d", imm*4)
+		}
+
+	case bits>>12 == 0xB && ExtractShiftBits(bits, 9, 11) == 0x2:
+		// Format 14: PUSH {Rlist, LR} = STMDB SP!, {...}
+		//            POP {Rlist, PC} = LDMIA SP!, {...}
+		ti.Format = thumbPushPop
+		l, r, list := ExtractShiftBits(bits, 11, 12) == 1, ExtractShiftBits(bits, 8, 9) == 1, ExtractShiftBits(bits, 0, 8)
+
+		if l {
+			if r {
+				list |= 1 << 15
+			}
+			arm = al | 0x08BD0000 | list
+			ti.assembly = fmt.Sprintf("pop {%s}", thumbRegisterList(list))
+		} else {
+			if r {
+				list |= 1 << 14
+			}
+			arm = al | 0x092D0000 | list
+			ti.assembly = fmt.Sprintf("push {%s}", thumbRegisterList(list))
+		}
+
+	case bits>>12 == 0xC:
+		// Format 15: LDMIA/STMIA Rb!, {Rlist}
+		ti.Format = thumbMultiple
+		l, rb, list := ExtractShiftBits(bits, 11, 12), ExtractShiftBits(bits, 8, 11), ExtractShiftBits(bits, 0, 8)
+		arm = al | 0x08A00000 | l<<20 | rb<<16 | list
+
+		ti.assembly = fmt.Sprintf("%s r%d!, {%s}", []string{"stmia", "ldmia"}[l], rb, thumbRegisterList(list))
+
+	case bits>>8 == 0xDF:
+		// Format 17: SWI #imm8
+		ti.Format = thumbSWI
+		imm := ExtractShiftBits(bits, 0, 8)
+		arm = al | 0x0F000000 | imm
+
+		ti.assembly = fmt.Sprintf("swi #%d", imm)
+
+	case bits>>12 == 0xD && ExtractShiftBits(bits, 8, 12) != 0xE:
+		// Format 16: B<cond> label
+		ti.Format = thumbConditionalBranch
+		ti.CondCode = ExtractShiftBits(bits, 8, 12)
+		ti.Offset = int32(int8(ExtractShiftBits(bits, 0, 8))) << 1
+
+		ti.assembly = fmt.Sprintf("b%s #%#x", ConditionMnemonic(ti.CondCode), ti.target())
+
+	case bits>>11 == 0x1C:
+		// Format 18: B label
+		ti.Format = thumbBranch
+		ti.Offset = int32(ExtractShiftBits(bits, 0, 11)<<21) >> 20
+
+		ti.assembly = fmt.Sprintf("b #%#x", ti.target())
+
+	case bits>>12 == 0xF:
+		// Format 19: BL label (two halfwords)
+		ti.Format = thumbLongBranch
+		ti.Offset = int32(ExtractShiftBits(bits, 0, 11))
+
+		if ExtractShiftBits(bits, 11, 12) == 0 {
+			// High part: sign extend and shift into bits 22-12
+			ti.Offset = ti.Offset << 21 >> 9
+
+			// Peek at the low part to show the whole target
+			ti.assembly = "bl (prefix)"
+			if next, err := ti.cpu.ram.ReadHalfWord(ti.Address + 2); err == nil && next>>11 == 0x1F {
+				target := uint32(int32(ti.Address+4)+ti.Offset) + ExtractBits(uint32(next), 0, 11)<<1
+				ti.assembly = fmt.Sprintf("bl #%#x", target)
+			}
+		} else {
+			ti.Offset <<= 1
+			ti.assembly = "bl (suffix)"
+		}
+
+	default:
+		ti.Format = thumbUndefined
+		ti.assembly = "unk"
+	}
+
+	if arm != 0 {
+		ti.log.Printf("ARM equivalent: %#08x", arm)
+		ti.arm = Decode(ti.cpu, ti.Address, arm)
+	}
+
+	ti.log.Printf("Decoded: %s", ti.assembly)
+
+	return
+}
+
+// Executes a Thumb instruction
+//
+// Parameters: None
+//
+// Returns:
+//  status - a boolean that determines if the CPU continues after this
+//  instruction
+func (ti *thumbInstruction) Execute() (status bool) {
+	ti.log.SetPrefix("Thumb Instruction (Execute): ")
+
+	if ti.arm != nil {
+		status = ti.arm.Execute()
+		ti.cycles = ti.arm.timing()
+
+		// Hi register operations that write the PC stay in Thumb state
+		if ti.Format == thumbHiRegister && ti.Rd == 15 {
+			pc, _ := ti.cpu.registers.ReadWord(PC)
+			ti.cpu.registers.WriteWord(PC, pc&^1)
+		}
+		return
+	}
+
+	if !ConditionPassed(ti.baseInstruction) {
+		return true
+	}
+
+	// 1S (plus the pipeline refill for branches)
+	ti.cycles = Cycles{S: 1}
+
+	switch ti.Format {
+	case thumbLoadAddress:
+		// Rd = (PC AND 0xFFFFFFFC) + (imm8 * 4)
+		pc, _ := ti.cpu.FetchRegister(PC)
+		ti.cpu.WriteRegisterFromInstruction(ti.Rd, pc&^3+uint32(ti.Offset))
+
+	case thumbConditionalBranch, thumbBranch:
+		ti.log.Printf("Branching to %X...", ti.target())
+		ti.cpu.WriteRegister(PC, ti.target())
+
+	case thumbLongBranch:
+		if ExtractShiftBits(ti.InstructionBits, 11, 12) == 1 {
+			// Low part: PC = LR + (offset << 1), LR = address of next instruction | 1
+			lr, _ := ti.cpu.FetchRegister(LR)
+			next, _ := ti.cpu.registers.ReadWord(PC)
+			ti.log.Printf("Branching to %X...", lr+uint32(ti.Offset))
+			ti.cpu.WriteRegister(PC, lr+uint32(ti.Offset))
+			ti.cpu.WriteRegister(LR, next|1)
+		} else {
+			// High part: LR = PC + (offset << 12)
+			pc, _ := ti.cpu.FetchRegister(PC)
+			ti.cpu.WriteRegister(LR, uint32(int32(pc)+ti.Offset))
+		}
+
+	default:
+		ti.cycles.I = 1
+		return ti.cpu.undefinedInstruction(ti.Address, ti.InstructionBits)
+	}
+
+	return true
+}
+
+// Builds an assembly string representing the instruction.
+//
+// Returns a string containing the mnemonic and related arguments.
+func (ti *thumbInstruction) Disassemble() (assembly string) {
+	return ti.assembly
+}
+
+// Calculates the target of a B or B<cond> (the PC reads as the instruction
+// address + 4).
+func (ti *thumbInstruction) target() uint32 {
+	return uint32(int32(ti.Address+4) + ti.Offset)
+}
+
+// Builds a human-readable register list from a PUSH/POP or LDMIA/STMIA list
+func thumbRegisterList(list uint32) (registers string) {
+	for i := uint32(0); i < 16; i++ {
+		if list&(1<<i) != 0 {
+			registers += fmt.Sprintf("r%d ", i)
+		}
+	}
+	return strings.TrimSpace(registers)
+}
@@ -0,0 +1,292 @@
+package armsim
+
+import (
+	"strings"
+	"testing"
+)
+
+// Builds a computer in Thumb state with the given halfwords loaded at 0x100
+func thumbComputer(halfwords ...uint16) (c *Computer) {
+	c = NewComputer(1024, nil)
+	for i, h := range halfwords {
+		c.ram.WriteHalfWord(0x100+uint32(i*2), h)
+	}
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(SP, 0x300)
+	c.registers.SetFlag(CPSR, T, true)
+	return
+}
+
+func TestBXThumb(t *testing.T) {
+	// BX r0 into Thumb state
+	c := NewComputer(1024, nil)
+	c.registers.WriteWord(PC, 0x4)
+	c.ram.WriteWord(0x4, 0xE12FFF10)
+	c.registers.WriteWord(r0, 0x101)
+	c.ram.WriteHalfWord(0x100, 0x2105) // movs r1, #5
+	c.ram.WriteHalfWord(0x102, 0x4710) // bx r2
+	c.registers.WriteWord(r2, 0x200)
+	c.Step()
+	if !c.cpu.Thumb() {
+		t.Fatal("expected Thumb state")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x100 {
+		t.Fatalf("expected pc 0x100, got %#x", pc)
+	}
+	if status := c.Status(); status.State != "Thumb" {
+		t.Fatal("expected state Thumb, got", status.State)
+	}
+
+	// Halfword fetch advances the PC by 2
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x102 {
+		t.Fatalf("expected pc 0x102, got %#x", pc)
+	}
+	if r, _ := c.registers.ReadWord(r1); r != 5 {
+		t.Fatal("expected 5, got", r)
+	}
+	if trace := c.Trace(0x100); !strings.Contains(trace, "T\t") {
+		t.Fatal("expected Thumb marker in trace:", trace)
+	}
+
+	// BX r2 back to ARM state
+	c.Step()
+	if c.cpu.Thumb() {
+		t.Fatal("expected ARM state")
+	}
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x200 {
+		t.Fatalf("expected pc 0x200, got %#x", pc)
+	}
+	if trace := c.Trace(0x200); strings.Contains(trace, "T\t") {
+		t.Fatal("unexpected Thumb marker in trace:", trace)
+	}
+}
+
+func TestLoadPCInterworking(t *testing.T) {
+	// LDR pc and LDM into Thumb state
+	for _, instruction := range []uint32{
+		0xE592F000, // ldr pc, [r2]
+		0xE8928000, // ldmia r2, {pc}
+	} {
+		c := NewComputer(1024, nil)
+		c.registers.WriteWord(PC, 0x4)
+		c.ram.WriteWord(0x4, instruction)
+		c.registers.WriteWord(r2, 0x80)
+		c.ram.WriteWord(0x80, 0x101)
+		c.ram.WriteHalfWord(0x100, 0x2105) // movs r1, #5
+		c.Step()
+		if !c.cpu.Thumb() {
+			t.Fatalf("%#08x: expected Thumb state", instruction)
+		}
+		if pc, _ := c.registers.ReadWord(PC); pc != 0x100 {
+			t.Fatalf("%#08x: expected pc 0x100, got %#x", instruction, pc)
+		}
+		c.Step()
+		if r, _ := c.registers.ReadWord(r1); r != 5 {
+			t.Fatalf("%#08x: expected 5, got %d", instruction, r)
+		}
+	}
+
+	// POP {pc} into ARM state, and within Thumb state
+	c := thumbComputer(
+		0xBD00, // pop {pc}
+	)
+	c.ram.WriteWord(0x300, 0x200)
+	c.ram.WriteWord(0x200, 0xE3A01007) // mov r1, #7
+	c.Step()
+	if c.cpu.Thumb() {
+		t.Fatal("expected ARM state")
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r1); r != 7 {
+		t.Fatal("expected 7, got", r)
+	}
+
+	c = thumbComputer(
+		0xBD00, // pop {pc}
+	)
+	c.ram.WriteWord(0x300, 0x181)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); !c.cpu.Thumb() || pc != 0x180 {
+		t.Fatalf("expected Thumb state at 0x180, got %#x", pc)
+	}
+}
+
+func TestThumbDataProcessing(t *testing.T) {
+	c := thumbComputer(
+		0x2105, // movs r1, #5
+		0x1CCA, // adds r2, r1, #3
+		0x0093, // lsls r3, r2, #2
+		0x1A9C, // subs r4, r3, r2
+		0x424D, // negs r5, r1
+		0x434C, // muls r4, r1
+		0x2905, // cmp r1, #5
+		0x46A0, // mov r8, r4
+	)
+
+	expected := []struct{ r, value uint32 }{
+		{r1, 5}, {r2, 8}, {r3, 32}, {r4, 24}, {r5, 0xFFFFFFFB}, {r4, 120}, {r1, 5}, {r8, 120},
+	}
+	for i, e := range expected {
+		c.Step()
+		if r, _ := c.registers.ReadWord(e.r); r != e.value {
+			t.Fatalf("step %d: expected %#x, got %#x", i, e.value, r)
+		}
+	}
+	expectFlags(t, c, false, true, true, false)
+}
+
+func TestThumbZeroHalfword(t *testing.T) {
+	// 0x0000 is lsls r0, r0, #0 in Thumb state, not the end of the program
+	c := thumbComputer(
+		0x0000, // lsls r0, r0, #0
+		0x2105, // movs r1, #5
+	)
+	if !c.Step() {
+		t.Fatal("expected lsls r0, r0, #0 to execute")
+	}
+	if !c.Step() {
+		t.Fatal("expected movs r1, #5 to execute")
+	}
+	if r, _ := c.registers.ReadWord(r1); r != 5 {
+		t.Fatal("expected 5, got", r)
+	}
+}
+
+func TestThumbLoadStore(t *testing.T) {
+	c := thumbComputer(
+		0x6041, // str r1, [r0, #4]
+		0x6842, // ldr r2, [r0, #4]
+		0x8041, // strh r1, [r0, #2]
+		0x7883, // ldrb r3, [r0, #2]
+		0x5FC5, // ldsh r5, [r0, r7]
+		0x4C01, // ldr r4, [pc, #4]
+		0xB502, // push {r1, lr}
+		0xBC40, // pop {r6}
+	)
+	c.ram.WriteWord(0x110, 0xCAFEBABE)
+	c.registers.WriteWord(r0, 0x200)
+	c.registers.WriteWord(r1, 0x12348081)
+	c.registers.WriteWord(r7, 0x2)
+	c.registers.WriteWord(LR, 0x55)
+
+	expected := []struct{ r, value uint32 }{
+		{r1, 0x12348081}, {r2, 0x12348081}, {r1, 0x12348081}, {r3, 0x81}, {r5, 0xFFFF8081},
+		{r4, 0xCAFEBABE}, {SP, 0x2F8}, {r6, 0x12348081},
+	}
+	for i, e := range expected {
+		c.Step()
+		if r, _ := c.registers.ReadWord(e.r); r != e.value {
+			t.Fatalf("step %d: expected %#x, got %#x", i, e.value, r)
+		}
+	}
+	if lr, _ := c.ram.ReadWord(0x2FC); lr != 0x55 {
+		t.Fatalf("expected pushed lr 0x55, got %#x", lr)
+	}
+	if sp, _ := c.registers.ReadWord(SP); sp != 0x2FC {
+		t.Fatalf("expected sp 0x2fc, got %#x", sp)
+	}
+}
+
+func TestThumbBranch(t *testing.T) {
+	c := thumbComputer(
+		0xF000, // bl #0x120 (prefix)
+		0xF80E, // bl #0x120 (suffix)
+		0x2905, // cmp r1, #5
+		0xD000, // beq #0x10a
+		0x2100, // movs r1, #0
+		0xE7FE, // b #0x10a
+	)
+	c.ram.WriteHalfWord(0x120, 0x4770) // bx lr
+
+	c.Step()
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x120 {
+		t.Fatalf("expected pc 0x120, got %#x", pc)
+	}
+	if lr, _ := c.registers.ReadWord(LR); lr != 0x105 {
+		t.Fatalf("expected lr 0x105, got %#x", lr)
+	}
+
+	// Return stays in Thumb state
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x104 || !c.cpu.Thumb() {
+		t.Fatalf("expected pc 0x104 in Thumb state, got %#x", pc)
+	}
+
+	c.registers.WriteWord(r1, 5)
+	c.Step()
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10A {
+		t.Fatalf("expected pc 0x10a, got %#x", pc)
+	}
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x10A {
+		t.Fatalf("expected pc 0x10a, got %#x", pc)
+	}
+}
+
+func TestThumbSWI(t *testing.T) {
+	c := thumbComputer(0xDF05) // swi #5
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x8 {
+		t.Fatalf("expected pc 0x8, got %#x", pc)
+	}
+	if c.cpu.Thumb() {
+		t.Fatal("expected ARM state")
+	}
+	if lr, _ := c.registers.ReadWord(r14_svc); lr != 0x102 {
+		t.Fatalf("expected lr 0x102, got %#x", lr)
+	}
+	if spsr, _ := c.registers.ReadWord(SPSR_svc); ExtractShiftBits(spsr, T, T+1) != 1 {
+		t.Fatal("expected T set in SPSR")
+	}
+}
+
+func TestThumbDisassemble(t *testing.T) {
+	c := thumbComputer(0xF000, 0xF80E)
+	tests := []struct {
+		bits     uint32
+		expected string
+	}{
+		{0x2105, "mov r1, #5"},
+		{0x1CCA, "add r2, r1, #3"},
+		{0x0093, "lsl r3, r2, #2"},
+		{0x1A9C, "sub r4, r3, r2"},
+		{0x424D, "neg r5, r1"},
+		{0x46A0, "mov r8, r4"},
+		{0x4770, "bx r14"},
+		{0x4C01, "ldr r4, [pc, #4]"},
+		{0x5FC5, "ldsh r5, [r0, r7]"},
+		{0x6041, "str r1, [r0, #4]"},
+		{0x8041, "strh r1, [r0, #2]"},
+		{0xB502, "push {r1 r14}"},
+		{0xBC40, "pop {r6}"},
+		{0xC803, "ldmia r0!, {r0 r1}"},
+		{0xB082, "sub sp, #8"},
+		{0xD000, "beq #0x104"},
+		{0xDF05, "swi #5"},
+	}
+	for _, test := range tests {
+		if assembly := DecodeThumb(c.cpu, 0x100, test.bits).Disassemble(); assembly != test.expected {
+			t.Errorf("%#04x: expected %q, got %q", test.bits, test.expected, assembly)
+		}
+	}
+	if assembly := DecodeThumb(c.cpu, 0x100, 0xF000).Disassemble(); assembly != "bl #0x120" {
+		t.Error("expected bl #0x120, got", assembly)
+	}
+}
+
+func TestThumbUndefined(t *testing.T) {
+	c := thumbComputer(0xDE00) // Undefined conditional branch
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+	if c.cpu.Thumb() {
+		t.Fatal("expected ARM state")
+	}
+	if lr, _ := c.registers.ReadWord(r14_und); lr != 0x102 {
+		t.Fatalf("expected lr 0x102, got %#x", lr)
+	}
+}
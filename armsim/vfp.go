@@ -0,0 +1,744 @@
+// Filename: vfp.go
+// Contents: The VFP struct, a VFPv2 floating-point unit attached as
+//	coprocessors 10 (single precision) and 11 (double precision). It holds
+//	s0-s31 (d0-d15 overlap them in pairs), FPSID, FPSCR and FPEXC, and
+//	implements scalar arithmetic, compares, conversions, register transfers
+//	and loads/stores.
+
+package armsim
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// VFP system registers (the Fn field of FMXR/FMRX)
+const (
+	FPSID uint32 = 0x0
+	FPSCR        = 0x1
+	FPEXC        = 0x8
+)
+
+const (
+	vfpID      uint32 = 0x410120B4 // FPSID of an ARM VFP11 (VFPv2)
+	vfpEnable  uint32 = 1 << 30    // FPEXC EN bit
+	fpscrMask  uint32 = 0xF3F79F9F // Writable FPSCR bits
+	fpscrIOC   uint32 = 1 << 0     // Invalid operation cumulative flag
+	fpscrDZC   uint32 = 1 << 1     // Division by zero cumulative flag
+	fpscrIXC   uint32 = 1 << 4     // Inexact cumulative flag
+	fpscrFlags uint32 = 0xF0000000 // FPSCR N, Z, C and V flags
+)
+
+// A VFPv2 floating-point coprocessor
+type VFP struct {
+	// s0-s31; d<n> is s<2n> (low word) and s<2n+1> (high word)
+	registers [32]uint32
+
+	fpscr uint32 // Status and control register
+	fpexc uint32 // Exception register
+
+	// Set once a VFP instruction has executed (used to extend traces)
+	used bool
+
+	// Memory byte order (the high word of a double is stored first when set)
+	bigEndian bool
+
+	log *log.Logger
+}
+
+// Initializes a VFP
+//
+// Parameters:
+//  logOut - an io.Writer out stream for the logger to use (or nil to use StdErr)
+//
+// Returns:
+//  vfp - a pointer to the newly initialized VFP
+func NewVFP(logOut io.Writer) (vfp *VFP) {
+	if logOut == nil {
+		logOut = os.Stderr
+	}
+
+	vfp = new(VFP)
+	vfp.log = log.New(logOut, "VFP: ", 0)
+	vfp.Reset()
+
+	return
+}
+
+// Clears the registers. The VFP starts enabled (FPEXC.EN set), so programs
+// can use it without a support routine.
+func (vfp *VFP) Reset() {
+	vfp.registers = [32]uint32{}
+	vfp.fpscr = 0
+	vfp.fpexc = vfpEnable
+	vfp.used = false
+}
+
+// Returns the raw contents of s0-s31.
+func (vfp *VFP) Registers() (registers [32]uint32) {
+	return vfp.registers
+}
+
+// Returns the FPSCR.
+func (vfp *VFP) FPSCR() (fpscr uint32) {
+	return vfp.fpscr
+}
+
+// Reports whether a VFP instruction has executed since the last reset.
+func (vfp *VFP) Used() (used bool) {
+	return vfp.used
+}
+
+// Coprocessor interface
+
+// Executes FMAC, FNMAC, FMSC, FNMSC, FMUL, FNMUL, FADD, FSUB, FDIV and the
+// extension operations (copies, compares, square root and conversions).
+//
+// Parameters:
+//  op - the decoded CDP instruction
+//
+// Returns:
+//  ok - false if the instruction is undefined
+func (vfp *VFP) DataOperation(op CoprocessorOperation) (ok bool) {
+	if !vfp.enabled() {
+		return false
+	}
+	vfp.used = true
+
+	double := op.Number == 11
+	bits := op.InstructionBits
+	d, n, m := vfpRegister(bits, 12, 22, double), vfpRegister(bits, 16, 7, double),
+		vfpRegister(bits, 0, 5, double)
+
+	opcode := vfpOpcode(bits)
+	if opcode == 0xF {
+		return vfp.extensionOperation(op, double, d, m)
+	}
+
+	a, b := vfp.read(double, n), vfp.read(double, m)
+	var result float64
+	switch opcode {
+	case 0x0, 0x1, 0x2, 0x3: // FMAC, FNMAC, FMSC, FNMSC
+		// The product is rounded before the accumulate (not fused)
+		product := float64(a * b)
+		if !double {
+			product = float64(float32(product))
+		}
+		if opcode&0x1 == 1 {
+			product = -product
+		}
+		accumulator := vfp.read(double, d)
+		if opcode&0x2 == 2 {
+			accumulator = -accumulator
+		}
+		result = accumulator + product
+		vfp.checkInvalid(result, a, b, accumulator)
+	case 0x4, 0x5: // FMUL, FNMUL
+		result = a * b
+		if opcode == 0x5 {
+			result = -result
+		}
+		vfp.checkInvalid(result, a, b)
+	case 0x6: // FADD
+		result = a + b
+		vfp.checkInvalid(result, a, b)
+	case 0x7: // FSUB
+		result = a - b
+		vfp.checkInvalid(result, a, b)
+	case 0x8: // FDIV
+		if b == 0 && a == a && a != 0 && !math.IsInf(a, 0) {
+			vfp.fpscr |= fpscrDZC
+		}
+		result = a / b
+		vfp.checkInvalid(result, a, b)
+	default:
+		return false
+	}
+
+	vfp.write(double, d, result)
+	return true
+}
+
+// Executes FMSR/FMRS (cp10), FMDLR/FMDHR (cp11) and FMXR (cp10).
+//
+// Parameters:
+//  op - the decoded MCR instruction
+//  data - the value of the ARM register
+//
+// Returns:
+//  ok - false if the instruction is undefined
+func (vfp *VFP) MoveTo(op CoprocessorOperation, data uint32) (ok bool) {
+	if op.Number == 10 && op.Opcode1 == 0x7 { // FMXR
+		switch op.CRn {
+		case FPSID:
+			// Read only
+		case FPSCR:
+			if !vfp.enabled() {
+				return false
+			}
+			vfp.fpscr = data & fpscrMask
+		case FPEXC:
+			vfp.fpexc = data & (vfpEnable | 1<<31)
+		default:
+			return false
+		}
+		vfp.used = true
+		return true
+	}
+
+	r, ok := vfp.transferRegister(op)
+	if !ok || !vfp.enabled() {
+		return false
+	}
+	vfp.used = true
+	vfp.registers[r] = data
+
+	return true
+}
+
+// Executes FMRS (cp10), FMRDL/FMRDH (cp11) and FMRX (cp10). FMRX r15, FPSCR
+// (FMSTAT) copies the FPSCR flags into the CPSR.
+//
+// Parameters:
+//  op - the decoded MRC instruction
+//
+// Returns:
+//  data - the value for the ARM register
+//  ok - false if the instruction is undefined
+func (vfp *VFP) MoveFrom(op CoprocessorOperation) (data uint32, ok bool) {
+	if op.Number == 10 && op.Opcode1 == 0x7 { // FMRX
+		switch op.CRn {
+		case FPSID:
+			data = vfpID
+		case FPSCR:
+			if !vfp.enabled() {
+				return 0, false
+			}
+			data = vfp.fpscr
+		case FPEXC:
+			data = vfp.fpexc
+		default:
+			return 0, false
+		}
+		vfp.used = true
+		return data, true
+	}
+
+	r, ok := vfp.transferRegister(op)
+	if !ok || !vfp.enabled() {
+		return 0, false
+	}
+	vfp.used = true
+
+	return vfp.registers[r], true
+}
+
+// Executes FMSRR (cp10) and FMDRR (cp11).
+//
+// Parameters:
+//  op - the decoded MCRR instruction
+//  low - the value of Rd
+//  high - the value of Rn
+//
+// Returns:
+//  ok - false if the instruction is undefined
+func (vfp *VFP) MoveToDouble(op CoprocessorOperation, low, high uint32) (ok bool) {
+	r, ok := vfp.doubleTransferRegister(op)
+	if !ok {
+		return
+	}
+	vfp.registers[r], vfp.registers[r+1] = low, high
+
+	return
+}
+
+// Executes FMRRS (cp10) and FMRRD (cp11).
+//
+// Parameters:
+//  op - the decoded MRRC instruction
+//
+// Returns:
+//  low - the value for Rd
+//  high - the value for Rn
+//  ok - false if the instruction is undefined
+func (vfp *VFP) MoveFromDouble(op CoprocessorOperation) (low, high uint32, ok bool) {
+	r, ok := vfp.doubleTransferRegister(op)
+	if !ok {
+		return
+	}
+
+	return vfp.registers[r], vfp.registers[r+1], true
+}
+
+// Returns the number of words transferred by FLDS, FLDD, FSTS, FSTD and the
+// FLDM/FSTM forms (S, D and X).
+//
+// Parameters:
+//  op - the decoded LDC/STC instruction
+//
+// Returns:
+//  words - the number of words to transfer
+//  ok - false if the instruction is undefined
+func (vfp *VFP) TransferLength(op CoprocessorOperation) (words uint32, ok bool) {
+	if !vfp.enabled() {
+		return 0, false
+	}
+
+	p, u, w := vfpIndexing(op.InstructionBits)
+	if p && !w { // FLDS/FLDD, FSTS/FSTD
+		words = 1
+		if op.Number == 11 {
+			words = 2
+		}
+	} else if p == u { // Only increment after and decrement before exist
+		return 0, false
+	} else {
+		words = op.Offset
+	}
+
+	// FLDMX/FSTMX transfer an extra word after the registers
+	registers := words
+	if op.Number == 11 {
+		registers -= words % 2
+	}
+	if words == 0 || vfpTransferBase(op)+registers > 32 {
+		return 0, false
+	}
+	vfp.used = true
+
+	return words, true
+}
+
+// Receives a word for FLDS, FLDD or FLDM.
+//
+// Parameters:
+//  op - the decoded LDC instruction
+//  index - the index of the word
+//  data - the word loaded from memory
+//
+// Returns: None
+func (vfp *VFP) Load(op CoprocessorOperation, index, data uint32) {
+	// The extra word of FLDMX is ignored
+	if r := vfp.transferWord(op, index); r < 32 {
+		vfp.registers[r] = data
+	}
+}
+
+// Returns a word for FSTS, FSTD or FSTM.
+//
+// Parameters:
+//  op - the decoded STC instruction
+//  index - the index of the word
+//
+// Returns:
+//  data - the word to store
+func (vfp *VFP) Store(op CoprocessorOperation, index uint32) (data uint32) {
+	// The extra word of FSTMX is stored as zero
+	if r := vfp.transferWord(op, index); r < 32 {
+		data = vfp.registers[r]
+	}
+	return
+}
+
+// Builds the VFP assembly for an operation (e.g., "fadds s0, s1, s2").
+//
+// Parameters:
+//  op - the decoded coprocessor instruction
+//
+// Returns:
+//  assembly - the disassembled instruction
+//  ok - false if the instruction is not a VFP instruction
+func (vfp *VFP) Disassemble(op CoprocessorOperation) (assembly string, ok bool) {
+	bits := op.InstructionBits
+	double := op.Number == 11
+	cond := ConditionMnemonic(ExtractShiftBits(bits, 28, 32))
+	precision := "s"
+	if double {
+		precision = "d"
+	}
+
+	d, n, m := vfpRegisterName(bits, 12, 22, double), vfpRegisterName(bits, 16, 7, double),
+		vfpRegisterName(bits, 0, 5, double)
+	rd := fmt.Sprintf("r%d", op.Rd)
+
+	switch ExtractShiftBits(bits, 24, 28) {
+	case 0xE:
+		if ExtractShiftBits(bits, 4, 5) == 0 { // CDP
+			opcode := vfpOpcode(bits)
+			if opcode < 0x9 {
+				mnemonics := []string{"fmac", "fnmac", "fmsc", "fnmsc", "fmul", "fnmul", "fadd", "fsub", "fdiv"}
+				return fmt.Sprintf("%s%s%s %s, %s, %s", mnemonics[opcode], precision, cond, d, n, m), true
+			} else if opcode != 0xF {
+				return
+			}
+
+			sm := vfpRegisterName(bits, 0, 5, false)
+			sd := vfpRegisterName(bits, 12, 22, false)
+			switch vfpExtension(bits) {
+			case 0x00, 0x01, 0x02, 0x03:
+				mnemonics := []string{"fcpy", "fabs", "fneg", "fsqrt"}
+				assembly = fmt.Sprintf("%s%s%s %s, %s", mnemonics[vfpExtension(bits)], precision, cond, d, m)
+			case 0x08, 0x09:
+				mnemonics := []string{"fcmp", "fcmpe"}
+				assembly = fmt.Sprintf("%s%s%s %s, %s", mnemonics[vfpExtension(bits)&1], precision, cond, d, m)
+			case 0x0A, 0x0B:
+				mnemonics := []string{"fcmpz", "fcmpez"}
+				assembly = fmt.Sprintf("%s%s%s %s", mnemonics[vfpExtension(bits)&1], precision, cond, d)
+			case 0x0F:
+				if double {
+					assembly = fmt.Sprintf("fcvtsd%s %s, %s", cond, sd, m)
+				} else {
+					assembly = fmt.Sprintf("fcvtds%s %s, %s", cond, vfpRegisterName(bits, 12, 22, true), sm)
+				}
+			case 0x10, 0x11:
+				mnemonics := []string{"fuito", "fsito"}
+				assembly = fmt.Sprintf("%s%s%s %s, %s", mnemonics[vfpExtension(bits)&1], precision, cond, d, sm)
+			case 0x18, 0x19, 0x1A, 0x1B:
+				mnemonics := []string{"ftoui", "ftouiz", "ftosi", "ftosiz"}
+				assembly = fmt.Sprintf("%s%s%s %s, %s", mnemonics[vfpExtension(bits)&3], precision, cond, sd, m)
+			default:
+				return
+			}
+			return assembly, true
+		}
+
+		// MCR/MRC
+		l := ExtractShiftBits(bits, 20, 21) == 1
+		switch {
+		case !double && op.Opcode1 == 0x7:
+			system := map[uint32]string{FPSID: "fpsid", FPSCR: "fpscr", FPEXC: "fpexc"}[op.CRn]
+			if system == "" {
+				return
+			}
+			if !l {
+				assembly = fmt.Sprintf("fmxr%s %s, %s", cond, system, rd)
+			} else if op.Rd == 15 && op.CRn == FPSCR {
+				assembly = "fmstat" + cond
+			} else {
+				assembly = fmt.Sprintf("fmrx%s %s, %s", cond, rd, system)
+			}
+		case !double && op.Opcode1 == 0x0:
+			if l {
+				assembly = fmt.Sprintf("fmrs%s %s, %s", cond, rd, n)
+			} else {
+				assembly = fmt.Sprintf("fmsr%s %s, %s", cond, n, rd)
+			}
+		case double && op.Opcode1 <= 0x1:
+			half := []string{"l", "h"}[op.Opcode1]
+			if l {
+				assembly = fmt.Sprintf("fmrd%s%s %s, %s", half, cond, rd, n)
+			} else {
+				assembly = fmt.Sprintf("fmd%sr%s %s, %s", half, cond, n, rd)
+			}
+		default:
+			return
+		}
+		return assembly, true
+	case 0xC, 0xD:
+		if bits&0x0FE00000 == 0x0C400000 { // MCRR/MRRC
+			rn := fmt.Sprintf("r%d", op.Rn)
+			if !double {
+				sm := vfpRegister(bits, 0, 5, false)
+				m = fmt.Sprintf("{s%d, s%d}", sm, sm+1)
+			}
+			if ExtractShiftBits(bits, 20, 21) == 1 {
+				assembly = fmt.Sprintf("fmrr%s%s %s, %s, %s", precision, cond, rd, rn, m)
+			} else {
+				assembly = fmt.Sprintf("fm%srr%s %s, %s, %s", precision, cond, m, rd, rn)
+			}
+			return assembly, true
+		}
+
+		mnemonic := "fst"
+		if ExtractShiftBits(bits, 20, 21) == 1 {
+			mnemonic = "fld"
+		}
+		p, u, w := vfpIndexing(bits)
+		rn := fmt.Sprintf("r%d", ExtractShiftBits(bits, 16, 20))
+		if p && !w {
+			sign := ""
+			if !u {
+				sign = "-"
+			}
+			return fmt.Sprintf("%s%s%s %s, [%s, #%s%d]", mnemonic, precision, cond, d, rn, sign, op.Offset*4), true
+		}
+
+		mode := "ia"
+		if p {
+			mode = "db"
+		}
+		count := op.Offset
+		if double {
+			if count%2 == 1 {
+				precision = "x"
+			}
+			count /= 2
+		}
+		if w {
+			rn += "!"
+		}
+		list := d
+		if count > 1 {
+			first := vfpRegister(bits, 12, 22, double)
+			list += fmt.Sprintf("-%s%d", d[:1], first+count-1)
+		}
+		return fmt.Sprintf("%sm%s%s%s %s, {%s}", mnemonic, mode, precision, cond, rn, list), true
+	}
+
+	return
+}
+
+// Helper Methods
+
+// Executes the extension operations (opcode 0xF).
+//
+// Parameters:
+//  op - the decoded CDP instruction
+//  double - true for cp11 (double precision)
+//  d - the destination register number in the instruction's precision
+//  m - the operand register number in the instruction's precision
+//
+// Returns:
+//  ok - false if the instruction is undefined
+func (vfp *VFP) extensionOperation(op CoprocessorOperation, double bool, d, m uint32) (ok bool) {
+	bits := op.InstructionBits
+	sd := vfpRegister(bits, 12, 22, false)
+	sm := vfpRegister(bits, 0, 5, false)
+
+	switch extension := vfpExtension(bits); extension {
+	case 0x00: // FCPY
+		vfp.copyRaw(double, d, m, 0, 0)
+	case 0x01: // FABS
+		vfp.copyRaw(double, d, m, 0x80000000, 0)
+	case 0x02: // FNEG
+		vfp.copyRaw(double, d, m, 0, 0x80000000)
+	case 0x03: // FSQRT
+		a := vfp.read(double, m)
+		result := math.Sqrt(a)
+		vfp.checkInvalid(result, a)
+		vfp.write(double, d, result)
+	case 0x08, 0x09: // FCMP, FCMPE
+		vfp.compare(vfp.read(double, d), vfp.read(double, m), extension == 0x09)
+	case 0x0A, 0x0B: // FCMPZ, FCMPEZ
+		vfp.compare(vfp.read(double, d), 0, extension == 0x0B)
+	case 0x0F: // FCVTDS (cp10), FCVTSD (cp11)
+		if double {
+			vfp.write(false, sd, vfp.read(true, m))
+		} else {
+			vfp.write(true, vfpRegister(bits, 12, 22, true), vfp.read(false, sm))
+		}
+	case 0x10: // FUITO
+		vfp.write(double, d, float64(vfp.registers[sm]))
+	case 0x11: // FSITO
+		vfp.write(double, d, float64(int32(vfp.registers[sm])))
+	case 0x18, 0x19, 0x1A, 0x1B: // FTOUI, FTOUIZ, FTOSI, FTOSIZ
+		signed := extension >= 0x1A
+		roundZero := extension&1 == 1
+		vfp.registers[sd] = vfp.toInteger(vfp.read(double, m), signed, roundZero)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Copies a register without interpreting it, clearing and flipping bits in
+// the sign word (for FCPY, FABS and FNEG).
+func (vfp *VFP) copyRaw(double bool, d, m, clear, flip uint32) {
+	if double {
+		vfp.registers[2*d] = vfp.registers[2*m]
+		d, m = 2*d+1, 2*m+1
+	}
+	vfp.registers[d] = (vfp.registers[m] &^ clear) ^ flip
+}
+
+// Sets the FPSCR flags for a compare (N for less than, Z and C for equal, C
+// for greater than and C and V for unordered).
+func (vfp *VFP) compare(a, b float64, signalNaN bool) {
+	var flags uint32
+	switch {
+	case a != a || b != b:
+		flags = 0x3
+		if signalNaN {
+			vfp.fpscr |= fpscrIOC
+		}
+	case a == b:
+		flags = 0x6
+	case a < b:
+		flags = 0x8
+	default:
+		flags = 0x2
+	}
+	vfp.fpscr = vfp.fpscr&^fpscrFlags | flags<<28
+}
+
+// Converts to a 32-bit integer, saturating out-of-range values (NaN converts
+// to 0). Without roundZero, the FPSCR rounding mode is used.
+func (vfp *VFP) toInteger(value float64, signed, roundZero bool) (result uint32) {
+	rounded := math.Trunc(value)
+	if !roundZero {
+		switch ExtractShiftBits(vfp.fpscr, 22, 24) {
+		case 0x0: // Round to nearest
+			rounded = math.RoundToEven(value)
+		case 0x1: // Round towards plus infinity
+			rounded = math.Ceil(value)
+		case 0x2: // Round towards minus infinity
+			rounded = math.Floor(value)
+		}
+	}
+
+	min, max := 0.0, float64(math.MaxUint32)
+	if signed {
+		min, max = math.MinInt32, math.MaxInt32
+	}
+	switch {
+	case value != value:
+		vfp.fpscr |= fpscrIOC
+		return 0
+	case rounded < min:
+		vfp.fpscr |= fpscrIOC
+		rounded = min
+	case rounded > max:
+		vfp.fpscr |= fpscrIOC
+		rounded = max
+	case rounded != value:
+		vfp.fpscr |= fpscrIXC
+	}
+
+	if signed {
+		return uint32(int32(rounded))
+	}
+	return uint32(rounded)
+}
+
+// Sets the invalid operation flag if an operation produced a NaN from
+// operands that were not NaNs.
+func (vfp *VFP) checkInvalid(result float64, operands ...float64) {
+	if result == result {
+		return
+	}
+	for _, operand := range operands {
+		if operand != operand {
+			return
+		}
+	}
+	vfp.fpscr |= fpscrIOC
+}
+
+// Reads a register of the given precision.
+func (vfp *VFP) read(double bool, r uint32) (value float64) {
+	if double {
+		return math.Float64frombits(uint64(vfp.registers[2*r+1])<<32 | uint64(vfp.registers[2*r]))
+	}
+	return float64(math.Float32frombits(vfp.registers[r]))
+}
+
+// Writes a register of the given precision (rounding singles).
+func (vfp *VFP) write(double bool, r uint32, value float64) {
+	if double {
+		bits := math.Float64bits(value)
+		vfp.registers[2*r], vfp.registers[2*r+1] = uint32(bits), uint32(bits>>32)
+		return
+	}
+	vfp.registers[r] = math.Float32bits(float32(value))
+}
+
+// Reports whether FPEXC.EN is set.
+func (vfp *VFP) enabled() (enabled bool) {
+	return vfp.fpexc&vfpEnable != 0
+}
+
+// Returns the single register for FMSR/FMRS (Sn) or FMDxR/FMRDx (half of Dn).
+func (vfp *VFP) transferRegister(op CoprocessorOperation) (r uint32, ok bool) {
+	if op.Number == 10 && op.Opcode1 == 0x0 {
+		return vfpRegister(op.InstructionBits, 16, 7, false), true
+	} else if op.Number == 11 && op.Opcode1 <= 0x1 {
+		return 2*op.CRn + op.Opcode1, true
+	}
+	return 0, false
+}
+
+// Returns the first single register for FMSRR/FMRRS (Sm) or FMDRR/FMRRD (Dm).
+func (vfp *VFP) doubleTransferRegister(op CoprocessorOperation) (r uint32, ok bool) {
+	if !vfp.enabled() || op.Opcode1&0xD != 0x1 {
+		return 0, false
+	}
+	vfp.used = true
+
+	if op.Number == 11 {
+		return 2 * op.CRm, true
+	}
+	r = vfpRegister(op.InstructionBits, 0, 5, false)
+	return r, r < 31
+}
+
+// Returns the single register for the index-th word of a load or store.
+func (vfp *VFP) transferWord(op CoprocessorOperation, index uint32) (r uint32) {
+	r = vfpTransferBase(op) + index
+	if vfp.bigEndian && op.Number == 11 && r < 32 {
+		// Doubles are stored high word first
+		r ^= 1
+	}
+	return
+}
+
+// Returns the first single register of a load or store.
+func vfpTransferBase(op CoprocessorOperation) (r uint32) {
+	if op.Number == 11 {
+		return 2 * op.CRd
+	}
+	return vfpRegister(op.InstructionBits, 12, 22, false)
+}
+
+// Returns a register number from a 4-bit field and its extra bit (the low
+// bit of single registers, ignored for double registers).
+func vfpRegister(bits, field, extra uint32, double bool) (r uint32) {
+	r = ExtractShiftBits(bits, field, field+4)
+	if !double {
+		r = r<<1 | ExtractShiftBits(bits, extra, extra+1)
+	}
+	return
+}
+
+// Returns a register name (e.g., "s3" or "d1").
+func vfpRegisterName(bits, field, extra uint32, double bool) (name string) {
+	if double {
+		return fmt.Sprintf("d%d", vfpRegister(bits, field, extra, double))
+	}
+	return fmt.Sprintf("s%d", vfpRegister(bits, field, extra, double))
+}
+
+// Returns the p, q, r and s bits of a CDP instruction as an opcode.
+func vfpOpcode(bits uint32) (opcode uint32) {
+	return ExtractShiftBits(bits, 23, 24)<<3 | ExtractShiftBits(bits, 21, 22)<<2 |
+		ExtractShiftBits(bits, 20, 21)<<1 | ExtractShiftBits(bits, 6, 7)
+}
+
+// Returns the Fn and N bits of an extension operation.
+func vfpExtension(bits uint32) (extension uint32) {
+	return vfpRegister(bits, 16, 7, false)
+}
+
+// Returns the P, U and W bits of a load or store.
+func vfpIndexing(bits uint32) (p, u, w bool) {
+	return ExtractShiftBits(bits, 24, 25) == 1, ExtractShiftBits(bits, 23, 24) == 1,
+		ExtractShiftBits(bits, 21, 22) == 1
+}
+
+// Formats s0-s31 for traces, eight registers per line.
+func (vfp *VFP) trace() (output string) {
+	output = fmt.Sprintf("FPSCR=%08X", vfp.fpscr)
+	for i, r := range vfp.registers {
+		if i%8 == 0 {
+			output += "\n\t"
+		} else {
+			output += "\t"
+		}
+		output += fmt.Sprintf("s%-2d=%08X", i, r)
+	}
+	return strings.TrimRight(output, "\t")
+}
@@ -0,0 +1,376 @@
+package armsim
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Builds a computer with the given instructions loaded at 0x100
+func vfpComputer(words ...uint32) (c *Computer) {
+	c = NewComputer(1024, nil)
+	for i, w := range words {
+		c.ram.WriteWord(0x100+uint32(i*4), w)
+	}
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(SP, 0x300)
+	return
+}
+
+func setSingle(c *Computer, s uint32, f float32) {
+	c.vfp.registers[s] = math.Float32bits(f)
+}
+
+func setDouble(c *Computer, d uint32, f float64) {
+	c.vfp.write(true, d, f)
+}
+
+func expectSingle(t *testing.T, c *Computer, s uint32, f float32) {
+	if r := math.Float32frombits(c.vfp.registers[s]); r != f {
+		t.Fatalf("expected s%d = %v, got %v", s, f, r)
+	}
+}
+
+func expectDouble(t *testing.T, c *Computer, d uint32, f float64) {
+	if r := c.vfp.read(true, d); r != f {
+		t.Fatalf("expected d%d = %v, got %v", d, f, r)
+	}
+}
+
+func TestVFPArithmetic(t *testing.T) {
+	c := vfpComputer(
+		0xEE300A81, // fadds s0, s1, s2
+		0xEE310B02, // faddd d0, d1, d2
+		0xEE421A22, // fmacs s3, s4, s5
+		0xEE202B01, // fmuld d2, d0, d1
+		0xEE700A41, // fsubs s1, s0, s2
+		0xEE802A22, // fdivs s4, s0, s5
+		0xEEB13BC2, // fsqrtd d3, d2
+		0xEEB13A40, // fnegs s6, s0
+		0xEEB04BC2, // fabsd d4, d2
+	)
+	setSingle(c, 1, 1.5)
+	setSingle(c, 2, 2.25)
+	c.Step()
+	expectSingle(t, c, 0, 3.75)
+
+	setDouble(c, 1, 10)
+	setDouble(c, 2, 0.5)
+	c.Step()
+	expectDouble(t, c, 0, 10.5)
+
+	setSingle(c, 3, 1)
+	setSingle(c, 4, 3)
+	setSingle(c, 5, 4)
+	c.Step()
+	expectSingle(t, c, 3, 13)
+
+	setDouble(c, 0, 10.5)
+	setDouble(c, 1, 10)
+	c.Step()
+	expectDouble(t, c, 2, 105)
+
+	setSingle(c, 0, 9)
+	setSingle(c, 2, 0.5)
+	c.Step()
+	expectSingle(t, c, 1, 8.5)
+
+	setSingle(c, 5, 4)
+	c.Step()
+	expectSingle(t, c, 4, 2.25)
+
+	setDouble(c, 2, 16)
+	c.Step()
+	expectDouble(t, c, 3, 4)
+
+	c.Step()
+	expectSingle(t, c, 6, -9)
+
+	setDouble(c, 2, -2.5)
+	c.Step()
+	expectDouble(t, c, 4, 2.5)
+}
+
+func TestVFPExceptions(t *testing.T) {
+	c := vfpComputer(
+		0xEE802A22, // fdivs s4, s0, s5
+		0xEE802A22, // fdivs s4, s0, s5
+	)
+	setSingle(c, 0, 1)
+	c.Step()
+	if !math.IsInf(float64(math.Float32frombits(c.vfp.registers[4])), 1) {
+		t.Fatal("expected +inf")
+	}
+	if c.vfp.fpscr&fpscrDZC == 0 {
+		t.Fatal("expected division by zero flag")
+	}
+
+	setSingle(c, 0, 0)
+	c.Step()
+	if c.vfp.fpscr&fpscrIOC == 0 {
+		t.Fatal("expected invalid operation flag")
+	}
+}
+
+func TestVFPCompare(t *testing.T) {
+	c := vfpComputer(
+		0xEEB40A60, // fcmps s0, s1
+		0xEEF1FA10, // fmstat
+		0xEEB40A60, // fcmps s0, s1
+		0xEEF1FA10, // fmstat
+		0xEEB50BC0, // fcmpezd d0
+		0xEEF1FA10, // fmstat
+	)
+	setSingle(c, 0, 1)
+	setSingle(c, 1, 2)
+	c.Step()
+	c.Step()
+	expectFlags(t, c, true, false, false, false)
+
+	setSingle(c, 1, 1)
+	c.Step()
+	c.Step()
+	expectFlags(t, c, false, true, true, false)
+
+	setDouble(c, 0, math.NaN())
+	c.Step()
+	c.Step()
+	expectFlags(t, c, false, false, true, true)
+	if c.vfp.fpscr&fpscrIOC == 0 {
+		t.Fatal("expected invalid operation flag")
+	}
+}
+
+func TestVFPTransfers(t *testing.T) {
+	c := vfpComputer(
+		0xEE001A10, // fmsr s0, r1
+		0xEE102A90, // fmrs r2, s1
+		0xEC410B10, // fmdrr d0, r0, r1
+		0xEC532B11, // fmrrd r2, r3, d1
+		0xEE054B10, // fmdlr d5, r4
+		0xEE355B10, // fmrdh r5, d5
+		0xEEE11A10, // fmxr fpscr, r1
+		0xEEF10A10, // fmrx r0, fpscr
+		0xEEF02A10, // fmrx r2, fpsid
+	)
+	c.registers.WriteWord(r1, 0x3F800000)
+	c.vfp.registers[1] = 0x12345678
+	c.Step()
+	c.Step()
+	if c.vfp.registers[0] != 0x3F800000 {
+		t.Fatalf("expected s0 0x3f800000, got %#x", c.vfp.registers[0])
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 0x12345678 {
+		t.Fatalf("expected r2 0x12345678, got %#x", r)
+	}
+
+	c.registers.WriteWord(r0, 0x11111111)
+	c.vfp.registers[2], c.vfp.registers[3] = 0xAAAAAAAA, 0xBBBBBBBB
+	c.Step()
+	c.Step()
+	if c.vfp.registers[0] != 0x11111111 || c.vfp.registers[1] != 0x3F800000 {
+		t.Fatalf("expected d0 0x3f80000011111111, got %#x%08x", c.vfp.registers[1], c.vfp.registers[0])
+	}
+	if r, _ := c.registers.ReadWord(r2); r != 0xAAAAAAAA {
+		t.Fatalf("expected r2 0xaaaaaaaa, got %#x", r)
+	}
+	if r, _ := c.registers.ReadWord(r3); r != 0xBBBBBBBB {
+		t.Fatalf("expected r3 0xbbbbbbbb, got %#x", r)
+	}
+
+	c.registers.WriteWord(r4, 0x44)
+	c.vfp.registers[11] = 0x55
+	c.Step()
+	c.Step()
+	if c.vfp.registers[10] != 0x44 {
+		t.Fatalf("expected s10 0x44, got %#x", c.vfp.registers[10])
+	}
+	if r, _ := c.registers.ReadWord(r5); r != 0x55 {
+		t.Fatalf("expected r5 0x55, got %#x", r)
+	}
+
+	c.registers.WriteWord(r1, 0x00C00000) // Round towards zero
+	c.Step()
+	c.Step()
+	if r, _ := c.registers.ReadWord(r0); r != 0x00C00000 {
+		t.Fatalf("expected r0 0xc00000, got %#x", r)
+	}
+	c.Step()
+	if r, _ := c.registers.ReadWord(r2); r != vfpID {
+		t.Fatalf("expected r2 %#x, got %#x", vfpID, r)
+	}
+}
+
+func TestVFPConversions(t *testing.T) {
+	c := vfpComputer(
+		0xEEB71AC0, // fcvtds d1, s0
+		0xEEB71BC1, // fcvtsd s2, d1
+		0xEEB82BC0, // fsitod d2, s0
+		0xEEF81A42, // fuitos s3, s4
+		0xEEBD2BC2, // ftosizd s4, d2
+		0xEEFD2A43, // ftosis s5, s6
+		0xEEFC2AC3, // ftouizs s5, s6
+	)
+	setSingle(c, 0, 2.5)
+	c.Step()
+	expectDouble(t, c, 1, 2.5)
+	setDouble(c, 1, 1.1)
+	c.Step()
+	expectSingle(t, c, 2, 1.1)
+
+	c.vfp.registers[0] = 0xFFFFFFFE // -2
+	c.Step()
+	expectDouble(t, c, 2, -2)
+	c.vfp.registers[4] = 0xFFFFFFFE
+	c.Step()
+	expectSingle(t, c, 3, 4294967294)
+
+	setDouble(c, 2, -7.75)
+	c.Step()
+	if c.vfp.registers[4] != 0xFFFFFFF9 {
+		t.Fatalf("expected -7, got %#x", c.vfp.registers[4])
+	}
+
+	// Round to nearest (even) by default
+	setSingle(c, 6, 2.5)
+	c.Step()
+	if c.vfp.registers[5] != 2 {
+		t.Fatal("expected 2, got", c.vfp.registers[5])
+	}
+
+	// Saturation
+	setSingle(c, 6, -1)
+	c.Step()
+	if c.vfp.registers[5] != 0 || c.vfp.fpscr&fpscrIOC == 0 {
+		t.Fatalf("expected 0 with the invalid operation flag, got %#x", c.vfp.registers[5])
+	}
+}
+
+func TestVFPLoadStore(t *testing.T) {
+	c := vfpComputer(
+		0xED910A01, // flds s0, [r1, #4]
+		0xED121B02, // fldd d1, [r2, #-8]
+		0xEDC01A00, // fsts s3, [r0]
+		0xECB00A04, // fldmias r0!, {s0-s3}
+		0xED2D8B04, // fstmdbd r13!, {d8-d9}
+	)
+	c.registers.WriteWord(r0, 0x200)
+	c.registers.WriteWord(r1, 0x200)
+	c.registers.WriteWord(r2, 0x210)
+	c.ram.WriteWord(0x204, 0x3F800000)
+	c.ram.WriteWord(0x208, 0x00000000)
+	c.ram.WriteWord(0x20C, 0x40000000)
+
+	c.Step()
+	expectSingle(t, c, 0, 1)
+	c.Step()
+	expectDouble(t, c, 1, 2)
+
+	setSingle(c, 3, 4)
+	c.Step()
+	if word, _ := c.ram.ReadWord(0x200); word != 0x40800000 {
+		t.Fatalf("expected 0x40800000, got %#x", word)
+	}
+
+	c.Step()
+	expectSingle(t, c, 0, 4)
+	expectSingle(t, c, 1, 1)
+	if r, _ := c.registers.ReadWord(r0); r != 0x210 {
+		t.Fatalf("expected r0 0x210, got %#x", r)
+	}
+
+	setDouble(c, 8, 8)
+	setDouble(c, 9, 9)
+	c.Step()
+	if sp, _ := c.registers.ReadWord(SP); sp != 0x2F0 {
+		t.Fatalf("expected sp 0x2f0, got %#x", sp)
+	}
+	if word, _ := c.ram.ReadWord(0x2FC); word != 0x40220000 {
+		t.Fatalf("expected 0x40220000, got %#x", word)
+	}
+}
+
+func TestVFPDisabled(t *testing.T) {
+	c := vfpComputer(
+		0xEEE80A10, // fmxr fpexc, r0
+		0xEE300A81, // fadds s0, s1, s2
+	)
+	c.Step()
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+
+	// Without the VFP, the instructions are undefined
+	c = vfpComputer(0xEE300A81)
+	c.AttachCoprocessor(10, nil)
+	c.Step()
+	if pc, _ := c.registers.ReadWord(PC); pc != 0x4 {
+		t.Fatalf("expected pc 0x4, got %#x", pc)
+	}
+}
+
+func TestVFPStatus(t *testing.T) {
+	c := vfpComputer(0xE3A00001) // mov r0, #1
+	c.Step()
+	if trace := c.Trace(0x100); strings.Contains(trace, "FPSCR") {
+		t.Fatal("unexpected VFP registers in trace:", trace)
+	}
+
+	c = vfpComputer(0xEE001A10) // fmsr s0, r1
+	c.registers.WriteWord(r1, 0x3F800000)
+	c.Step()
+	if trace := c.Trace(0x100); !strings.Contains(trace, "s0 =3F800000") {
+		t.Fatal("expected VFP registers in trace:", trace)
+	}
+	if status := c.Status(); status.VFPRegisters[0] != 0x3F800000 {
+		t.Fatalf("expected s0 0x3f800000 in status, got %#x", status.VFPRegisters[0])
+	}
+
+	c.Reset()
+	if c.vfp.Used() || c.vfp.registers[0] != 0 {
+		t.Fatal("expected a clean VFP after reset")
+	}
+}
+
+func TestVFPDisassemble(t *testing.T) {
+	c := NewComputer(1024, nil)
+	tests := []struct {
+		bits     uint32
+		expected string
+	}{
+		{0xEE300A81, "fadds s0, s1, s2"},
+		{0xEE310B02, "faddd d0, d1, d2"},
+		{0x0E421A22, "fmacseq s3, s4, s5"},
+		{0xEE310B42, "fsubd d0, d1, d2"},
+		{0xEEB13BC2, "fsqrtd d3, d2"},
+		{0xEEF03A40, "fcpys s7, s0"},
+		{0xEEB40A60, "fcmps s0, s1"},
+		{0xEEB50BC0, "fcmpezd d0"},
+		{0xEEF1FA10, "fmstat"},
+		{0xEEF10A10, "fmrx r0, fpscr"},
+		{0xEEE11A10, "fmxr fpscr, r1"},
+		{0xEE001A10, "fmsr s0, r1"},
+		{0xEE102A90, "fmrs r2, s1"},
+		{0xEC410B10, "fmdrr d0, r0, r1"},
+		{0xEC532B11, "fmrrd r2, r3, d1"},
+		{0xEC410A11, "fmsrr {s2, s3}, r0, r1"},
+		{0xEE054B10, "fmdlr d5, r4"},
+		{0xEE355B10, "fmrdh r5, d5"},
+		{0xEEB71AC0, "fcvtds d1, s0"},
+		{0xEEB71BC1, "fcvtsd s2, d1"},
+		{0xEEB82BC0, "fsitod d2, s0"},
+		{0xEEBD2BC2, "ftosizd s4, d2"},
+		{0xED910A01, "flds s0, [r1, #4]"},
+		{0xED121B02, "fldd d1, [r2, #-8]"},
+		{0xECB00A04, "fldmias r0!, {s0-s3}"},
+		{0xED2D8B04, "fstmdbd r13!, {d8-d9}"},
+		{0xEC910B06, "fldmiad r1, {d0-d2}"},
+		{0xECB00B05, "fldmiax r0!, {d0-d1}"},
+	}
+	for _, test := range tests {
+		if assembly := Decode(c.cpu, 0x100, test.bits).Disassemble(); assembly != test.expected {
+			t.Errorf("%#08x: expected %q, got %q", test.bits, test.expected, assembly)
+		}
+	}
+}
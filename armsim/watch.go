@@ -0,0 +1,165 @@
+// Filename: watch.go
+// Contents: Data watchpoints, which stop execution when an instruction reads
+//	or writes a range of RAM addresses, and the WatchHit reporting the access.
+//	Every access to RAM by an instruction is watched, whether it goes through
+//	the bus or straight to the Memory, but MMU page table walks aren't.
+
+package armsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The accesses a watchpoint stops on
+type WatchKind uint8
+
+const (
+	WatchRead   WatchKind = iota // Any read
+	WatchWrite                   // Any write
+	WatchChange                  // A write that changes the stored value
+	WatchValue                   // A write of a specific value
+)
+
+var watchKindNames = [...]string{"read", "write", "change", "value"}
+
+// Builds a string such as "write".
+func (k WatchKind) String() string {
+	return watchKindNames[k]
+}
+
+// A range of RAM addresses to watch
+type Watchpoint struct {
+	Kind  WatchKind
+	Base  uint32
+	Size  uint32
+	Value uint32 // The value a WatchValue watchpoint stops on
+}
+
+// Returns true if an access overlaps the watched range.
+func (w Watchpoint) overlaps(address, size uint32) bool {
+	return address <= w.Base+w.Size-1 && w.Base <= address+size-1
+}
+
+// Builds a string such as "write 0x00007000-0x00007003" (with "= 0x%08X" for
+// a WatchValue watchpoint).
+func (w Watchpoint) String() string {
+	s := fmt.Sprintf("%s 0x%08X-0x%08X", w.Kind, w.Base, w.Base+w.Size-1)
+	if w.Kind == WatchValue {
+		s += fmt.Sprintf(" = 0x%08X", w.Value)
+	}
+	return s
+}
+
+// Parses a watchpoint from a string of the form kind:address[:size[:value]]
+// (e.g., "write:0x7000:4" or "value:0x7000:4:0xDEADBEEF"). The kind is read,
+// write, change or value, the size defaults to 4 bytes, and the value is
+// required for (and only allowed with) the value kind.
+//
+// Parameters:
+//  s - the string to parse
+//
+// Returns:
+//  w - the parsed watchpoint
+//  err - an error if the string is malformed
+func ParseWatchpoint(s string) (w Watchpoint, err error) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 4 {
+		err = fmt.Errorf("Invalid watchpoint %q (expected kind:address[:size[:value]]).", s)
+		return
+	}
+
+	kind := -1
+	for k, name := range watchKindNames {
+		if strings.ToLower(fields[0]) == name {
+			kind = k
+		}
+	}
+	if kind < 0 {
+		err = fmt.Errorf("Invalid watchpoint kind: %s.", fields[0])
+		return
+	}
+	w.Kind = WatchKind(kind)
+	if (w.Kind == WatchValue) != (len(fields) == 4) {
+		err = fmt.Errorf("Invalid watchpoint %q (only the value kind takes a value).", s)
+		return
+	}
+
+	values := []uint64{0, 4, 0}
+	for i, field := range fields[1:] {
+		if values[i], err = strconv.ParseUint(field, 0, 32); err != nil {
+			err = fmt.Errorf("Invalid watchpoint %s: %s.", []string{"address", "size", "value"}[i], field)
+			return
+		}
+	}
+	w.Base, w.Size, w.Value = uint32(values[0]), uint32(values[1]), uint32(values[2])
+
+	err = w.validate()
+	return
+}
+
+// Checks that the range isn't empty and doesn't wrap.
+func (w Watchpoint) validate() (err error) {
+	if w.Kind > WatchValue {
+		return fmt.Errorf("Invalid watchpoint kind %d.", w.Kind)
+	}
+	if w.Size == 0 || w.Base+w.Size-1 < w.Base {
+		return fmt.Errorf("Invalid watchpoint range (0x%08X, %d bytes).", w.Base, w.Size)
+	}
+	return
+}
+
+// A WatchHit reports the access that triggered a watchpoint.
+type WatchHit struct {
+	Watchpoint Watchpoint
+	PC         uint32 // The address of the instruction that made the access
+	Address    uint32 // The address accessed
+	Size       uint32 // The size of the access in bytes
+	Write      bool
+	Old        uint32 // The previous value (writes only)
+	Data       uint32 // The value read or written
+}
+
+// Builds the report, such as "Watchpoint write 0x00007000-0x00007003: the
+// instruction at 0x00001010 wrote 0x00000005 to 0x00007000 (was 0x00000000)."
+func (h *WatchHit) String() string {
+	access := fmt.Sprintf("read 0x%0*X from 0x%08X", 2*h.Size, h.Data, h.Address)
+	if h.Write {
+		access = fmt.Sprintf("wrote 0x%0*X to 0x%08X (was 0x%0*X)", 2*h.Size, h.Data, h.Address, 2*h.Size, h.Old)
+	}
+	return fmt.Sprintf("Watchpoint %s: the instruction at 0x%08X %s.", h.Watchpoint, h.PC, access)
+}
+
+// Checks a RAM access against the watchpoints (a MemoryWatcher). Only
+// accesses made while an instruction executes are checked, and the first hit
+// of a step is kept.
+func (cpu *CPU) watch(address, size uint32, write bool, old, data uint32) {
+	if !cpu.executing || cpu.watchHit != nil {
+		return
+	}
+
+	for _, w := range cpu.watchpoints {
+		if !w.overlaps(address, size) {
+			continue
+		}
+
+		var hit bool
+		switch w.Kind {
+		case WatchRead:
+			hit = !write
+		case WatchWrite:
+			hit = write
+		case WatchChange:
+			hit = write && old != data
+		case WatchValue:
+			hit = write && data == w.Value
+		}
+
+		if hit {
+			cpu.watchHit = &WatchHit{w, cpu.current, address, size, write, old, data}
+			cpu.log.Println(cpu.watchHit)
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package armsim
+
+import "testing"
+
+func TestParseWatchpoint(t *testing.T) {
+	w, err := ParseWatchpoint("value:0x7000:4:0xDEADBEEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != (Watchpoint{WatchValue, 0x7000, 4, 0xDEADBEEF}) {
+		t.Fatalf("unexpected watchpoint %+v", w)
+	}
+	if s := w.String(); s != "value 0x00007000-0x00007003 = 0xDEADBEEF" {
+		t.Fatal("unexpected string", s)
+	}
+	if w, _ = ParseWatchpoint("read:0x200"); w != (Watchpoint{WatchRead, 0x200, 4, 0}) {
+		t.Fatalf("expected a 4-byte read watchpoint, got %+v", w)
+	}
+
+	for _, s := range []string{"write", "poke:0x200", "write:x", "write:0x200:0", "write:0xFFFFFFFF:2", "write:0x200:4:5", "value:0x200:4"} {
+		if _, err := ParseWatchpoint(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+// A coprocessor whose data operation writes straight to RAM
+type ramCoprocessor struct {
+	testCoprocessor
+	ram *Memory
+}
+
+func (rc *ramCoprocessor) DataOperation(op CoprocessorOperation) (ok bool) {
+	rc.ram.WriteWord(0x300, 7)
+	return true
+}
+
+func TestWatchpoints(t *testing.T) {
+	c := NewComputer(1024, nil)
+	c.AttachCoprocessor(1, &ramCoprocessor{ram: c.ram})
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r0, 5)
+	c.registers.WriteWord(r1, 0x200)
+	c.ram.WriteWord(0x100, 0xE5810000) // str r0, [r1]
+	c.ram.WriteWord(0x104, 0xE5810000) // str r0, [r1]
+	c.ram.WriteWord(0x108, 0xE5D12001) // ldrb r2, [r1, #1]
+	c.ram.WriteWord(0x10C, 0xEE221183) // cdp p1, 2, c1, c2, c3, 4
+	c.ram.WriteWord(0x110, 0xE1A00000) // mov r0, r0
+
+	for _, w := range []Watchpoint{{WatchChange, 0x200, 4, 0}, {WatchRead, 0x201, 1, 0}, {WatchValue, 0x300, 4, 7}} {
+		if err := c.AddWatchpoint(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddWatchpoint(Watchpoint{WatchWrite, 0x200, 0, 0}); err == nil {
+		t.Fatal("expected an error for an empty range")
+	}
+
+	// Changing the value stops after the store
+	if c.Step() {
+		t.Fatal("expected the step to stop at the change watchpoint")
+	}
+	expected := WatchHit{Watchpoint{WatchChange, 0x200, 4, 0}, 0x100, 0x200, 4, true, 0, 5}
+	if hit := c.WatchHit(); hit == nil || *hit != expected {
+		t.Fatalf("expected %+v, got %+v", expected, hit)
+	}
+	if s := c.WatchHit().String(); s != "Watchpoint change 0x00000200-0x00000203: the instruction at 0x00000100 wrote 0x00000005 to 0x00000200 (was 0x00000000)." {
+		t.Fatal("unexpected report", s)
+	}
+	if data, _ := c.ram.ReadWord(0x200); data != 5 {
+		t.Fatal("expected the store to complete, got", data)
+	}
+
+	// Storing the same value again doesn't change it
+	if !c.Step() || c.WatchHit() != nil {
+		t.Fatal("expected no watchpoint hit, got", c.WatchHit())
+	}
+
+	// Reads of an overlapping byte stop
+	if c.Step() || c.WatchHit().PC != 0x108 || c.WatchHit().Write {
+		t.Fatalf("expected a read at 0x108, got %+v", c.WatchHit())
+	}
+
+	// So do writes straight to the Memory
+	if c.Step() || c.WatchHit().PC != 0x10C || c.WatchHit().Address != 0x300 {
+		t.Fatalf("expected a write to 0x300 at 0x10C, got %+v", c.WatchHit())
+	}
+
+	// Reading the memory outside of an instruction isn't watched
+	c.Status()
+	if !c.Step() || c.WatchHit() != nil {
+		t.Fatal("expected no watchpoint hit, got", c.WatchHit())
+	}
+
+	// Removing the watchpoints
+	if err := c.RemoveWatchpoint(Watchpoint{WatchRead, 0x201, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveWatchpoint(Watchpoint{WatchRead, 0x201, 1, 0}); err == nil {
+		t.Fatal("expected an error removing a missing watchpoint")
+	}
+	if len(c.Watchpoints()) != 2 {
+		t.Fatal("expected 2 watchpoints, got", c.Watchpoints())
+	}
+	c.ClearWatchpoints()
+	c.registers.WriteWord(PC, 0x108)
+	if !c.Step() || len(c.Watchpoints()) != 0 {
+		t.Fatal("expected no watchpoints")
+	}
+}
+
+func TestWatchpointPageTables(t *testing.T) {
+	c := NewComputer(0x10000, nil)
+	c.registers.WriteWord(CPSR, System)
+
+	// A section mapping the first 1MB to itself, with the MMU enabled
+	c.ram.WriteWord(0x8000, 0x00000C02)
+	c.cp15.ttbr, c.cp15.dacr, c.cp15.control = 0x8000, 0x1, controlReset|controlM
+	c.AddWatchpoint(Watchpoint{WatchRead, 0x8000, 4, 0})
+
+	// The fetch hits a TLB entry, but the load walks the page tables, and the
+	// walk reading the descriptor isn't watched
+	c.cp15.tlb[0] = tlbEntry{valid: true, virtual: 0x100, mask: 0xFF, physical: 0x100, ap: [4]uint32{3, 3, 3, 3}}
+	c.cp15.tlbNext = 1
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r1, 0x200)
+	c.ram.WriteWord(0x100, 0xE5910000) // ldr r0, [r1]
+	if !c.Step() || c.WatchHit() != nil {
+		t.Fatal("expected no watchpoint hit on a page table walk, got", c.WatchHit())
+	}
+
+	// The program's own read of the descriptor is
+	c.registers.WriteWord(PC, 0x100)
+	c.registers.WriteWord(r1, 0x8000)
+	if c.Step() || c.WatchHit() == nil || c.WatchHit().Address != 0x8000 {
+		t.Fatal("expected a watchpoint hit on the program's read, got", c.WatchHit())
+	}
+}
@@ -26,6 +26,9 @@ $(document).ready(function () {
       case "finished":
         finished();
         break;
+      case "snapshot-saved":
+        alert("Snapshot saved.");
+        break;
       }
       break;
     case "update":
@@ -40,6 +43,9 @@ $(document).ready(function () {
     case "error":
       error(received);
       break;
+    case "watchpoint":
+      alert(received.Content);
+      break;
     }
   };
 
@@ -127,6 +133,10 @@ $(document).ready(function () {
 
   $("#trace-button").click(toggleTrace);
   $("#system-trace-button").click(toggleSystemTrace);
+  $("#pipeline-button").click(togglePipeline);
+  $("#watch-button").click(addWatchpoint);
+  $("#save-snapshot-button").click(saveSnapshot);
+  $("#load-snapshot-button").click(loadSnapshot);
 
   $("#memory-search").submit(function(e) {
     e.preventDefault();
@@ -137,10 +147,9 @@ $(document).ready(function () {
     }
 
     var address = parseInt(q, 16);
-    var row = address >> 4;
     var kth = address & 0xF;
 
-    row = $(".memory-row")[row];
+    var row = $(".memory-row[data-address='" + (address - kth) + "']")[0];
     $("#memory-container").scrollTo( row );
     $(row).addClass("active");
   });
@@ -150,20 +159,29 @@ function update(data) {
   data = JSON.parse(data.Content);
 
   updateFlags(data.Flags);
-  updateDisassembly(data.Disassembly, data.Registers[15]);
+  updateDisassembly(data.Disassembly, data.Registers[15], data.State);
   updateRegisters(data.Registers);
   updateStack(data.Stack, data.Registers[13]);
-  updateMemory(data.Memory);
+  updateVFP(data.FPSCR, data.VFPRegisters);
+  updatePipeline(data.Pipelined, data.Pipeline);
+  updateCaches(data.ICache, data.DCache);
+  updateMemory(data.Memory, data.MemoryPages, data.Endianness);
   updateChecksum(data.Checksum);
-  updateMode(data.Mode);
+  updateMode(data.Mode, data.State);
+  updateCycles(data.Cycles, data.CycleCounts);
 }
 
 function updateChecksum(checksum) {
   $("#checksum").text("Checksum: " + checksum);
 }
 
-function updateMode(mode) {
-  $("#mode").text("Mode: " + mode);
+function updateMode(mode, state) {
+  $("#mode").text("Mode: " + mode + " (" + state + ")");
+}
+
+function updateCycles(total, counts) {
+  $("#cycles").text("Cycles: " + total).attr("title",
+    "S=" + counts.S + " N=" + counts.N + " I=" + counts.I + " C=" + counts.C);
 }
 
 function updateFlags(flags) {
@@ -193,9 +211,69 @@ function updateStack(stack, sp) {
   });
 }
 
-function updateDisassembly(instructions, pc) {
+function updateVFP(fpscr, registers) {
+  $("#vfp tbody").empty();
+  $("#vfp tbody").append("<tr><td>fpscr</td><td>" + hexToString(fpscr) + "</td></tr>");
+  $.each(registers, function (i) {
+    $("#vfp tbody").append("<tr><td>s" + i + "</td><td>" + hexToString(registers[i]) + "</td></tr>");
+  });
+}
+
+function updatePipeline(pipelined, pipeline) {
+  $("#pipeline tbody").empty();
+  if (!pipelined) {
+    $("#pipeline tbody").append("<tr><td colspan=\"3\">Pipeline disabled</td></tr>");
+    return;
+  }
+  $.each(["Fetch", "Decode", "Execute"], function (i, name) {
+    var stage = pipeline[name];
+    var address = "", instruction = "(empty)";
+    if (stage.Valid) {
+      address = hexToString(stage.Address);
+      if (stage.Aborted) {
+        instruction = "(prefetch abort)";
+      } else if (stage.Disassembly != "") {
+        instruction = stage.Disassembly;
+      } else {
+        instruction = hexToString(stage.Bits);
+      }
+    }
+    $("#pipeline tbody").append("<tr><td>" + name + "</td><td>" + address + "</td><td>" + instruction + "</td></tr>");
+  });
+}
+
+function updateCaches(icache, dcache) {
+  $("#caches tbody").empty();
+  if (!icache && !dcache) {
+    $("#caches tbody").append("<tr><td colspan=\"3\">Caches disabled</td></tr>");
+    return;
+  }
+  var cell = function (cache, value) {
+    return "<td>" + (cache ? value(cache) : "-") + "</td>";
+  };
+  var rows = {
+    "Config": function (c) { return c.Config; },
+    "Reads": function (c) { return c.Reads; },
+    "Writes": function (c) { return c.Writes; },
+    "Hits": function (c) { return c.Hits; },
+    "Misses": function (c) { return c.Misses; },
+    "Hit rate": function (c) {
+      var total = c.Hits + c.Misses;
+      return total ? (100 * c.Hits / total).toFixed(1) + "%" : "-";
+    },
+    "Evictions": function (c) { return c.Evictions; },
+    "Writebacks": function (c) { return c.Writebacks; }
+  };
+  $.each(rows, function (name, value) {
+    $("#caches tbody").append("<tr><td>" + name + "</td>" + cell(icache, value) + cell(dcache, value) + "</tr>");
+  });
+}
+
+function updateDisassembly(instructions, pc, state) {
   $("#instructions").empty();
-  var address = pc - 8;
+  // Thumb instructions are halfwords
+  var size = (state == "Thumb") ? 2 : 4;
+  var address = pc - 2 * size;
   $.each(instructions, function (i) {
     if (instructions[i] == "") {
       return;
@@ -215,12 +293,16 @@ function updateDisassembly(instructions, pc) {
       "</span><span class='decoded'>" + decoded +
       "<span class='arguments'>" + arguments +
       "</span></span><span class='comment'>" + comments + "</span></div>");
-    address += 4
+    address += size
   });
 }
 
-function updateMemory(memory) {
+// Size of the pages of a sparse memory (armsim.PageSize)
+var PAGE_SIZE = 0x1000;
+
+function updateMemory(memory, pages, endianness) {
   $("#memory-container").empty();
+  $("#endianness").text(endianness + "-endian");
   var row = "";
   var decoded = "";
   for (i = 0; i < memory.length; i++) {
@@ -231,10 +313,23 @@ function updateMemory(memory) {
         decoded = "";
       }
 
-      row = "<div class='memory-row'><span class='address'>" +
-        hexToString(i) + "</span>";
+      // A sparse memory only sends its allocated pages
+      var address = i;
+      if (pages) {
+        address = pages[Math.floor(i / PAGE_SIZE)] + i % PAGE_SIZE;
+      }
+      row = "<div class='memory-row' data-address='" + address + "'><span class='address'>" +
+        hexToString(address) + "</span>";
+    }
+    // Bytes are grouped into words in the computer's byte order
+    if (i % 4 == 0) {
+      var word = "";
+      for (j = 0; j < 4; j++) {
+        var b = nToWidth(memory[i + j].toString(16), 2);
+        word = (endianness == "Big") ? word + b : b + word;
+      }
+      row += word + " ";
     }
-    row += nToWidth(memory[i].toString(16), 2) + " ";
     var ascii = String.fromCharCode(memory[i]);
     decoded += ((/[\x00-\x1F\x80-\xFF]/.test(ascii)) ? "." : ascii);
   }
@@ -270,6 +365,20 @@ function toggleSystemTrace() {
   $("#system-trace-button").toggleClass("btn-success");
 }
 
+function togglePipeline() {
+  var content = "off";
+  if ($("#pipeline-button").hasClass("btn-success")) {
+    // Turn the pipeline on
+    content = "on";
+  }
+
+  ws.send("pipeline", content);
+
+  $("#pipeline-button").html("Turn-" + (content == "on" ? "off" : "on") + " Pipeline");
+  $("#pipeline-button").toggleClass("btn-danger");
+  $("#pipeline-button").toggleClass("btn-success");
+}
+
 function loadFile() {
   var filePath = prompt("Please enter your filename (relative to the executable).");
 
@@ -282,6 +391,29 @@ function loadFile() {
   ws.send("load", filePath);
 }
 
+function addWatchpoint() {
+  var watchpoint = prompt("Please enter a watchpoint as kind:address[:size[:value]] " +
+    "(kind is read, write, change or value), or nothing to remove all watchpoints.");
+  if (watchpoint === null) {
+    return;
+  }
+  ws.send(watchpoint == "" ? "unwatch" : "watch", watchpoint);
+}
+
+function saveSnapshot() {
+  var name = prompt("Please enter the snapshot name (saved in the snapshot directory).");
+  if (name) {
+    ws.send("save-snapshot", name);
+  }
+}
+
+function loadSnapshot() {
+  var name = prompt("Please enter the snapshot name (from the snapshot directory).");
+  if (name) {
+    ws.send("load-snapshot", name);
+  }
+}
+
 function ready() {
   enableButton("load");
 }
@@ -316,11 +448,8 @@ function keyboardInput(e) {
 }
 
 function output(text) {
-  if (text.Content[0] == '\r') {
-    text.Content = "\n";
-  }
   var old = $("#terminal textarea").val();
-  $("#terminal textarea").val(old + text.Content);
+  $("#terminal textarea").val(old + text.Content.replace(/\r/g, "\n"));
 }
 
 function error(error) {
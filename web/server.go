@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Generic Message
@@ -28,14 +29,19 @@ type Server struct {
 	Halt     chan bool
 	Finished chan bool
 	Log      *log.Logger
-	Keyboard chan byte
-	Console  chan byte
+	Keyboard *armsim.Keyboard // Optional: input is dropped without one
+	Console  *armsim.Console  // Optional: no output is sent without one
+
+	// The directory snapshots are saved to and loaded from (by name)
+	SnapshotDir string
 }
 
 var globalServer Server
 
 func (s *Server) Serve(ws *websocket.Conn) {
-	go s.SendConsoleOutput(ws)
+	if s.Console != nil {
+		go s.SendConsoleOutput(ws)
+	}
 	for {
 		var m Message
 
@@ -62,12 +68,24 @@ func (s *Server) Serve(ws *websocket.Conn) {
 		case "step": // Step the program
 			s.Computer.Step()
 			s.UpdateStatus(ws)
+			s.SendError(ws)
+			s.SendWatchHit(ws)
 		case "stop": // Stop the program while running
 			s.Stop(ws)
 		case "trace": // Enable/Disable tracing
 			s.Trace(m, ws)
 		case "system-trace": // Enable/Disable system tracing
 			s.SystemTrace(m, ws)
+		case "pipeline": // Enable/Disable the pipelined mode
+			s.Pipeline(m, ws)
+		case "watch": // Add a data watchpoint (kind:address[:size[:value]])
+			s.Watch(m, ws)
+		case "unwatch": // Remove a data watchpoint (or all of them if empty)
+			s.Unwatch(m, ws)
+		case "save-snapshot": // Save a snapshot to a file in SnapshotDir by name
+			s.SaveSnapshot(m, ws)
+		case "load-snapshot": // Restore a snapshot from a file in SnapshotDir by name
+			s.LoadSnapshot(m, ws)
 		case "input":
 			s.Input(m, ws)
 		case "quit": // Quit connection
@@ -131,6 +149,24 @@ func (s *Server) Start(ws *websocket.Conn) {
 	s.UpdateStatus(ws)
 	m = Message{"status", "finished"}
 	m.Send(ws)
+	s.SendError(ws)
+	s.SendWatchHit(ws)
+}
+
+// Sends the error that halted the computer (if any)
+func (s *Server) SendError(ws *websocket.Conn) {
+	if err := s.Computer.Err(); err != nil {
+		m := Message{"error", err.Error()}
+		m.Send(ws)
+	}
+}
+
+// Sends the watchpoint hit that stopped the computer (if any)
+func (s *Server) SendWatchHit(ws *websocket.Conn) {
+	if hit := s.Computer.WatchHit(); hit != nil {
+		m := Message{"watchpoint", hit.String()}
+		m.Send(ws)
+	}
 }
 
 func (s *Server) Stop(ws *websocket.Conn) {
@@ -162,6 +198,99 @@ func (s *Server) SystemTrace(m Message, ws *websocket.Conn) {
 	}
 }
 
+func (s *Server) Pipeline(m Message, ws *websocket.Conn) {
+	if m.Content == "on" {
+		s.Computer.EnablePipeline()
+	} else {
+		s.Computer.DisablePipeline()
+	}
+	s.UpdateStatus(ws)
+}
+
+func (s *Server) Watch(m Message, ws *websocket.Conn) {
+	w, err := armsim.ParseWatchpoint(m.Content)
+	if err == nil {
+		err = s.Computer.AddWatchpoint(w)
+	}
+	if err != nil {
+		m := Message{"error", err.Error()}
+		m.Send(ws)
+	}
+	s.UpdateStatus(ws)
+}
+
+func (s *Server) Unwatch(m Message, ws *websocket.Conn) {
+	if m.Content == "" {
+		s.Computer.ClearWatchpoints()
+		s.UpdateStatus(ws)
+		return
+	}
+
+	w, err := armsim.ParseWatchpoint(m.Content)
+	if err == nil {
+		err = s.Computer.RemoveWatchpoint(w)
+	}
+	if err != nil {
+		m := Message{"error", err.Error()}
+		m.Send(ws)
+	}
+	s.UpdateStatus(ws)
+}
+
+func (s *Server) SaveSnapshot(m Message, ws *websocket.Conn) {
+	path, err := s.snapshotPath(m.Content)
+	if err == nil && s.SnapshotDir != "" {
+		err = os.MkdirAll(s.SnapshotDir, 0755)
+	}
+	var file *os.File
+	if err == nil {
+		file, err = os.Create(path)
+	}
+	if err == nil {
+		err = s.Computer.SaveSnapshot(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		m = Message{"error", fmt.Sprintf("Unable to save snapshot to %s: %s", m.Content, err)}
+	} else {
+		m = Message{"status", "snapshot-saved"}
+	}
+	m.Send(ws)
+}
+
+func (s *Server) LoadSnapshot(m Message, ws *websocket.Conn) {
+	path, err := s.snapshotPath(m.Content)
+	var file *os.File
+	if err == nil {
+		file, err = os.Open(path)
+	}
+	if err == nil {
+		err = s.Computer.LoadSnapshot(file)
+		file.Close()
+	}
+
+	if err != nil {
+		m = Message{"error", fmt.Sprintf("Unable to load snapshot %s: %s", m.Content, err)}
+	} else {
+		m = Message{"status", "loaded"}
+	}
+	m.Send(ws)
+	s.UpdateStatus(ws)
+}
+
+// Returns the path of a snapshot in SnapshotDir. Names can't contain path
+// separators or "..", so a websocket client can't read or write files
+// elsewhere.
+func (s *Server) snapshotPath(name string) (path string, err error) {
+	if name == "" || name == "." || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("Invalid snapshot name %q (names can't contain path separators or \"..\").", name)
+	}
+	return filepath.Join(s.SnapshotDir, name), nil
+}
+
 func (s *Server) Quit(ws *websocket.Conn) {
 }
 
@@ -172,16 +301,17 @@ func (s *Server) UpdateStatus(ws *websocket.Conn) {
 }
 
 func (s *Server) SendConsoleOutput(ws *websocket.Conn) {
-	var b byte
-	for {
-		b = <-s.Console
-		m := Message{"output", string(b)}
+	for _ = range s.Console.Ready() {
+		m := Message{"output", string(s.Console.Read())}
 		m.Send(ws)
 	}
 }
 
 func (s *Server) Input(m Message, ws *websocket.Conn) {
-	s.Keyboard <- m.Content[0]
+	if s.Keyboard == nil || m.Content == "" {
+		return
+	}
+	s.Keyboard.Press(m.Content[0])
 	if len(s.Computer.Irq) < 1 {
 		s.Computer.Irq <- true
 	}
@@ -195,13 +325,27 @@ func (s *Server) Launch(logOut io.Writer) {
 	globalServer.Log.Println(asset_path)
 
 	http.Handle("/", http.FileServer(http.Dir(asset_path)))
-	http.Handle("/ws", websocket.Handler(wsHandler))
+	http.Handle("/ws", globalServer.websocketServer())
 
 	if err := http.ListenAndServe("localhost:4567", nil); err != nil {
 		panic("ListenAndServe: " + err.Error())
 	}
 }
 
-func wsHandler(ws *websocket.Conn) {
-	globalServer.Serve(ws)
+// Returns the websocket handler, which only accepts connections from pages
+// served by this server (see checkOrigin).
+func (s *Server) websocketServer() websocket.Server {
+	return websocket.Server{Handshake: checkOrigin, Handler: s.Serve}
+}
+
+// Rejects a websocket handshake unless its Origin is the server itself, so
+// other web pages open in the browser can't control the simulator.
+func checkOrigin(config *websocket.Config, req *http.Request) (err error) {
+	if config.Origin, err = websocket.Origin(config, req); err != nil {
+		return
+	}
+	if config.Origin == nil || config.Origin.Host != req.Host {
+		return fmt.Errorf("Rejected websocket connection from origin %v.", config.Origin)
+	}
+	return
 }
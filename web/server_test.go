@@ -5,9 +5,17 @@
 package web
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/lseelenbinder/armsim/armsim"
 	"golang.org/x/net/websocket"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,3 +56,242 @@ func TestServer(t *testing.T) {
 		t.Fatal("Did not load index.html. " + resp.Status)
 	}
 }
+
+// Starts a Server for a new computer on a test HTTP server and connects to it.
+// Snapshots are saved in a temporary directory. The returned function closes
+// the connection and the HTTP server and removes the directory.
+func dialTestServer(t *testing.T) (s *Server, ws *websocket.Conn, done func()) {
+	dir, err := ioutil.TempDir("", "armsim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := armsim.NewComputer(32768, ioutil.Discard)
+	c.DisableTracing()
+	s = &Server{Computer: c, Halt: make(chan bool, 1), Finished: make(chan bool, 1),
+		Log: log.New(ioutil.Discard, "", 0), Keyboard: c.Keyboard, Console: c.Console,
+		SnapshotDir: filepath.Join(dir, "snapshots")}
+
+	server := httptest.NewServer(s.websocketServer())
+	ws, err = websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		server.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return s, ws, func() {
+		ws.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Receives messages until one of the given type arrives.
+func receive(t *testing.T, ws *websocket.Conn, messageType string) (m Message) {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			t.Fatalf("expected a %s message, got %s", messageType, err)
+		}
+		if m.Type == messageType {
+			return
+		}
+	}
+}
+
+// Receives messages until a status update arrives and decodes it.
+func receiveStatus(t *testing.T, ws *websocket.Conn) (status armsim.ComputerStatus) {
+	m := receive(t, ws, "update")
+	if err := json.Unmarshal([]byte(m.Content), &status); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestPipelineCommand(t *testing.T) {
+	s, ws, done := dialTestServer(t)
+	defer done()
+
+	m := Message{"pipeline", "on"}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); !status.Pipelined {
+		t.Fatal("expected pipelined mode to be reported")
+	}
+	if !s.Computer.Pipelined() {
+		t.Fatal("expected pipelined mode to be enabled")
+	}
+
+	// A step only fetches while the pipeline fills
+	m = Message{"step", ""}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); !status.Pipeline.Fetch.Valid || status.Pipeline.Execute.Valid {
+		t.Fatalf("expected a fetch into an empty pipeline, got %+v", status.Pipeline)
+	}
+
+	m = Message{"pipeline", "off"}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); status.Pipelined || status.Pipeline.Fetch.Valid {
+		t.Fatalf("expected an empty pipeline once disabled, got %+v", status.Pipeline)
+	}
+}
+
+func TestWatchCommands(t *testing.T) {
+	s, ws, done := dialTestServer(t)
+	defer done()
+
+	m := Message{"load", "../test_files/ldmstm.exe"}
+	m.Send(ws)
+	sp := receiveStatus(t, ws).Registers[13]
+
+	// The first stmfd writes r4 just below the stack pointer
+	watchpoint := fmt.Sprintf("write:%#x:4", sp-4)
+	m = Message{"watch", watchpoint}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); len(status.Watchpoints) != 1 {
+		t.Fatalf("expected a watchpoint, got %+v", status.Watchpoints)
+	}
+	m = Message{"watch", "poke:0x200"}
+	m.Send(ws)
+	if m = receive(t, ws, "error"); !strings.Contains(m.Content, "poke") {
+		t.Fatal("expected an error for an invalid watchpoint, got", m.Content)
+	}
+
+	m = Message{"start", ""}
+	m.Send(ws)
+	if m = receive(t, ws, "watchpoint"); !strings.Contains(m.Content, "wrote 0x00000004") {
+		t.Fatal("unexpected watchpoint report", m.Content)
+	}
+
+	// Removing a watchpoint, then all of them
+	m = Message{"watch", "read:0x200"}
+	m.Send(ws)
+	receiveStatus(t, ws)
+	m = Message{"unwatch", watchpoint}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); len(status.Watchpoints) != 1 {
+		t.Fatalf("expected one watchpoint left, got %+v", status.Watchpoints)
+	}
+	m = Message{"unwatch", ""}
+	m.Send(ws)
+	if status := receiveStatus(t, ws); len(status.Watchpoints) != 0 {
+		t.Fatalf("expected no watchpoints, got %+v", status.Watchpoints)
+	}
+
+	// With the watchpoints gone the program runs to the end
+	m = Message{"start", ""}
+	m.Send(ws)
+	for m.Content != "finished" {
+		m = receive(t, ws, "status")
+	}
+	if hit := s.Computer.WatchHit(); hit != nil {
+		t.Fatal("expected no watchpoint hit, got", hit)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	s, ws, done := dialTestServer(t)
+	defer done()
+
+	server := httptest.NewServer(s.websocketServer())
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	for _, origin := range []string{"http://example.com/", "http://localhost:4567/"} {
+		if other, err := websocket.Dial(url, "", origin); err == nil {
+			other.Close()
+			t.Errorf("%s: expected the connection to be rejected", origin)
+		}
+	}
+
+	// The test server's own origin is accepted
+	m := Message{"hello", ""}
+	m.Send(ws)
+	if m = receive(t, ws, "status"); m.Content != "ready" {
+		t.Fatal("expected ready, got", m.Content)
+	}
+}
+
+func TestSnapshotCommands(t *testing.T) {
+	s, ws, done := dialTestServer(t)
+	defer done()
+
+	m := Message{"load", "../test_files/ldmstm.exe"}
+	m.Send(ws)
+	receiveStatus(t, ws)
+	for i := 0; i < 4; i++ {
+		m = Message{"step", ""}
+		m.Send(ws)
+		receiveStatus(t, ws)
+	}
+
+	m = Message{"save-snapshot", "ldmstm.snap"}
+	m.Send(ws)
+	if m = receive(t, ws, "status"); m.Content != "snapshot-saved" {
+		t.Fatal("expected snapshot-saved, got", m.Content)
+	}
+	if _, err := os.Stat(filepath.Join(s.SnapshotDir, "ldmstm.snap")); err != nil {
+		t.Fatal("expected the snapshot in the snapshot directory:", err)
+	}
+	expected := s.Computer.Status()
+
+	// Step on, then restore the snapshot
+	for i := 0; i < 4; i++ {
+		m = Message{"step", ""}
+		m.Send(ws)
+		receiveStatus(t, ws)
+	}
+	m = Message{"load-snapshot", "ldmstm.snap"}
+	m.Send(ws)
+	if m = receive(t, ws, "status"); m.Content != "loaded" {
+		t.Fatal("expected loaded, got", m.Content)
+	}
+	status := receiveStatus(t, ws)
+	if status.Registers != expected.Registers || status.Steps != expected.Steps || status.Checksum != expected.Checksum {
+		t.Fatalf("expected the saved state:\n%+v\n%+v", status, expected)
+	}
+
+	// Names that could reach outside the snapshot directory are rejected
+	escape := filepath.Join(filepath.Dir(s.SnapshotDir), "escape.snap")
+	for _, name := range []string{"", ".", "..", "../escape.snap", "sub/escape.snap", `sub\escape.snap`, escape} {
+		for _, command := range []string{"save-snapshot", "load-snapshot"} {
+			m = Message{command, name}
+			m.Send(ws)
+			if m = receive(t, ws, "error"); !strings.Contains(m.Content, "Invalid snapshot name") {
+				t.Errorf("%s %q: expected an invalid name error, got %s", command, name, m.Content)
+			}
+		}
+	}
+	if _, err := os.Stat(escape); err == nil {
+		t.Fatal("expected no snapshot outside the snapshot directory")
+	}
+
+	m = Message{"load-snapshot", "missing.snap"}
+	m.Send(ws)
+	if m = receive(t, ws, "error"); !strings.Contains(m.Content, "missing.snap") {
+		t.Fatal("expected an error loading a missing snapshot, got", m.Content)
+	}
+}
+
+func TestServerWithoutDevices(t *testing.T) {
+	c := armsim.NewComputer(32768, ioutil.Discard)
+	c.DisableTracing()
+	s := &Server{Computer: c, Halt: make(chan bool, 1), Finished: make(chan bool, 1),
+		Log: log.New(ioutil.Discard, "", 0)}
+
+	server := httptest.NewServer(s.websocketServer())
+	defer server.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Input is dropped without a keyboard
+	m := Message{"input", "a"}
+	m.Send(ws)
+	m = Message{"hello", ""}
+	m.Send(ws)
+	if m = receive(t, ws, "status"); m.Content != "ready" {
+		t.Fatal("expected ready, got", m.Content)
+	}
+}